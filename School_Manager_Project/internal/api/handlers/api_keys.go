@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"restapi/internal/models"
+	"restapi/internal/policy"
+	"restapi/internal/rbac"
+	"restapi/internal/sqlconnect"
+	"restapi/utils"
+	"strconv"
+)
+
+func GetAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := sqlconnect.GetAPIKeysDBHandler(r.Context())
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Status string          `json:"status"`
+		Count  int             `json:"count"`
+		Data   []models.APIKey `json:"data"`
+	}{
+		Status: "success",
+		Count:  len(keys),
+		Data:   keys,
+	}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
+
+// PostAPIKeyHandler POST /apikeys/ - creates a key, the only response that contains it in full
+func PostAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.APIKeyRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, utils.InvalidRequestBodyError.Error(), utils.InvalidRequestBodyError.GetStatusCode())
+		return
+	}
+	r.Body.Close()
+
+	expiresAt, err := utils.ValidateAPIKeyRequest(request)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	// nobody can hand out more than they hold themselves
+	subject := policy.SubjectFromContext(r.Context())
+	for _, scope := range request.Scopes {
+		if !rbac.IsKnownPermission(scope) {
+			http.Error(w, utils.UnknownPermissionError.Error(), utils.UnknownPermissionError.GetStatusCode())
+			return
+		}
+		if !rbac.HasPermission(subject.Role, scope) {
+			http.Error(w, utils.PermissionDeniedError.Error(), utils.PermissionDeniedError.GetStatusCode())
+			return
+		}
+	}
+
+	apiKey, prefix, err := utils.GenerateAPIKey()
+	if err != nil {
+		http.Error(w, utils.ErrorGeneratingToken.Error(), utils.ErrorGeneratingToken.GetStatusCode())
+		return
+	}
+	key := models.APIKey{
+		Name:       request.Name,
+		Prefix:     prefix,
+		Scopes:     request.Scopes,
+		AllowedIPs: request.AllowedIPs,
+	}
+	if subject.UserID != 0 {
+		key.CreatedBy = sql.NullInt64{Int64: int64(subject.UserID), Valid: true}
+	}
+	addedKey, err := sqlconnect.AddAPIKeyDBHandler(r.Context(), key, utils.HashToken(apiKey), expiresAt)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	response := struct {
+		models.APIKey
+		Key string `json:"key"`
+	}{
+		APIKey: addedKey,
+		Key:    apiKey,
+	}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
+
+func RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, utils.InvalidIdError.Error(), utils.InvalidIdError.GetStatusCode())
+		return
+	}
+	err = sqlconnect.RevokeAPIKeyDBHandler(r.Context(), id)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Status string `json:"status"`
+		ID     int    `json:"id"`
+	}{
+		Status: "API key successfully revoked",
+		ID:     id,
+	}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
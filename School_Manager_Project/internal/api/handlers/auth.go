@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"restapi/internal/keyring"
+	"restapi/internal/models"
+	"restapi/internal/policy"
+	"restapi/internal/sqlconnect"
+	"restapi/utils"
+	"time"
+)
+
+type tokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	// browsers echo it in the X-CSRF-Token header on state-changing requests
+	CSRFToken string `json:"csrf_token"`
+	// set when the role requires MFA but the user hasn't enrolled yet
+	MFAEnrollmentRequired bool `json:"mfa_enrollment_required,omitempty"`
+}
+
+func setAccessCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     utils.AccessCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		Expires:  expires,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func setRefreshCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     utils.RefreshCookieName,
+		Value:    token,
+		Path:     "/auth",
+		HttpOnly: true,
+		Secure:   true,
+		Expires:  expires,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// setCSRFCookie is readable by the frontend, see middlewares.CSRFMiddleware
+func setCSRFCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     utils.CSRFCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: false,
+		Secure:   true,
+		Expires:  expires,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// setMFAChallengeCookie hands the challenge of a browser login to LoginMFAHandler without
+// exposing it to scripts
+func setMFAChallengeCookie(w http.ResponseWriter, challenge string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     utils.MFAChallengeCookieName,
+		Value:    challenge,
+		Path:     "/execs/login/mfa",
+		HttpOnly: true,
+		Secure:   true,
+		Expires:  expires,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// setDeviceCookie recognizes the browser on later logins, it is Lax so the SSO callback gets it too
+func setDeviceCookie(w http.ResponseWriter, deviceID string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     utils.DeviceCookieName,
+		Value:    deviceID,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		Expires:  time.Now().Add(365 * 24 * time.Hour),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearAuthCookies(w http.ResponseWriter) {
+	setAccessCookie(w, "", time.Unix(0, 0))
+	setRefreshCookie(w, "", time.Unix(0, 0))
+	setCSRFCookie(w, "", time.Unix(0, 0))
+}
+
+// issueTokens starts a new refresh token family for the user, records the login as a session
+// and sets both cookies. The session is stored first so no token is handed out that can't be
+// revoked through it.
+func issueTokens(w http.ResponseWriter, r *http.Request, user models.Exec) (tokenResponse, error) {
+	familyID, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	refreshToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	err = sqlconnect.CreateRefreshTokenDBHandler(r.Context(), user.ID, familyID, utils.HashToken(refreshToken))
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	claims, err := utils.AccessTokenClaims(user, familyID)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	err = recordSession(w, r, user, familyID, claims)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	return signAndSetCookies(w, claims, refreshToken)
+}
+
+// accessTokenID is the jti and the expiry of an access token, kept with its session
+func accessTokenID(claims jwt.MapClaims) (string, time.Time) {
+	jti, _ := claims["jti"].(string)
+	var expiresAt time.Time
+	if exp, ok := claims["exp"].(*jwt.NumericDate); ok {
+		expiresAt = exp.Time
+	}
+	return jti, expiresAt
+}
+
+func signAndSetCookies(w http.ResponseWriter, claims jwt.MapClaims, refreshToken string) (tokenResponse, error) {
+	ttl, err := utils.AccessTokenTTL()
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	token, err := keyring.Sign(claims)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	csrfToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	setAccessCookie(w, token, time.Now().Add(ttl))
+	setRefreshCookie(w, refreshToken, time.Now().Add(utils.RefreshTokenTTL()))
+	setCSRFCookie(w, csrfToken, time.Now().Add(utils.RefreshTokenTTL()))
+	return tokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(ttl.Seconds()),
+		CSRFToken:    csrfToken,
+	}, nil
+}
+
+// RefreshHandler POST /auth/refresh - rotates the refresh token and issues a new access token
+func RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	var refreshToken string
+	cookie, err := r.Cookie(utils.RefreshCookieName)
+	if err == nil {
+		refreshToken = cookie.Value
+	} else {
+		var req models.RefreshRequest
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, utils.InvalidRequestBodyError.Error(), utils.InvalidRequestBodyError.GetStatusCode())
+			return
+		}
+		refreshToken = req.RefreshToken
+	}
+	if refreshToken == "" {
+		http.Error(w, utils.MissingFieldsError.Error(), utils.MissingFieldsError.GetStatusCode())
+		return
+	}
+
+	newRefreshToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		http.Error(w, utils.ErrorGeneratingToken.Error(), utils.ErrorGeneratingToken.GetStatusCode())
+		return
+	}
+	user, familyID, err := sqlconnect.RotateRefreshTokenDBHandler(r.Context(), utils.HashToken(refreshToken), utils.HashToken(newRefreshToken))
+	if err != nil {
+		clearAuthCookies(w)
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+
+	claims, err := utils.AccessTokenClaims(user, familyID)
+	if err != nil {
+		http.Error(w, utils.ErrorGeneratingJwtToken.Error(), utils.ErrorGeneratingJwtToken.GetStatusCode())
+		return
+	}
+	// the session learns the new token before it is handed out, so revoking it denies the token
+	tokenID, tokenExpiresAt := accessTokenID(claims)
+	err = sqlconnect.TouchSessionDBHandler(r.Context(), familyID, utils.ClientIP(r), tokenID, tokenExpiresAt)
+	if err != nil {
+		// a token the session doesn't know about couldn't be revoked with it, so none is handed out
+		clearAuthCookies(w)
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	response, err := signAndSetCookies(w, claims, newRefreshToken)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
+
+// GetTokenFamiliesHandler GET /auth/families - active logins of the current user
+func GetTokenFamiliesHandler(w http.ResponseWriter, r *http.Request) {
+	subject := policy.SubjectFromContext(r.Context())
+	families, err := sqlconnect.GetTokenFamiliesDBHandler(r.Context(), subject.UserID)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	response := struct {
+		Status string               `json:"status"`
+		Count  int                  `json:"count"`
+		Data   []models.TokenFamily `json:"data"`
+	}{
+		Status: "success",
+		Count:  len(families),
+		Data:   families,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
+
+// DeleteTokenFamilyHandler DELETE /auth/families/{familyId} - revokes one login of the current user
+func DeleteTokenFamilyHandler(w http.ResponseWriter, r *http.Request) {
+	subject := policy.SubjectFromContext(r.Context())
+	familyID := r.PathValue("familyId")
+	err := sqlconnect.RevokeSessionDBHandler(r.Context(), subject.UserID, familyID)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutEverywhereHandler POST /auth/logout-all - invalidates every token of the current user
+func LogoutEverywhereHandler(w http.ResponseWriter, r *http.Request) {
+	subject := policy.SubjectFromContext(r.Context())
+	err := sqlconnect.RevokeAllSessionsDBHandler(r.Context(), subject.UserID)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	clearAuthCookies(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"message": "Logged out from all devices"}`))
+}
+
+// JWKSHandler GET /.well-known/jwks.json - public keys other services can verify our access tokens with
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	response := struct {
+		Keys []keyring.JWK `json:"keys"`
+	}{
+		Keys: keyring.JWKS(),
+	}
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
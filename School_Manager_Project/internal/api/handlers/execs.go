@@ -2,9 +2,15 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"restapi/internal/mailer"
+	"restapi/internal/metrics"
 	"restapi/internal/models"
+	"restapi/internal/policy"
+	"restapi/internal/rbac"
 	"restapi/internal/sqlconnect"
 	"restapi/utils"
 	"strconv"
@@ -19,7 +25,7 @@ func GetOneExecHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	exec, err := sqlconnect.GetExecByID(realID)
+	exec, err := sqlconnect.GetExecByID(r.Context(), realID)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -75,7 +81,6 @@ func PostExecsHandler(w http.ResponseWriter, r *http.Request) {
 	err = utils.ValidateExecPost(newExecs)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
-			log.Println("ERROR 1:", err)
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
 			return
 		}
@@ -83,10 +88,35 @@ func PostExecsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	addedExecs, err := sqlconnect.AddExecsDBHandler(newExecs)
+	for _, exec := range newExecs {
+		if !rbac.RoleExists(exec.Role) {
+			http.Error(w, utils.InvalidRoleError.Error(), utils.InvalidRoleError.GetStatusCode())
+			return
+		}
+	}
+
+	err = utils.RejectExecPasswords(newExecs)
+	if fieldErrs, ok := err.(utils.FieldErrors); ok {
+		writeFieldErrors(w, fieldErrs)
+		return
+	}
+
+	// every exec gets an invite to pick a password, the signed link carries a nonce stored hashed
+	nonces := make([]string, len(newExecs))
+	tokenHashes := make([]string, len(newExecs))
+	for i := range newExecs {
+		nonces[i], err = utils.GenerateRandomToken(32)
+		if err != nil {
+			http.Error(w, utils.ErrorGeneratingToken.Error(), utils.ErrorGeneratingToken.GetStatusCode())
+			return
+		}
+		tokenHashes[i] = utils.HashToken(nonces[i])
+	}
+	expiresAt := time.Now().Add(utils.InviteTTL())
+
+	addedExecs, err := sqlconnect.AddExecsDBHandler(r.Context(), newExecs, policy.SubjectFromContext(r.Context()).UserID, tokenHashes, expiresAt)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
-			log.Println("ERROR 2:", err)
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
 			return
 		}
@@ -94,20 +124,29 @@ func PostExecsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	for i, exec := range addedExecs {
+		err = sendInvite(exec, nonces[i], expiresAt)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "sending invite failed", "exec_id", exec.ID, "err", err)
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	response := struct {
-		Status string        `json:"status"`
-		Count  int           `json:"count"`
-		Data   []models.Exec `json:"data"`
+		Status          string        `json:"status"`
+		Count           int           `json:"count"`
+		Data            []models.Exec `json:"data"`
+		InviteExpiresAt string        `json:"invite_expires_at"`
 	}{
-		Status: "success",
-		Count:  len(addedExecs),
-		Data:   addedExecs,
+		Status:          "success",
+		Count:           len(addedExecs),
+		Data:            addedExecs,
+		InviteExpiresAt: expiresAt.UTC().Format(time.RFC3339),
 	}
 	err = json.NewEncoder(w).Encode(response)
 	if err != nil {
-		log.Println("ERROR 3:", err)
+		slog.ErrorContext(r.Context(), "encoding response failed", "err", err)
 		http.Error(w, "Error encoding error", http.StatusInternalServerError)
 		return
 	}
@@ -121,7 +160,7 @@ func PatchExecsHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "error decoding data", http.StatusBadRequest)
 		return
 	}
-	err = sqlconnect.PatchExecsDBHandler(updates)
+	err = sqlconnect.PatchExecsDBHandler(r.Context(), updates)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -149,7 +188,7 @@ func PatchOneExecHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	existingExec, err := sqlconnect.PatchOneExecDBHandler(id, updates)
+	existingExec, err := sqlconnect.PatchOneExecDBHandler(r.Context(), id, updates)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -169,7 +208,7 @@ func DeleteOneExecHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, utils.InvalidIdError.Error(), utils.InvalidIdError.GetStatusCode())
 		return
 	}
-	err = sqlconnect.DeleteOneExecDBHandler(id)
+	err = sqlconnect.DeleteOneExecDBHandler(r.Context(), id)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -208,9 +247,18 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// too many failed attempts for this username or from this client
+	if loginLocked(w, r, "password", req.Username) {
+		return
+	}
+
 	// search for user if user actually exists
-	user, err := sqlconnect.LoginDBHandler(req.Username)
-	if err != nil {
+	user, err := sqlconnect.LoginDBHandler(r.Context(), req.Username)
+	if err == utils.InvalidCredentialsError {
+		verifyDummyPassword(req.Password)
+		loginFailed(w, r, "password", req.Username, utils.InvalidCredentialsError)
+		return
+	} else if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
 			return
@@ -219,62 +267,104 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// verify password, an unknown username and a wrong password get the same answer
+	_, err = utils.VerifyPassword(user.Password, req.Password)
+	if err == utils.IncorrectPasswordError {
+		loginFailed(w, r, "password", req.Username, utils.InvalidCredentialsError)
+		return
+	} else if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, "unknown internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// hashes made with older or weaker argon2 parameters are upgraded while the plain password is at hand
+	if utils.NeedsRehash(user.Password) {
+		newHash, err := utils.Hash(req.Password)
+		if err == nil {
+			err = sqlconnect.RehashPasswordDBHandler(r.Context(), user.ID, user.Password, newHash)
+		}
+		if err != nil {
+			slog.WarnContext(r.Context(), "rehashing password failed", "err", err)
+		}
+	}
+
 	// is user active
 	if user.InactiveStatus {
 		http.Error(w, utils.AccountInactiveError.Error(), utils.AccountInactiveError.GetStatusCode())
 		return
 	}
 
-	// verify password
-	_, err = utils.VerifyPassword(user.Password, req.Password)
-	if err != nil {
-		if appErr, ok := err.(*utils.AppErrors); ok {
-			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+	// with MFA enabled the password only earns a challenge, the cookie is set by LoginMFAHandler
+	if user.MfaEnabled {
+		challenge, err := utils.SignMFAChallenge(user.ID)
+		if err != nil {
+			http.Error(w, utils.ErrorGeneratingJwtToken.Error(), utils.ErrorGeneratingJwtToken.GetStatusCode())
 			return
 		}
-		http.Error(w, "unknown internal server error", http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			MFARequired bool   `json:"mfa_required"`
+			MFAToken    string `json:"mfa_token"`
+		}{
+			MFARequired: true,
+			MFAToken:    challenge,
+		}
+		json.NewEncoder(w).Encode(response)
 		return
 	}
 
-	// generate token
-	token, err := utils.SignToken(user.ID, req.Username, user.Role)
+	// the failed attempts are only cleared once the login is complete, a right password doesn't
+	// reset the backoff between second factor guesses
+	loginSucceeded(r, req.Username)
+
+	// generate a short-lived access token and a refresh token, sent as cookies and in the response
+	response, err := issueTokens(w, r, user)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
 			return
 		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
 	}
 
-	// send token as a response or as a cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "Bearer",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		Expires:  time.Now().Add(24 * time.Hour),
-		SameSite: http.SameSiteStrictMode,
-	})
+	metrics.Logins.WithLabelValues("password", "success").Inc()
+	response.MFAEnrollmentRequired = rbac.MFARequired(user.Role)
 
 	w.Header().Set("Content-Type", "application/json")
-	response := struct {
-		Token string `json:"token"`
-	}{
-		Token: token,
-	}
 	json.NewEncoder(w).Encode(response)
 }
 
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     "Bearer",
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		Expires:  time.Unix(0, 0),
-		SameSite: http.SameSiteStrictMode,
-	})
+	subject := policy.SubjectFromContext(r.Context())
+	jti, _ := r.Context().Value("jti").(string)
+	if jti != "" {
+		expiresAt := time.Now().Add(24 * time.Hour)
+		if exp, ok := r.Context().Value("expiresAt").(float64); ok {
+			expiresAt = time.Unix(int64(exp), 0)
+		}
+		err := sqlconnect.RevokeAccessTokenDBHandler(r.Context(), subject.UserID, jti, expiresAt)
+		if err != nil {
+			if appErr, ok := err.(*utils.AppErrors); ok {
+				http.Error(w, appErr.Error(), appErr.GetStatusCode())
+				return
+			}
+			http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+			return
+		}
+	}
+	if familyID, _ := r.Context().Value("familyId").(string); familyID != "" {
+		err := sqlconnect.RevokeSessionDBHandler(r.Context(), subject.UserID, familyID)
+		if err != nil && err != utils.UnitNotFoundError {
+			http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+			return
+		}
+	}
+	clearAuthCookies(w)
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(`{"message": "Logged out successfully"}`))
 }
@@ -286,6 +376,12 @@ func UpdatePasswordHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, utils.InvalidIdError.Error(), utils.InvalidIdError.GetStatusCode())
 		return
 	}
+	// execs can only change their own password
+	err = policy.CheckOwner(policy.SubjectFromContext(r.Context()), policy.ExecPasswords, userId)
+	if err != nil {
+		http.Error(w, utils.PermissionDeniedError.Error(), utils.PermissionDeniedError.GetStatusCode())
+		return
+	}
 	var request models.UpdatePasswordRequest
 	err = json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
@@ -304,7 +400,21 @@ func UpdatePasswordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := sqlconnect.UpdatePasswordInDB(userId, request.NewPassword, request.CurrentPassword)
+	user, err := sqlconnect.UpdatePasswordInDB(r.Context(), userId, request.NewPassword, request.CurrentPassword)
+	if err != nil {
+		if fieldErrs, ok := err.(utils.FieldErrors); ok {
+			writeFieldErrors(w, fieldErrs)
+			return
+		}
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	// every other session was logged out, this one continues with a new login
+	_, err = issueTokens(w, r, user)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -313,15 +423,6 @@ func UpdatePasswordHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
 		return
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:     "Bearer",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		Expires:  time.Now().Add(24 * time.Hour),
-		SameSite: http.SameSiteStrictMode,
-	})
 
 	w.Header().Set("Content-Type", "application/json")
 	response := struct {
@@ -331,3 +432,139 @@ func UpdatePasswordHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	json.NewEncoder(w).Encode(response)
 }
+
+// RevokeExecSessionsHandler POST /execs/{id}/revokeSessions - logs another exec out of every device
+func RevokeExecSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, utils.InvalidIdError.Error(), utils.InvalidIdError.GetStatusCode())
+		return
+	}
+	err = sqlconnect.RevokeAllSessionsDBHandler(r.Context(), id)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Status string `json:"status"`
+		ID     int    `json:"id"`
+	}{
+		Status: "Exec sessions successfully revoked",
+		ID:     id,
+	}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
+
+// ForgotPasswordHandler POST /execs/forgotPassword - mails a reset code. The response is the same
+// whether or not the email belongs to an exec.
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.ForgotPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.Email == "" {
+		http.Error(w, utils.InvalidRequestBodyError.Error(), utils.InvalidRequestBodyError.GetStatusCode())
+		return
+	}
+	r.Body.Close()
+
+	resetCode, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		http.Error(w, utils.ErrorGeneratingToken.Error(), utils.ErrorGeneratingToken.GetStatusCode())
+		return
+	}
+	ttl := utils.PasswordResetTTL()
+	exec, err := sqlconnect.SetPasswordResetTokenDBHandler(r.Context(), request.Email, utils.HashToken(resetCode), time.Now().Add(ttl))
+	if err == nil {
+		mailer.SendAsync(mailer.Message{
+			To:      exec.Email,
+			Subject: "Your password reset code",
+			Body: fmt.Sprintf("Hi %s,\n\nuse the link below to reset your password, it is valid for %d minutes:\n%s%s\n\nIf you didn't request a reset you can ignore this email.",
+				exec.FirstName, int(ttl.Minutes()), resetPasswordURL(), resetCode),
+		})
+	} else if err != utils.UnitNotFoundError {
+		slog.ErrorContext(r.Context(), "forgot password failed", "err", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Message string `json:"message"`
+	}{
+		Message: "If the email belongs to an account, a password reset link has been sent",
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// ResetPasswordHandler POST /execs/resetPassword/reset/{resetCode}
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	resetCode := r.PathValue("resetCode")
+	var request models.ResetPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, utils.InvalidRequestBodyError.Error(), utils.InvalidRequestBodyError.GetStatusCode())
+		return
+	}
+	r.Body.Close()
+
+	err = utils.ValidatePasswordReset(request)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+
+	err = sqlconnect.ResetPasswordDBHandler(r.Context(), utils.HashToken(resetCode), request.NewPassword)
+	if err != nil {
+		if fieldErrs, ok := err.(utils.FieldErrors); ok {
+			writeFieldErrors(w, fieldErrs)
+			return
+		}
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Message string `json:"message"`
+	}{
+		Message: "Password reset successfully",
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeFieldErrors answers with the rules the request broke, one entry per field
+func writeFieldErrors(w http.ResponseWriter, fieldErrs utils.FieldErrors) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(fieldErrs.GetStatusCode())
+	response := struct {
+		Status string             `json:"status"`
+		Error  string             `json:"error"`
+		Fields []utils.FieldError `json:"fields"`
+	}{
+		Status: "error",
+		Error:  "validation failed",
+		Fields: fieldErrs,
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func resetPasswordURL() string {
+	if url := os.Getenv("RESET_PASSWORD_URL"); url != "" {
+		return url
+	}
+	return "https://localhost" + os.Getenv("API_PORT") + "/execs/resetPassword/reset/"
+}
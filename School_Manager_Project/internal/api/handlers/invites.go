@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"restapi/internal/mailer"
+	"restapi/internal/models"
+	"restapi/internal/policy"
+	"restapi/internal/sqlconnect"
+	"restapi/utils"
+	"strconv"
+	"time"
+)
+
+func inviteURL() string {
+	if url := os.Getenv("INVITE_URL"); url != "" {
+		return url
+	}
+	return "https://localhost" + os.Getenv("API_PORT") + "/execs/invite/accept/"
+}
+
+// sendInvite mails the signed link an invited exec picks their password with
+func sendInvite(exec models.Exec, nonce string, expiresAt time.Time) error {
+	token, err := utils.SignInviteToken(exec.ID, nonce, expiresAt)
+	if err != nil {
+		return err
+	}
+	mailer.SendAsync(mailer.Message{
+		To:      exec.Email,
+		Subject: "You have been invited to School Manager",
+		Body: fmt.Sprintf("Hi %s,\n\nan account with the role %q has been created for you. Use the link below to choose your password, it is valid until %s:\n%s%s\n\nIf you weren't expecting this email you can ignore it.",
+			exec.FirstName, exec.Role, expiresAt.UTC().Format("2006-01-02 15:04 UTC"), inviteURL(), token),
+	})
+	return nil
+}
+
+// GetInviteHandler GET /execs/{id}/invite - status of the invite of an exec
+func GetInviteHandler(w http.ResponseWriter, r *http.Request) {
+	execID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, utils.InvalidIdError.Error(), utils.InvalidIdError.GetStatusCode())
+		return
+	}
+
+	invite, err := sqlconnect.GetInviteDBHandler(r.Context(), execID)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invite)
+}
+
+// ResendInviteHandler POST /execs/{id}/invite - mails a new link, the previous one stops working
+func ResendInviteHandler(w http.ResponseWriter, r *http.Request) {
+	execID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, utils.InvalidIdError.Error(), utils.InvalidIdError.GetStatusCode())
+		return
+	}
+
+	nonce, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		http.Error(w, utils.ErrorGeneratingToken.Error(), utils.ErrorGeneratingToken.GetStatusCode())
+		return
+	}
+	expiresAt := time.Now().Add(utils.InviteTTL())
+	exec, err := sqlconnect.ResendInviteDBHandler(r.Context(), execID, policy.SubjectFromContext(r.Context()).UserID, utils.HashToken(nonce), expiresAt)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	err = sendInvite(exec, nonce, expiresAt)
+	if err != nil {
+		http.Error(w, utils.ErrorGeneratingJwtToken.Error(), utils.ErrorGeneratingJwtToken.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Message   string `json:"message"`
+		ExpiresAt string `json:"expires_at"`
+	}{
+		Message:   "Invite sent",
+		ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// RevokeInviteHandler DELETE /execs/{id}/invite - the exec stays inactive
+func RevokeInviteHandler(w http.ResponseWriter, r *http.Request) {
+	execID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, utils.InvalidIdError.Error(), utils.InvalidIdError.GetStatusCode())
+		return
+	}
+
+	err = sqlconnect.RevokeInviteDBHandler(r.Context(), execID)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptInviteHandler POST /execs/invite/accept/{token} - the invitee picks a password
+func AcceptInviteHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.ResetPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, utils.InvalidRequestBodyError.Error(), utils.InvalidRequestBodyError.GetStatusCode())
+		return
+	}
+	r.Body.Close()
+
+	err = utils.ValidatePasswordReset(request)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+
+	execID, nonce, err := utils.ParseInviteToken(r.PathValue("token"))
+	if err != nil {
+		http.Error(w, utils.InvalidInviteError.Error(), utils.InvalidInviteError.GetStatusCode())
+		return
+	}
+	err = sqlconnect.AcceptInviteDBHandler(r.Context(), execID, utils.HashToken(nonce), request.NewPassword)
+	if err != nil {
+		if fieldErrs, ok := err.(utils.FieldErrors); ok {
+			writeFieldErrors(w, fieldErrs)
+			return
+		}
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Message string `json:"message"`
+	}{
+		Message: "Invite accepted, you can log in now",
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"restapi/internal/metrics"
+	"restapi/internal/models"
+	"restapi/internal/policy"
+	"restapi/internal/sqlconnect"
+	"restapi/utils"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// verifyDummyPassword spends the same time as a real password check, so unknown
+// usernames can't be told apart by how long the login takes
+func verifyDummyPassword(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = utils.Hash("not-a-real-password")
+	})
+	utils.VerifyPassword(dummyHash, password)
+}
+
+// loginLocked answers with 429 and returns true if logins for the username or from the client are
+// locked. method is password or mfa, for the login metrics.
+func loginLocked(w http.ResponseWriter, r *http.Request, method, username string) bool {
+	until, err := sqlconnect.GetLoginLockDBHandler(r.Context(), username, utils.ClientIP(r))
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return true
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return true
+	}
+	if until.IsZero() {
+		return false
+	}
+	metrics.Logins.WithLabelValues(method, "locked").Inc()
+	writeTooManyAttempts(w, until)
+	return true
+}
+
+// loginFailed records the failed attempt and answers with the given error, or with 429 if
+// the attempt caused a lockout
+func loginFailed(w http.ResponseWriter, r *http.Request, method, username string, loginErr *utils.AppErrors) {
+	metrics.Logins.WithLabelValues(method, "failure").Inc()
+	until, err := sqlconnect.RecordLoginFailureDBHandler(r.Context(), username, utils.ClientIP(r))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "recording failed login failed", "err", err)
+	}
+	if !until.IsZero() {
+		writeTooManyAttempts(w, until)
+		return
+	}
+	http.Error(w, loginErr.Error(), loginErr.GetStatusCode())
+}
+
+func loginSucceeded(r *http.Request, username string) {
+	err := sqlconnect.ClearLoginFailuresDBHandler(r.Context(), username)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "clearing failed logins failed", "err", err)
+	}
+}
+
+func writeTooManyAttempts(w http.ResponseWriter, until time.Time) {
+	retryAfter := int(math.Ceil(time.Until(until).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	http.Error(w, utils.TooManyLoginAttemptsError.Error(), utils.TooManyLoginAttemptsError.GetStatusCode())
+}
+
+// UnlockExecHandler POST /execs/{id}/unlock - lifts the lockout of an exec after too many failed logins
+func UnlockExecHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, utils.InvalidIdError.Error(), utils.InvalidIdError.GetStatusCode())
+		return
+	}
+	subject := policy.SubjectFromContext(r.Context())
+	err = sqlconnect.UnlockExecDBHandler(r.Context(), id, subject.UserID)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Status string `json:"status"`
+		ID     int    `json:"id"`
+	}{
+		Status: "Exec successfully unlocked",
+		ID:     id,
+	}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
+
+// UnlockIPHandler DELETE /lockouts/ip/{ip} - lifts the lockout of a client IP
+func UnlockIPHandler(w http.ResponseWriter, r *http.Request) {
+	ip := r.PathValue("ip")
+	subject := policy.SubjectFromContext(r.Context())
+	err := sqlconnect.UnlockIPDBHandler(r.Context(), ip, subject.UserID)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Status string `json:"status"`
+		IP     string `json:"ip"`
+	}{
+		Status: "IP successfully unlocked",
+		IP:     ip,
+	}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
+
+// GetLockoutEventsHandler GET /lockouts/events?limit=50 - latest lockouts and unlocks
+func GetLockoutEventsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 500 {
+			http.Error(w, utils.InvalidLimitError.Error(), utils.InvalidLimitError.GetStatusCode())
+			return
+		}
+		limit = parsed
+	}
+	events, err := sqlconnect.GetLockoutEventsDBHandler(r.Context(), limit)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Status string                `json:"status"`
+		Count  int                   `json:"count"`
+		Data   []models.LockoutEvent `json:"data"`
+	}{
+		Status: "success",
+		Count:  len(events),
+		Data:   events,
+	}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
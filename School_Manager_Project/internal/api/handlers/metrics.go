@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"restapi/internal/metrics"
+	"restapi/utils"
+	"strings"
+	"sync"
+)
+
+// metricsAllowedIPs reads METRICS_ALLOWED_IPS, addresses and CIDR ranges separated by commas
+// or "*" for everyone. Unset, only the host itself may scrape.
+var metricsAllowedIPs = sync.OnceValue(func() []string {
+	value := strings.TrimSpace(os.Getenv("METRICS_ALLOWED_IPS"))
+	switch value {
+	case "":
+		return []string{"127.0.0.1", "::1"}
+	case "*":
+		return nil
+	}
+	var allowed []string
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if !utils.ValidIPOrCIDR(entry) {
+			slog.Warn("ignoring invalid entry of METRICS_ALLOWED_IPS", "entry", entry)
+			continue
+		}
+		allowed = append(allowed, entry)
+	}
+	if allowed == nil {
+		// nothing valid must not mean everyone
+		return []string{"127.0.0.1", "::1"}
+	}
+	return allowed
+})
+
+// MetricsHandler GET /metrics - the Prometheus metrics, for the scrapers of METRICS_ALLOWED_IPS
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if !utils.IPAllowed(utils.ClientIP(r), metricsAllowedIPs()) {
+		http.Error(w, utils.MetricsNotAllowedError.Error(), utils.MetricsNotAllowedError.GetStatusCode())
+		return
+	}
+	metrics.Handler().ServeHTTP(w, r)
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"restapi/internal/metrics"
+	"restapi/internal/models"
+	"restapi/internal/policy"
+	"restapi/internal/sqlconnect"
+	"restapi/utils"
+	"time"
+)
+
+const recoveryCodeCount = 10
+
+func mfaIssuer() string {
+	if issuer := os.Getenv("MFA_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "School Manager"
+}
+
+// verifySecondFactor accepts either a TOTP code or one of the recovery codes of the user
+func verifySecondFactor(ctx context.Context, user models.Exec, secret, code, recoveryCode string) error {
+	if code != "" {
+		step, ok := utils.ValidateTOTP(secret, code, time.Now())
+		if !ok {
+			return utils.InvalidMFACodeError
+		}
+		return sqlconnect.UseTOTPStepDBHandler(ctx, user.ID, step)
+	}
+	if recoveryCode != "" {
+		return sqlconnect.UseRecoveryCodeDBHandler(ctx, user.ID, recoveryCode)
+	}
+	return utils.MissingFieldsError
+}
+
+// EnrollMFAHandler POST /execs/mfa/enroll - creates a TOTP secret for the current user
+func EnrollMFAHandler(w http.ResponseWriter, r *http.Request) {
+	subject := policy.SubjectFromContext(r.Context())
+	user, _, err := sqlconnect.GetMFAStateDBHandler(r.Context(), subject.UserID)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	if user.MfaEnabled {
+		http.Error(w, utils.MFAAlreadyEnabledError.Error(), utils.MFAAlreadyEnabledError.GetStatusCode())
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		http.Error(w, utils.ErrorGeneratingToken.Error(), utils.ErrorGeneratingToken.GetStatusCode())
+		return
+	}
+	err = sqlconnect.SetPendingMFASecretDBHandler(r.Context(), user.ID, secret)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(models.MFAEnrollment{
+		Secret:     secret,
+		OtpauthURI: utils.TOTPURI(mfaIssuer(), user.Username, secret),
+	})
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
+
+// VerifyMFAHandler POST /execs/mfa/verify - confirms the enrollment with a first code and
+// returns the recovery codes, they are shown only once
+func VerifyMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.MFACodeRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.Code == "" {
+		http.Error(w, utils.InvalidRequestBodyError.Error(), utils.InvalidRequestBodyError.GetStatusCode())
+		return
+	}
+
+	subject := policy.SubjectFromContext(r.Context())
+	user, secret, err := sqlconnect.GetMFAStateDBHandler(r.Context(), subject.UserID)
+	if err == nil && user.MfaEnabled {
+		err = utils.MFAAlreadyEnabledError
+	} else if err == nil && secret == "" {
+		err = utils.MFANotEnrolledError
+	}
+	if err == nil {
+		err = verifySecondFactor(r.Context(), user, secret, request.Code, "")
+	}
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+
+	recoveryCodes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		http.Error(w, utils.ErrorGeneratingToken.Error(), utils.ErrorGeneratingToken.GetStatusCode())
+		return
+	}
+	hashes := make([]string, len(recoveryCodes))
+	for i, code := range recoveryCodes {
+		hashes[i], err = utils.Hash(code)
+		if err != nil {
+			http.Error(w, utils.ErrorGeneratingSaltForHashing.Error(), utils.ErrorGeneratingSaltForHashing.GetStatusCode())
+			return
+		}
+	}
+	err = sqlconnect.EnableMFADBHandler(r.Context(), user.ID, hashes)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+
+	// other sessions were logged out, this one continues with a token that passed MFA
+	user.MfaEnabled = true
+	user.TokenVersion++
+	tokens, err := issueTokens(w, r, user)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Status        string   `json:"status"`
+		RecoveryCodes []string `json:"recovery_codes"`
+		tokenResponse
+	}{
+		Status:        "two-factor authentication enabled",
+		RecoveryCodes: recoveryCodes,
+		tokenResponse: tokens,
+	}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
+
+// DisableMFAHandler POST /execs/mfa/disable - needs a current code or a recovery code
+func DisableMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.MFACodeRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, utils.InvalidRequestBodyError.Error(), utils.InvalidRequestBodyError.GetStatusCode())
+		return
+	}
+
+	subject := policy.SubjectFromContext(r.Context())
+	user, secret, err := sqlconnect.GetMFAStateDBHandler(r.Context(), subject.UserID)
+	if err == nil && !user.MfaEnabled {
+		err = utils.MFANotEnrolledError
+	}
+	if err == nil {
+		err = verifySecondFactor(r.Context(), user, secret, request.Code, request.RecoveryCode)
+	}
+	if err == nil {
+		err = sqlconnect.DisableMFADBHandler(r.Context(), user.ID)
+	}
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+
+	user.MfaEnabled = false
+	user.TokenVersion++
+	tokens, err := issueTokens(w, r, user)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// LoginMFAHandler POST /execs/login/mfa - second step of the login, exchanges the challenge
+// returned by LoginHandler and a code for the JWT cookie. After a browser SSO login the
+// challenge comes in the mfa_challenge cookie instead.
+func LoginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.MFALoginRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err == nil && request.MFAToken == "" {
+		if cookie, cookieErr := r.Cookie(utils.MFAChallengeCookieName); cookieErr == nil {
+			request.MFAToken = cookie.Value
+		}
+	}
+	if err != nil || request.MFAToken == "" {
+		http.Error(w, utils.InvalidRequestBodyError.Error(), utils.InvalidRequestBodyError.GetStatusCode())
+		return
+	}
+	defer r.Body.Close()
+
+	userId, err := utils.ParseMFAChallenge(request.MFAToken)
+	if err != nil {
+		http.Error(w, utils.InvalidMFAChallengeError.Error(), utils.InvalidMFAChallengeError.GetStatusCode())
+		return
+	}
+	user, secret, err := sqlconnect.GetMFAStateDBHandler(r.Context(), userId)
+	if err == nil && user.InactiveStatus {
+		err = utils.AccountInactiveError
+	} else if err == nil && !user.MfaEnabled {
+		err = utils.InvalidMFAChallengeError
+	}
+	// second factor guesses count towards the same lockout as passwords
+	if err == nil && loginLocked(w, r, "mfa", user.Username) {
+		return
+	}
+	if err == nil {
+		err = verifySecondFactor(r.Context(), user, secret, request.Code, request.RecoveryCode)
+		if err == utils.InvalidMFACodeError {
+			loginFailed(w, r, "mfa", user.Username, utils.InvalidMFACodeError)
+			return
+		}
+	}
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	loginSucceeded(r, user.Username)
+
+	response, err := issueTokens(w, r, user)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	metrics.Logins.WithLabelValues("mfa", "success").Inc()
+	setMFAChallengeCookie(w, "", time.Unix(0, 0))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
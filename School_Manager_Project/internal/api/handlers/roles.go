@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"restapi/internal/models"
+	"restapi/internal/rbac"
+	"restapi/internal/sqlconnect"
+	"restapi/utils"
+)
+
+func GetRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roleList := rbac.Roles()
+	response := struct {
+		Status string        `json:"status"`
+		Count  int           `json:"count"`
+		Data   []models.Role `json:"data"`
+	}{
+		Status: "success",
+		Count:  len(roleList),
+		Data:   roleList,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
+
+func GetPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	response := struct {
+		Status string   `json:"status"`
+		Count  int      `json:"count"`
+		Data   []string `json:"data"`
+	}{
+		Status: "success",
+		Count:  len(rbac.AllPermissions),
+		Data:   rbac.AllPermissions,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
+
+func PostRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var newRole models.Role
+	err := json.NewDecoder(r.Body).Decode(&newRole)
+	if err != nil {
+		http.Error(w, utils.InvalidRequestBodyError.Error(), utils.InvalidRequestBodyError.GetStatusCode())
+		return
+	}
+
+	err = validateRole(newRole)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+
+	addedRole, err := sqlconnect.AddRoleDBHandler(r.Context(), newRole)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	rbac.Invalidate()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(addedRole)
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
+
+// UpdateRoleHandler PUT /roles/{name} - replaces the permissions of a role
+func UpdateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var updatedRole models.Role
+	err := json.NewDecoder(r.Body).Decode(&updatedRole)
+	if err != nil {
+		http.Error(w, utils.InvalidRequestBodyError.Error(), utils.InvalidRequestBodyError.GetStatusCode())
+		return
+	}
+	updatedRole.Name = name
+
+	err = validateRole(updatedRole)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+
+	updatedRoleFromDB, err := sqlconnect.UpdateRoleDBHandler(r.Context(), name, updatedRole)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	rbac.Invalidate()
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(updatedRoleFromDB)
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
+
+// SetRoleMFAPolicyHandler PUT /roles/{name}/mfa - requires or stops requiring MFA for a role
+func SetRoleMFAPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var request models.MFAPolicyRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, utils.InvalidRequestBodyError.Error(), utils.InvalidRequestBodyError.GetStatusCode())
+		return
+	}
+
+	err = sqlconnect.SetRoleMFARequiredDBHandler(r.Context(), name, request.Required)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	rbac.Invalidate()
+
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Name        string `json:"name"`
+		MfaRequired bool   `json:"mfa_required"`
+	}{
+		Name:        name,
+		MfaRequired: request.Required,
+	}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
+
+func DeleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	err := sqlconnect.DeleteRoleDBHandler(r.Context(), name)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	rbac.Invalidate()
+
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Status string `json:"status"`
+		Name   string `json:"name"`
+	}{
+		Status: "Role successfully deleted",
+		Name:   name,
+	}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
+
+func validateRole(role models.Role) error {
+	err := utils.ValidateRole(role)
+	if err != nil {
+		return err
+	}
+	// reserved for requests made with an API key
+	if role.Name == rbac.APIKeyRole {
+		return utils.InvalidRoleError
+	}
+	for _, permission := range role.Permissions {
+		if !rbac.IsKnownPermission(permission) {
+			return utils.UnknownPermissionError
+		}
+	}
+	return nil
+}
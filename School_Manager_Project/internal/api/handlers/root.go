@@ -1,7 +1,7 @@
 package handlers
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 	"restapi/utils"
 )
@@ -12,10 +12,8 @@ func HandleRoot(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 
 	if _, err := w.Write([]byte(message)); err != nil {
-		log.Println("Error on the server:", err)
+		slog.ErrorContext(r.Context(), "writing response failed", "err", err)
 		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
 		return
 	}
-
-	log.Println("Successfully responded to root request")
 }
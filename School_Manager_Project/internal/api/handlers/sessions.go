@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"restapi/internal/mailer"
+	"restapi/internal/models"
+	"restapi/internal/policy"
+	"restapi/internal/rbac"
+	"restapi/internal/sqlconnect"
+	"restapi/utils"
+	"strconv"
+	"time"
+)
+
+const maxUserAgentLength = 512
+
+// recordSession stores the login started by issueTokens, with the access token about to be
+// signed from claims, and mails the exec when it comes from a device they haven't logged in
+// from before
+func recordSession(w http.ResponseWriter, r *http.Request, user models.Exec, familyID string, claims jwt.MapClaims) error {
+	deviceID := ""
+	cookie, err := r.Cookie(utils.DeviceCookieName)
+	hasDeviceCookie := err == nil && cookie.Value != ""
+	if hasDeviceCookie {
+		deviceID = cookie.Value
+	} else {
+		deviceID, err = utils.GenerateRandomToken(32)
+		if err != nil {
+			return err
+		}
+		setDeviceCookie(w, deviceID)
+	}
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	session := models.Session{
+		ID:        familyID,
+		ExecID:    user.ID,
+		IP:        utils.ClientIP(r),
+		UserAgent: userAgent,
+	}
+	tokenID, tokenExpiresAt := accessTokenID(claims)
+	newDevice, err := sqlconnect.CreateSessionDBHandler(r.Context(), session, utils.HashToken(deviceID), hasDeviceCookie, tokenID, tokenExpiresAt)
+	if err != nil {
+		return err
+	}
+	if newDevice && user.Email != "" {
+		notifyNewDevice(user, session)
+	}
+	return nil
+}
+
+func notifyNewDevice(user models.Exec, session models.Session) {
+	userAgent := session.UserAgent
+	if userAgent == "" {
+		userAgent = "unknown"
+	}
+	mailer.SendAsync(mailer.Message{
+		To:      user.Email,
+		Subject: "New login to your account",
+		Body: fmt.Sprintf("Hi %s,\n\nyour account was just logged in from a new device:\n\nTime: %s\nIP address: %s\nDevice: %s\n\nIf this was you, there is nothing to do. Otherwise change your password and end the session with DELETE /execs/%d/sessions/%s.",
+			user.FirstName, time.Now().UTC().Format("2006-01-02 15:04 UTC"), session.IP, userAgent, user.ID, session.ID),
+	})
+}
+
+// canManageSessions lets execs see their own sessions and admins everybody's
+func canManageSessions(subject policy.Subject, execID int) bool {
+	return subject.UserID == execID || rbac.HasPermission(subject.Role, rbac.SessionsRevoke)
+}
+
+// GetSessionsHandler GET /execs/{id}/sessions - where the exec is logged in
+func GetSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	execID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, utils.InvalidIdError.Error(), utils.InvalidIdError.GetStatusCode())
+		return
+	}
+	subject := policy.SubjectFromContext(r.Context())
+	if !canManageSessions(subject, execID) {
+		http.Error(w, utils.PermissionDeniedError.Error(), utils.PermissionDeniedError.GetStatusCode())
+		return
+	}
+
+	sessions, err := sqlconnect.GetSessionsDBHandler(r.Context(), execID)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	currentID, _ := r.Context().Value("familyId").(string)
+	for i := range sessions {
+		sessions[i].Current = subject.UserID == execID && sessions[i].ID == currentID
+	}
+	response := struct {
+		Status string           `json:"status"`
+		Count  int              `json:"count"`
+		Data   []models.Session `json:"data"`
+	}{
+		Status: "success",
+		Count:  len(sessions),
+		Data:   sessions,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, utils.ErrorEncodingData.Error(), utils.ErrorEncodingData.GetStatusCode())
+	}
+}
+
+// RevokeSessionHandler DELETE /execs/{id}/sessions/{sid} - logs one device out right away
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	execID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, utils.InvalidIdError.Error(), utils.InvalidIdError.GetStatusCode())
+		return
+	}
+	if !canManageSessions(policy.SubjectFromContext(r.Context()), execID) {
+		http.Error(w, utils.PermissionDeniedError.Error(), utils.PermissionDeniedError.GetStatusCode())
+		return
+	}
+
+	err = sqlconnect.RevokeSessionDBHandler(r.Context(), execID, r.PathValue("sid"))
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
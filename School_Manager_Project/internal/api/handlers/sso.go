@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"restapi/internal/metrics"
+	"restapi/internal/models"
+	"restapi/internal/rbac"
+	"restapi/internal/sqlconnect"
+	"restapi/internal/sso"
+	"restapi/utils"
+	"strings"
+	"time"
+)
+
+const ssoFlowCookieName = "oidc_flow"
+
+// setExecRole is replaced in tests, there's no database
+var setExecRole = sqlconnect.SetExecRoleDBHandler
+
+// setSSORole stores the role mapped from the identity provider groups. The change bumps the
+// token version, the user takes the new one along or the tokens issued next would be rejected.
+func setSSORole(ctx context.Context, user *models.Exec, role string) error {
+	tokenVersion, err := setExecRole(ctx, user.ID, role)
+	if err != nil {
+		return err
+	}
+	user.Role = role
+	user.TokenVersion = tokenVersion
+	return nil
+}
+
+// the flow cookie comes back on the top-level redirect from the identity provider, so it is Lax
+func setSSOFlowCookie(w http.ResponseWriter, value string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoFlowCookieName,
+		Value:    value,
+		Path:     "/auth/oidc",
+		HttpOnly: true,
+		Secure:   true,
+		Expires:  expires,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ssoRedirectTarget only allows paths on our own site, anything else falls back to OIDC_POST_LOGIN_URL
+func ssoRedirectTarget(redirectTo string) string {
+	if strings.HasPrefix(redirectTo, "/") && !strings.HasPrefix(redirectTo, "//") && !strings.Contains(redirectTo, "\\") {
+		return redirectTo
+	}
+	return os.Getenv("OIDC_POST_LOGIN_URL")
+}
+
+// mfaRedirectTarget tells the page the login continues with a TOTP code, in the fragment so
+// it never reaches a server
+func mfaRedirectTarget(redirectTo string) string {
+	target, _, _ := strings.Cut(redirectTo, "#")
+	return target + "#mfa_required"
+}
+
+// SSOLoginHandler GET /auth/oidc/login - redirects to the identity provider
+func SSOLoginHandler(w http.ResponseWriter, r *http.Request) {
+	cfg, ok := sso.LoadConfig()
+	if !ok {
+		http.Error(w, utils.SSONotConfiguredError.Error(), utils.SSONotConfiguredError.GetStatusCode())
+		return
+	}
+
+	authURL, flow, err := sso.Begin(r.Context(), cfg, ssoRedirectTarget(r.URL.Query().Get("redirect_to")))
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	encoded, err := sso.EncodeFlow(flow)
+	if err != nil {
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	setSSOFlowCookie(w, encoded, time.Unix(flow.ExpiresAt, 0))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// SSOCallbackHandler GET /auth/oidc/callback - finishes the login started by SSOLoginHandler
+func SSOCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	cfg, ok := sso.LoadConfig()
+	if !ok {
+		http.Error(w, utils.SSONotConfiguredError.Error(), utils.SSONotConfiguredError.GetStatusCode())
+		return
+	}
+
+	// the flow is single use, whatever happens next
+	cookie, err := r.Cookie(ssoFlowCookieName)
+	if err != nil {
+		http.Error(w, utils.InvalidSSOResponseError.Error(), utils.InvalidSSOResponseError.GetStatusCode())
+		return
+	}
+	setSSOFlowCookie(w, "", time.Unix(0, 0))
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		http.Error(w, utils.InvalidSSOResponseError.Error(), utils.InvalidSSOResponseError.GetStatusCode())
+		return
+	}
+	flow, err := sso.DecodeFlow(cookie.Value)
+	if err != nil {
+		http.Error(w, utils.InvalidSSOResponseError.Error(), utils.InvalidSSOResponseError.GetStatusCode())
+		return
+	}
+	identity, err := sso.Finish(r.Context(), cfg, flow, query.Get("state"), query.Get("code"))
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	if !identity.EmailVerified && !cfg.AllowUnverifiedEmail {
+		http.Error(w, utils.SSOEmailNotVerifiedError.Error(), utils.SSOEmailNotVerifiedError.GetStatusCode())
+		return
+	}
+
+	user, err := sqlconnect.GetExecForSSODBHandler(r.Context(), identity.Issuer, identity.Subject, identity.Email)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	if user.InactiveStatus {
+		http.Error(w, utils.AccountInactiveError.Error(), utils.AccountInactiveError.GetStatusCode())
+		return
+	}
+
+	// the identity provider is the source of truth for the role when a group mapping matches
+	if role, ok := sso.RoleForGroups(cfg, identity.Groups); ok && role != user.Role && rbac.RoleExists(role) {
+		err = setSSORole(r.Context(), &user, role)
+		if err != nil {
+			http.Error(w, utils.DatabaseQueryError.Error(), utils.DatabaseQueryError.GetStatusCode())
+			return
+		}
+	}
+
+	// an exec enrolled in TOTP still has to pass it unless the identity provider already did MFA
+	if user.MfaEnabled && !identity.MFA {
+		challenge, err := utils.SignMFAChallenge(user.ID)
+		if err != nil {
+			http.Error(w, utils.ErrorGeneratingJwtToken.Error(), utils.ErrorGeneratingJwtToken.GetStatusCode())
+			return
+		}
+		// a browser goes back to the app, which asks for the code and posts it to
+		// /execs/login/mfa where the cookie supplies the challenge
+		if flow.RedirectTo != "" {
+			setMFAChallengeCookie(w, challenge, time.Now().Add(utils.MFAChallengeTTL))
+			http.Redirect(w, r, mfaRedirectTarget(flow.RedirectTo), http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		response := struct {
+			MFARequired bool   `json:"mfa_required"`
+			MFAToken    string `json:"mfa_token"`
+		}{
+			MFARequired: true,
+			MFAToken:    challenge,
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	// MfaEnabled ends up in the mfa claim of the access token
+	user.MfaEnabled = user.MfaEnabled || identity.MFA
+
+	response, err := issueTokens(w, r, user)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	metrics.Logins.WithLabelValues("sso", "success").Inc()
+	response.MFAEnrollmentRequired = rbac.MFARequired(user.Role) && !user.MfaEnabled
+
+	if flow.RedirectTo != "" {
+		http.Redirect(w, r, flow.RedirectTo, http.StatusFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
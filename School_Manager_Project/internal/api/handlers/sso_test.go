@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"restapi/internal/models"
+	"restapi/internal/sqlconnect"
+	"restapi/utils"
+	"testing"
+)
+
+func TestSSORedirectTarget(t *testing.T) {
+	t.Setenv("OIDC_POST_LOGIN_URL", "https://school.example/home")
+	tests := []struct {
+		redirectTo string
+		want       string
+	}{
+		{redirectTo: "/dashboard", want: "/dashboard"},
+		{redirectTo: "/students?class=9A#list", want: "/students?class=9A#list"},
+		{redirectTo: "", want: "https://school.example/home"},
+		{redirectTo: "https://evil.example/", want: "https://school.example/home"},
+		{redirectTo: "//evil.example/", want: "https://school.example/home"},
+		{redirectTo: "/\\evil.example/", want: "https://school.example/home"},
+		{redirectTo: "dashboard", want: "https://school.example/home"},
+		{redirectTo: "javascript:alert(1)", want: "https://school.example/home"},
+	}
+	for _, tt := range tests {
+		got := ssoRedirectTarget(tt.redirectTo)
+		if got != tt.want {
+			t.Errorf("ssoRedirectTarget(%q) = %q, want %q", tt.redirectTo, got, tt.want)
+		}
+	}
+}
+
+func TestMFARedirectTarget(t *testing.T) {
+	tests := []struct {
+		redirectTo string
+		want       string
+	}{
+		{redirectTo: "/dashboard", want: "/dashboard#mfa_required"},
+		{redirectTo: "/students#list", want: "/students#mfa_required"},
+		{redirectTo: "https://school.example/home", want: "https://school.example/home#mfa_required"},
+	}
+	for _, tt := range tests {
+		got := mfaRedirectTarget(tt.redirectTo)
+		if got != tt.want {
+			t.Errorf("mfaRedirectTarget(%q) = %q, want %q", tt.redirectTo, got, tt.want)
+		}
+	}
+}
+
+func TestSetSSORole(t *testing.T) {
+	// the exec is at version 3 in the database, the role change bumps it to 4
+	stored := models.Exec{ID: 7, Role: "staff", TokenVersion: 3}
+	setExecRole = func(ctx context.Context, execID int, role string) (int, error) {
+		stored.Role = role
+		stored.TokenVersion++
+		return stored.TokenVersion, nil
+	}
+	t.Cleanup(func() { setExecRole = sqlconnect.SetExecRoleDBHandler })
+
+	user := stored
+	if err := setSSORole(context.Background(), &user, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	claims, err := utils.AccessTokenClaims(user, "family")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["role"] != "admin" || claims["ver"] != stored.TokenVersion {
+		t.Errorf("token after the role change has role %v and ver %v, want admin and %d", claims["role"], claims["ver"], stored.TokenVersion)
+	}
+
+	setExecRole = func(ctx context.Context, execID int, role string) (int, error) {
+		return 0, utils.DatabaseQueryError
+	}
+	user = models.Exec{ID: 7, Role: "staff", TokenVersion: 3}
+	if err := setSSORole(context.Background(), &user, "admin"); err == nil || user.Role != "staff" || user.TokenVersion != 3 {
+		t.Errorf("failed role change left role %q and version %d (%v)", user.Role, user.TokenVersion, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"restapi/internal/models"
+	"restapi/internal/policy"
 	"restapi/internal/sqlconnect"
 	"restapi/utils"
 	"strconv"
@@ -17,7 +18,7 @@ func GetOneStudentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	student, err := sqlconnect.GetStudentByID(realID)
+	student, err := sqlconnect.GetStudentByID(r.Context(), policy.SubjectFromContext(r.Context()), realID)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -65,8 +66,15 @@ func GetStudentsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func PostStudentHandler(w http.ResponseWriter, r *http.Request) {
+	subject := policy.SubjectFromContext(r.Context())
+	err := policy.Allow(subject, policy.Students, policy.Create)
+	if err != nil {
+		http.Error(w, utils.PermissionDeniedError.Error(), utils.PermissionDeniedError.GetStatusCode())
+		return
+	}
+
 	var newStudents []models.Student
-	err := json.NewDecoder(r.Body).Decode(&newStudents)
+	err = json.NewDecoder(r.Body).Decode(&newStudents)
 	if err != nil {
 		http.Error(w, "Error decoding data", http.StatusBadRequest)
 		return
@@ -80,7 +88,7 @@ func PostStudentHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	addedStudents, err := sqlconnect.AddStudentsDBHandler(newStudents)
+	addedStudents, err := sqlconnect.AddStudentsDBHandler(r.Context(), newStudents)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -110,6 +118,13 @@ func PostStudentHandler(w http.ResponseWriter, r *http.Request) {
 
 // UpdateTeacherHandler PUT teachers - update all fields
 func UpdateStudentHandler(w http.ResponseWriter, r *http.Request) {
+	subject := policy.SubjectFromContext(r.Context())
+	err := policy.Allow(subject, policy.Students, policy.Update)
+	if err != nil {
+		http.Error(w, utils.PermissionDeniedError.Error(), utils.PermissionDeniedError.GetStatusCode())
+		return
+	}
+
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
@@ -123,7 +138,7 @@ func UpdateStudentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updatedStudentFromDB, err := sqlconnect.UpdateStudentDBHandler(id, updatedStudent)
+	updatedStudentFromDB, err := sqlconnect.UpdateStudentDBHandler(r.Context(), subject, id, updatedStudent)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -141,13 +156,20 @@ func UpdateStudentHandler(w http.ResponseWriter, r *http.Request) {
 
 // PatchStudentsHandler PATCH /teachers/
 func PatchStudentsHandler(w http.ResponseWriter, r *http.Request) {
+	subject := policy.SubjectFromContext(r.Context())
+	err := policy.Allow(subject, policy.Students, policy.Update)
+	if err != nil {
+		http.Error(w, utils.PermissionDeniedError.Error(), utils.PermissionDeniedError.GetStatusCode())
+		return
+	}
+
 	var updates []map[string]interface{}
-	err := json.NewDecoder(r.Body).Decode(&updates)
+	err = json.NewDecoder(r.Body).Decode(&updates)
 	if err != nil {
 		http.Error(w, utils.InvalidRequestBodyError.Error(), utils.InvalidRequestBodyError.GetStatusCode())
 		return
 	}
-	err = sqlconnect.PatchStudentsDBHandler(updates)
+	err = sqlconnect.PatchStudentsDBHandler(r.Context(), subject, updates)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -161,6 +183,13 @@ func PatchStudentsHandler(w http.ResponseWriter, r *http.Request) {
 
 // PatchOneTeacherHandler patch method - only update received fields
 func PatchOneStudentHandler(w http.ResponseWriter, r *http.Request) {
+	subject := policy.SubjectFromContext(r.Context())
+	err := policy.Allow(subject, policy.Students, policy.Update)
+	if err != nil {
+		http.Error(w, utils.PermissionDeniedError.Error(), utils.PermissionDeniedError.GetStatusCode())
+		return
+	}
+
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
@@ -175,7 +204,7 @@ func PatchOneStudentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	existingStudent, err := sqlconnect.PatchOneStudentDBHandler(id, updates)
+	existingStudent, err := sqlconnect.PatchOneStudentDBHandler(r.Context(), subject, id, updates)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -192,13 +221,20 @@ func PatchOneStudentHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func DeleteOneStudentHandler(w http.ResponseWriter, r *http.Request) {
+	subject := policy.SubjectFromContext(r.Context())
+	err := policy.Allow(subject, policy.Students, policy.Delete)
+	if err != nil {
+		http.Error(w, utils.PermissionDeniedError.Error(), utils.PermissionDeniedError.GetStatusCode())
+		return
+	}
+
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		http.Error(w, utils.InvalidIdError.Error(), utils.InvalidIdError.GetStatusCode())
 		return
 	}
-	err = sqlconnect.DeleteOneStudentDBHandler(id)
+	err = sqlconnect.DeleteOneStudentDBHandler(r.Context(), id)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -226,15 +262,22 @@ func DeleteOneStudentHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func DeleteStudentsHandler(w http.ResponseWriter, r *http.Request) {
+	subject := policy.SubjectFromContext(r.Context())
+	err := policy.Allow(subject, policy.Students, policy.Delete)
+	if err != nil {
+		http.Error(w, utils.PermissionDeniedError.Error(), utils.PermissionDeniedError.GetStatusCode())
+		return
+	}
+
 	var ids []int
 
-	err := json.NewDecoder(r.Body).Decode(&ids)
+	err = json.NewDecoder(r.Body).Decode(&ids)
 	if err != nil {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
 
-	deletedIds, err := sqlconnect.DeleteStudentsDBHandler(ids)
+	deletedIds, err := sqlconnect.DeleteStudentsDBHandler(r.Context(), ids)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
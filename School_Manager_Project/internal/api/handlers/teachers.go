@@ -2,9 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"restapi/internal/models"
+	"restapi/internal/policy"
 	"restapi/internal/sqlconnect"
 	"restapi/utils"
 	"strconv"
@@ -18,7 +18,7 @@ func GetOneTeacherHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	teacher, err := sqlconnect.GetTeacherByID(realID)
+	teacher, err := sqlconnect.GetTeacherByID(r.Context(), realID)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -79,7 +79,7 @@ func PostTeacherHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	addedTeachers, err := sqlconnect.AddTeachersDBHandler(newTeachers)
+	addedTeachers, err := sqlconnect.AddTeachersDBHandler(r.Context(), newTeachers)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -121,7 +121,7 @@ func UpdateTeacherHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updatedTeacherFromDB, err := sqlconnect.UpdateTeacherDBHandler(id, updatedTeacher)
+	updatedTeacherFromDB, err := sqlconnect.UpdateTeacherDBHandler(r.Context(), id, updatedTeacher)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -145,7 +145,7 @@ func PatchTeachersHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, utils.InvalidRequestBodyError.Error(), utils.InvalidRequestBodyError.GetStatusCode())
 		return
 	}
-	err = sqlconnect.PatchTeachersDBHandler(updates)
+	err = sqlconnect.PatchTeachersDBHandler(r.Context(), updates)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -173,7 +173,7 @@ func PatchOneTeacherHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	existingTeacher, err := sqlconnect.PatchOneTeacherDBHandler(id, updates)
+	existingTeacher, err := sqlconnect.PatchOneTeacherDBHandler(r.Context(), id, updates)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -196,7 +196,7 @@ func DeleteOneTeacherHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, utils.InvalidIdError.Error(), utils.InvalidIdError.GetStatusCode())
 		return
 	}
-	err = sqlconnect.DeleteOneTeacherDBHandler(id)
+	err = sqlconnect.DeleteOneTeacherDBHandler(r.Context(), id)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -232,7 +232,7 @@ func DeleteTeachersHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deletedIds, err := sqlconnect.DeleteTeachersDBHandler(ids)
+	deletedIds, err := sqlconnect.DeleteTeachersDBHandler(r.Context(), ids)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -263,7 +263,7 @@ func GetStudentsListForTeacher(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, utils.InvalidIdError.Error(), utils.InvalidIdError.GetStatusCode())
 		return
 	}
-	studentsList, err := sqlconnect.GetStudentsListForTeacherDBHandler(id)
+	studentsList, err := sqlconnect.GetStudentsListForTeacherDBHandler(r.Context(), policy.SubjectFromContext(r.Context()), id)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
@@ -290,24 +290,13 @@ func GetStudentsListForTeacher(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetStudentCountForTeacher(w http.ResponseWriter, r *http.Request) {
-	// allowed only for admin, manager, exec
-	_, err := utils.AuthorizeUser(r.Context().Value("role").(string), "admin", "manager", "exec")
-	fmt.Println(r.Context().Value("role"))
-	if err != nil {
-		if appErr, ok := err.(*utils.AppErrors); ok {
-			http.Error(w, appErr.Error(), appErr.GetStatusCode())
-			return
-		}
-		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
-		return
-	}
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		http.Error(w, utils.InvalidIdError.Error(), utils.InvalidIdError.GetStatusCode())
 		return
 	}
-	studentCount, err := sqlconnect.GetStudentCountForTeacherDBHandler(id)
+	studentCount, err := sqlconnect.GetStudentCountForTeacherDBHandler(r.Context(), id)
 	if err != nil {
 		if appErr, ok := err.(*utils.AppErrors); ok {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
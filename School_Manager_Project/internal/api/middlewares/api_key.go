@@ -0,0 +1,62 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"restapi/internal/rbac"
+	"restapi/internal/sqlconnect"
+	"restapi/utils"
+	"strings"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+// apiKeyFromRequest finds an API key in Authorization: Bearer or X-API-Key. Cookies never carry one.
+func apiKeyFromRequest(r *http.Request) (string, bool) {
+	if token, ok := bearerToken(r); ok && strings.HasPrefix(token, utils.APIKeyPrefix) {
+		return token, true
+	}
+	if key := strings.TrimSpace(r.Header.Get(apiKeyHeader)); key != "" {
+		return key, true
+	}
+	return "", false
+}
+
+func serveAPIKey(w http.ResponseWriter, r *http.Request, apiKey string, next http.Handler) {
+	prefix, ok := utils.ParseAPIKeyPrefix(apiKey)
+	if !ok {
+		http.Error(w, utils.InvalidAPIKeyError.Error(), utils.InvalidAPIKeyError.GetStatusCode())
+		return
+	}
+	key, keyHash, err := sqlconnect.GetActiveAPIKeyDBHandler(r.Context(), prefix)
+	if err != nil {
+		if appErr, ok := err.(*utils.AppErrors); ok {
+			http.Error(w, appErr.Error(), appErr.GetStatusCode())
+			return
+		}
+		http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(utils.HashToken(apiKey)), []byte(keyHash)) != 1 {
+		http.Error(w, utils.InvalidAPIKeyError.Error(), utils.InvalidAPIKeyError.GetStatusCode())
+		return
+	}
+	clientIP := utils.ClientIP(r)
+	if !utils.IPAllowed(clientIP, key.AllowedIPs) {
+		http.Error(w, utils.APIKeyIPNotAllowedError.Error(), utils.APIKeyIPNotAllowedError.GetStatusCode())
+		return
+	}
+	err = sqlconnect.TouchAPIKeyDBHandler(r.Context(), key.ID, clientIP)
+	if err != nil {
+		slog.WarnContext(r.Context(), "recording API key use failed", "err", err)
+	}
+
+	ctx := context.WithValue(r.Context(), "apiKeyId", key.ID)
+	ctx = context.WithValue(ctx, "username", "api-key:"+key.Name)
+	ctx = context.WithValue(ctx, "role", rbac.APIKeyRole)
+	ctx = context.WithValue(ctx, "scopes", key.Scopes)
+
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
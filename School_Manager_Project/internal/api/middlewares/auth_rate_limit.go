@@ -0,0 +1,76 @@
+package middlewares
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"restapi/internal/metrics"
+	"restapi/utils"
+	"strconv"
+	"time"
+)
+
+// AuthRateLimitOptions limit the failed authentications of a client IP, default 10 a minute
+type AuthRateLimitOptions struct {
+	Limit  int    `json:"limit"`
+	Window string `json:"window"`
+	Burst  int    `json:"burst"`
+	// Store is "memory" or "redis", see rateLimitStore
+	Store string `json:"store"`
+}
+
+// authRateLimiter runs before auth. Requests with a bad access token or API key never reach
+// rate_limit, which counts per user and so runs after auth, yet each one costs auth its
+// database lookups. Only 401 answers take from the bucket of the client IP, so the users of
+// a school sharing one address don't limit each other, and once it is empty the IP is turned
+// away before auth runs at all.
+type authRateLimiter struct {
+	scope  string
+	policy RateLimitPolicy
+	store  RateLimitStore
+}
+
+func NewAuthRateLimiter(scope string, options AuthRateLimitOptions, store RateLimitStore) (*authRateLimiter, error) {
+	if options.Limit == 0 {
+		options.Limit = 10
+	}
+	if options.Window == "" {
+		options.Window = "1m"
+	}
+	policy := RateLimitPolicy{Name: "auth_failures", Limit: options.Limit, Window: options.Window, Burst: options.Burst}
+	err := policy.normalize(policy.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &authRateLimiter{scope: scope, policy: policy, store: store}, nil
+}
+
+func (a *authRateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := a.scope + "|" + a.policy.Name + "|ip:" + utils.ClientIP(r)
+		take := func(cost int) (float64, error) {
+			_, tokens, err := a.store.Take(r.Context(), key, cost, a.policy.Burst, a.policy.rate(), maxRefill(&a.policy), time.Now())
+			if err != nil {
+				// an unreachable store must not take the API down with it
+				slog.ErrorContext(r.Context(), "rate limit store failed, request let through", "err", err)
+			}
+			return tokens, err
+		}
+
+		tokens, err := take(0)
+		if err == nil && tokens < 1 {
+			decision := decide(false, tokens, &a.policy)
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(decision.retryAfter)))
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", a.policy.Limit, ceilSeconds(a.policy.window), a.policy.Burst))
+			metrics.RateLimitRejections.WithLabelValues(a.policy.Name).Inc()
+			http.Error(w, utils.RateLimitExceededError.Error(), utils.RateLimitExceededError.GetStatusCode())
+			return
+		}
+
+		wrappedWriter := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(wrappedWriter, r)
+		if wrappedWriter.status == http.StatusUnauthorized {
+			take(1)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthRateLimiter(t *testing.T) {
+	limiter, err := NewAuthRateLimiter(GroupAuthenticated, AuthRateLimitOptions{Limit: 2, Window: "1h"}, &memoryStore{buckets: map[string]*bucket{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("Authorization") != "Bearer good" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		}
+	}))
+	request := func(ip, authorization string) int {
+		r := httptest.NewRequest(http.MethodGet, "/students/", nil)
+		r.RemoteAddr = ip + ":1234"
+		r.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	// successful requests never count
+	for i := 0; i < 5; i++ {
+		if code := request("203.0.113.1", "Bearer good"); code != http.StatusOK {
+			t.Fatalf("authenticated request %d = %d", i+1, code)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if code := request("203.0.113.1", "Bearer bad"); code != http.StatusUnauthorized {
+			t.Fatalf("failed request %d = %d, want 401", i+1, code)
+		}
+	}
+	calls = 0
+	if code := request("203.0.113.1", "Bearer bad"); code != http.StatusTooManyRequests {
+		t.Fatalf("request after the failures = %d, want 429", code)
+	}
+	if code := request("203.0.113.1", "Bearer good"); code != http.StatusTooManyRequests {
+		t.Fatalf("good request from the blocked IP = %d, want 429", code)
+	}
+	if calls != 0 {
+		t.Fatal("blocked requests reached auth")
+	}
+	if code := request("203.0.113.2", "Bearer bad"); code != http.StatusUnauthorized {
+		t.Fatalf("failed request from another IP = %d, want 401", code)
+	}
+}
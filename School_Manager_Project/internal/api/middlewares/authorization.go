@@ -0,0 +1,58 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"net/http"
+	"restapi/internal/rbac"
+	"restapi/utils"
+)
+
+// RequirePermission only lets the request through if the role stored in the context by
+// JWTMiddleware has been granted the given permission
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, ok := r.Context().Value("role").(string)
+			if !ok || role == "" {
+				http.Error(w, utils.UserNotAuthorizedError.Error(), utils.UserNotAuthorizedError.GetStatusCode())
+				return
+			}
+			// API keys carry their own scopes and no second factor
+			if scopes, ok := r.Context().Value("scopes").([]string); ok {
+				if !rbac.GrantsPermission(scopes, permission) {
+					writeForbidden(w, role, permission)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !rbac.HasPermission(role, permission) {
+				writeForbidden(w, role, permission)
+				return
+			}
+			// roles can be required to log in with a second factor
+			if mfa, _ := r.Context().Value("mfa").(bool); !mfa && rbac.MFARequired(role) {
+				http.Error(w, utils.MFARequiredError.Error(), utils.MFARequiredError.GetStatusCode())
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeForbidden(w http.ResponseWriter, role, permission string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(utils.PermissionDeniedError.GetStatusCode())
+	response := struct {
+		Status             string `json:"status"`
+		Error              string `json:"error"`
+		Role               string `json:"role"`
+		RequiredPermission string `json:"required_permission"`
+	}{
+		Status:             "error",
+		Error:              utils.PermissionDeniedError.Error(),
+		Role:               role,
+		RequiredPermission: permission,
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,54 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"restapi/utils"
+	"strings"
+)
+
+// ClientIPOptions lists the proxies in front of the API and the header they set
+type ClientIPOptions struct {
+	// TrustedProxies are addresses or CIDR ranges, default TRUSTED_PROXIES
+	TrustedProxies []string `json:"trusted_proxies"`
+	// Header is the one header the proxies write the client into: X-Forwarded-For (default),
+	// Forwarded or X-Real-IP. Only one is read, clients can send the others themselves and a
+	// proxy passes those through untouched.
+	Header string `json:"header"`
+}
+
+type clientIPResolver struct {
+	trusted []*net.IPNet
+	header  string
+}
+
+func NewClientIPResolver(options ClientIPOptions) (*clientIPResolver, error) {
+	if options.TrustedProxies == nil {
+		options.TrustedProxies = strings.Split(os.Getenv("TRUSTED_PROXIES"), ",")
+	}
+	trusted, err := utils.ParseTrustedProxies(options.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	header := http.CanonicalHeaderKey(options.Header)
+	switch header {
+	case "":
+		header = utils.XForwardedForHeader
+	case utils.ForwardedHeader, utils.XForwardedForHeader, utils.XRealIPHeader:
+	default:
+		return nil, fmt.Errorf("unsupported header %q", options.Header)
+	}
+	return &clientIPResolver{trusted: trusted, header: header}, nil
+}
+
+// Middleware stores the client address in the context for utils.ClientIP, so the rate
+// limiter, lockouts, API key allowlists and sessions see the client instead of the proxy
+func (c *clientIPResolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := utils.ResolveClientIP(r, c.trusted, c.header)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "clientIp", ip)))
+	})
+}
@@ -1,38 +1,301 @@
 package middlewares
 
 import (
+	"bufio"
 	"compress/gzip"
 	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"mime"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 )
 
-func Compression(next http.Handler) http.Handler {
+// CompressionOptions pick the encodings and which responses are worth compressing
+type CompressionOptions struct {
+	// Encodings in order of preference when the client likes several equally,
+	// default zstd, br, gzip
+	Encodings []string `json:"encodings"`
+	// MinSize in bytes, smaller bodies are sent as they are. Default 1024.
+	MinSize *int `json:"min_size"`
+	// SkipTypes are content types that are already compressed, "image/*" matches a whole type
+	SkipTypes []string `json:"skip_types"`
+}
+
+// encoder is what gzip, brotli and zstd writers have in common
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// the pools of reusable encoders, writers are kept per encoding since building one is
+// the expensive part of compressing a short response
+var encoderPools = map[string]*sync.Pool{
+	"gzip": {New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	}},
+	// dynamic responses, the higher levels cost more CPU than they save in bytes
+	"br": {New: func() interface{} {
+		return brotli.NewWriterLevel(io.Discard, 4)
+	}},
+	"zstd": {New: func() interface{} {
+		enc, err := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(1<<20))
+		if err != nil {
+			panic(err)
+		}
+		return enc
+	}},
+}
+
+var defaultSkipTypes = []string{
+	"image/png", "image/jpeg", "image/gif", "image/webp", "image/avif",
+	"video/*", "audio/*", "font/woff", "font/woff2",
+	"application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+	"application/x-7z-compressed", "application/x-rar-compressed",
+}
+
+type compression struct {
+	encodings []string
+	minSize   int
+	skipTypes []string
+}
+
+func NewCompression(options CompressionOptions) (*compression, error) {
+	c := &compression{minSize: 1024, skipTypes: defaultSkipTypes}
+	if options.Encodings == nil {
+		options.Encodings = []string{"zstd", "br", "gzip"}
+	}
+	for _, encoding := range options.Encodings {
+		encoding = strings.ToLower(encoding)
+		if _, ok := encoderPools[encoding]; !ok {
+			return nil, fmt.Errorf("unsupported encoding %q", encoding)
+		}
+		c.encodings = append(c.encodings, encoding)
+	}
+	if options.MinSize != nil {
+		if *options.MinSize < 0 {
+			return nil, fmt.Errorf("min_size can't be negative")
+		}
+		c.minSize = *options.MinSize
+	}
+	if options.SkipTypes != nil {
+		c.skipTypes = options.SkipTypes
+	}
+	return c, nil
+}
+
+// negotiate returns the encoding with the highest q-value in Accept-Encoding, ties go to
+// the one listed first in encodings. An empty result means identity.
+func (c *compression) negotiate(acceptEncoding string) string {
+	qualities := map[string]float64{}
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if found && strings.EqualFold(key, "q") {
+				parsed, err := strconv.ParseFloat(value, 64)
+				if err != nil || parsed < 0 || parsed > 1 {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+		if coding == "*" {
+			wildcard = q
+		} else {
+			qualities[coding] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range c.encodings {
+		q, listed := qualities[encoding]
+		if !listed && encoding == "gzip" {
+			q, listed = qualities["x-gzip"]
+		}
+		if !listed {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+func (c *compression) skipType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, skip := range c.skipTypes {
+		if prefix, ok := strings.CutSuffix(skip, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if strings.EqualFold(mediaType, skip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware compresses successful responses once they reach the size threshold. The first
+// bytes are held back until then, so small bodies go out untouched with their Content-Length.
+func (c *compression) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// check if the client accepts gunzip encoding
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := c.negotiate(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		w.Header().Set("Content-Encoding", "gzip")
-		gz := gzip.NewWriter(w)
-		defer gz.Close()
+		cw := &compressWriter{ResponseWriter: w, compression: c, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
 
-		w = &gzipResponseWriter{ResponseWriter: w, Writer: gz}
+// compressWriter buffers the start of a response until it knows whether to compress it
+type compressWriter struct {
+	http.ResponseWriter
+	compression *compression
+	encoding    string
 
-		next.ServeHTTP(w, r)
-		fmt.Println("send response from compression middleware")
+	status  int
+	buf     []byte
+	decided bool
+	encoder encoder
+}
 
-	})
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+	// informational responses go straight out, the real one follows
+	if status >= 100 && status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
 }
 
-// gzip response writer wraps http.ResponseWriter to write gzipped responses
-type gzipResponseWriter struct {
-	http.ResponseWriter
-	Writer *gzip.Writer
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if cw.decided {
+		if cw.encoder != nil {
+			return cw.encoder.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.compression.minSize {
+		err := cw.decide(true)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
 }
 
-func (g *gzipResponseWriter) Write(b []byte) (int, error) {
-	return g.Writer.Write(b)
+// decide writes the header and the buffered bytes, compressed or not
+func (cw *compressWriter) decide(bigEnough bool) error {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	header := cw.Header()
+	if header.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	compress := bigEnough &&
+		cw.status >= 200 && cw.status < 300 &&
+		cw.status != http.StatusNoContent && cw.status != http.StatusPartialContent &&
+		header.Get("Content-Encoding") == "" &&
+		!cw.compression.skipType(header.Get("Content-Type"))
+	if compress {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", cw.encoding)
+		// the compressed body is a different representation
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		cw.encoder = encoderPools[cw.encoding].Get().(encoder)
+		cw.encoder.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends what has been written so far, a streamed response is compressed even while
+// it is below the size threshold
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			return
+		}
+		cw.decide(true)
+	}
+	if cw.encoder != nil {
+		cw.encoder.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if cw.status == 0 {
+			// the handler wrote nothing, net/http sends an empty 200 itself
+			return
+		}
+		cw.decide(false)
+	}
+	if cw.encoder != nil {
+		cw.encoder.Close()
+		cw.encoder.Reset(io.Discard)
+		encoderPools[cw.encoding].Put(cw.encoder)
+		cw.encoder = nil
+	}
 }
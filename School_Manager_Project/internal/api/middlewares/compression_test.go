@@ -0,0 +1,44 @@
+package middlewares
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	c, err := NewCompression(CompressionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzipOnly, err := NewCompression(CompressionOptions{Encodings: []string{"GZIP"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name           string
+		c              *compression
+		acceptEncoding string
+		want           string
+	}{
+		{name: "no header", c: c, acceptEncoding: "", want: ""},
+		{name: "identity only", c: c, acceptEncoding: "identity", want: ""},
+		{name: "single encoding", c: c, acceptEncoding: "gzip", want: "gzip"},
+		{name: "ties go to the server preference", c: c, acceptEncoding: "gzip, deflate, br, zstd", want: "zstd"},
+		{name: "highest q wins", c: c, acceptEncoding: "zstd;q=0.5, br;q=0.8, gzip", want: "gzip"},
+		{name: "q=0 refuses", c: c, acceptEncoding: "zstd;q=0, br", want: "br"},
+		{name: "all refused", c: c, acceptEncoding: "zstd;q=0, br;q=0, gzip;q=0", want: ""},
+		{name: "case and spaces", c: c, acceptEncoding: " GZip ; Q=0.9 , BR ;q=0.1", want: "gzip"},
+		{name: "x-gzip alias", c: c, acceptEncoding: "x-gzip", want: "gzip"},
+		{name: "wildcard", c: c, acceptEncoding: "*", want: "zstd"},
+		{name: "wildcard below a listed encoding", c: c, acceptEncoding: "*;q=0.1, br;q=0.5", want: "br"},
+		{name: "wildcard refused with one allowed", c: c, acceptEncoding: "*;q=0, gzip", want: "gzip"},
+		{name: "invalid q is a refusal", c: c, acceptEncoding: "zstd;q=abc, br;q=2, gzip;q=0.3", want: "gzip"},
+		{name: "unknown encodings ignored", c: c, acceptEncoding: "deflate, compress", want: ""},
+		{name: "only configured encodings", c: gzipOnly, acceptEncoding: "zstd, br", want: ""},
+		{name: "configured encoding offered", c: gzipOnly, acceptEncoding: "zstd, gzip;q=0.2", want: "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.c.negotiate(tt.acceptEncoding); got != tt.want {
+				t.Errorf("negotiate(%q) = %q, want %q", tt.acceptEncoding, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,45 +1,181 @@
 package middlewares
 
 import (
+	"fmt"
 	"net/http"
+	"os"
+	"restapi/utils"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// Allowed origins
-var allowedOrigins = []string{
-	"https://localhost:3000",
-	"https://my-origin-url.com",
+// CorsOptions decide which other sites may call the API from a browser
+type CorsOptions struct {
+	// AllowedOrigins are origins like https://app.school.org, patterns like
+	// https://*.school.org for any subdomain, or "*". Default CORS_ALLOWED_ORIGINS.
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowedMethods   []string `json:"allowed_methods"`
+	AllowedHeaders   []string `json:"allowed_headers"`
+	ExposedHeaders   []string `json:"exposed_headers"`
+	AllowCredentials *bool    `json:"allow_credentials"`
+	// MaxAge is how long browsers may cache a preflight, like "1h"
+	MaxAge string `json:"max_age"`
 }
 
-func isAllowedOrigin(origin string) bool {
-	for _, allowed := range allowedOrigins {
-		if origin == allowed {
+// originPattern is an origin with at most one "*" standing for one or more subdomain labels
+type originPattern struct {
+	prefix string
+	suffix string
+	any    bool
+}
+
+func parseOriginPattern(origin string) (originPattern, error) {
+	origin = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+	if origin == "*" {
+		return originPattern{any: true}, nil
+	}
+	scheme, host, found := strings.Cut(origin, "://")
+	if !found || scheme == "" || host == "" || strings.Contains(host, "/") {
+		return originPattern{}, fmt.Errorf("invalid origin %q", origin)
+	}
+	prefix, suffix, wildcard := strings.Cut(origin, "*")
+	if !wildcard {
+		return originPattern{prefix: origin}, nil
+	}
+	if prefix != scheme+"://" || !strings.HasPrefix(suffix, ".") || strings.Contains(suffix, "*") {
+		return originPattern{}, fmt.Errorf("invalid origin pattern %q, use scheme://*.domain", origin)
+	}
+	return originPattern{prefix: prefix, suffix: suffix}, nil
+}
+
+func (p originPattern) matches(origin string) bool {
+	if p.any {
+		return true
+	}
+	if p.suffix == "" {
+		return origin == p.prefix
+	}
+	if len(origin) <= len(p.prefix)+len(p.suffix) || !strings.HasPrefix(origin, p.prefix) || !strings.HasSuffix(origin, p.suffix) {
+		return false
+	}
+	// the subdomains must not smuggle in a port, credentials or another host
+	for _, c := range origin[len(p.prefix) : len(origin)-len(p.suffix)] {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+type cors struct {
+	origins          []originPattern
+	anyOrigin        bool
+	methods          string
+	headers          string
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           string
+}
+
+func NewCors(options CorsOptions) (*cors, error) {
+	if options.AllowedOrigins == nil {
+		options.AllowedOrigins = strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",")
+	}
+	if options.AllowedMethods == nil {
+		options.AllowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	}
+	if options.AllowedHeaders == nil {
+		options.AllowedHeaders = []string{"Content-Type", "Authorization", utils.CSRFHeaderName, apiKeyHeader}
+	}
+	if options.ExposedHeaders == nil {
+		options.ExposedHeaders = []string{"Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy"}
+	}
+	if options.MaxAge == "" {
+		options.MaxAge = "1h"
+	}
+
+	c := &cors{
+		methods:          strings.Join(options.AllowedMethods, ", "),
+		headers:          strings.Join(options.AllowedHeaders, ", "),
+		exposedHeaders:   strings.Join(options.ExposedHeaders, ", "),
+		allowCredentials: options.AllowCredentials == nil || *options.AllowCredentials,
+	}
+	for _, origin := range options.AllowedOrigins {
+		if strings.TrimSpace(origin) == "" {
+			continue
+		}
+		pattern, err := parseOriginPattern(origin)
+		if err != nil {
+			return nil, err
+		}
+		c.anyOrigin = c.anyOrigin || pattern.any
+		c.origins = append(c.origins, pattern)
+	}
+	// any website could act with the user's cookies
+	if c.anyOrigin && c.allowCredentials {
+		return nil, fmt.Errorf(`allowed_origins "*" needs allow_credentials false`)
+	}
+	maxAge, err := time.ParseDuration(options.MaxAge)
+	if err != nil || maxAge < 0 {
+		return nil, fmt.Errorf("invalid max_age %q", options.MaxAge)
+	}
+	c.maxAge = strconv.Itoa(int(maxAge.Seconds()))
+	return c, nil
+}
+
+func (c *cors) allowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range c.origins {
+		if pattern.matches(origin) {
 			return true
 		}
 	}
 	return false
 }
 
-func Cors(next http.Handler) http.Handler {
+// Middleware answers preflights and adds the CORS headers for allowed origins. Requests
+// without an Origin, same-origin and server-to-server ones, pass through untouched, and
+// actual requests from other origins too: the browser hides the response from the page.
+func (c *cors) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
-
-		if isAllowedOrigin(origin) {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-		} else {
-			http.Error(w, "Not allowed by CORS", http.StatusUnauthorized)
+		if !c.anyOrigin {
+			w.Header().Add("Vary", "Origin")
+		}
+		if origin == "" {
+			next.ServeHTTP(w, r)
 			return
 		}
+		allowed := c.allowed(origin)
+		if allowed {
+			if c.anyOrigin {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if c.allowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
 
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Expose-Headers", "Authorization")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
-		w.Header().Set("Access-Control-Max-Age", "3600")
-
-		if r.Method == http.MethodOptions {
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if !allowed {
+				http.Error(w, utils.CorsOriginNotAllowedError.Error(), utils.CorsOriginNotAllowedError.GetStatusCode())
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", c.methods)
+			w.Header().Set("Access-Control-Allow-Headers", c.headers)
+			w.Header().Set("Access-Control-Max-Age", c.maxAge)
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
+		if allowed && c.exposedHeaders != "" {
+			w.Header().Set("Access-Control-Expose-Headers", c.exposedHeaders)
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,54 @@
+package middlewares
+
+import "testing"
+
+func TestParseOriginPattern(t *testing.T) {
+	valid := []string{"*", "https://app.school.org", "https://app.school.org/", " HTTPS://App.School.org ", "https://*.school.org", "http://localhost:3000"}
+	for _, origin := range valid {
+		if _, err := parseOriginPattern(origin); err != nil {
+			t.Errorf("parseOriginPattern(%q) = %v", origin, err)
+		}
+	}
+	invalid := []string{"app.school.org", "https://", "https://app.school.org/path", "https://app.*.org", "*.school.org", "https://*school.org", "https://*.*.school.org"}
+	for _, origin := range invalid {
+		if _, err := parseOriginPattern(origin); err == nil {
+			t.Errorf("parseOriginPattern(%q) accepted", origin)
+		}
+	}
+}
+
+func TestOriginPatternMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		origin  string
+		want    bool
+	}{
+		{pattern: "*", origin: "https://anything.example", want: true},
+		{pattern: "https://app.school.org", origin: "https://app.school.org", want: true},
+		{pattern: "HTTPS://App.School.org/", origin: "https://app.school.org", want: true},
+		{pattern: "https://app.school.org", origin: "http://app.school.org", want: false},
+		{pattern: "https://app.school.org", origin: "https://app.school.org:8443", want: false},
+		{pattern: "https://app.school.org", origin: "https://app.school.org.evil.example", want: false},
+		{pattern: "https://*.school.org", origin: "https://app.school.org", want: true},
+		{pattern: "https://*.school.org", origin: "https://a.b-c.school.org", want: true},
+		{pattern: "https://*.school.org", origin: "https://school.org", want: false},
+		{pattern: "https://*.school.org", origin: "https://.school.org", want: false},
+		{pattern: "https://*.school.org", origin: "http://app.school.org", want: false},
+		{pattern: "https://*.school.org", origin: "https://evilschool.org", want: false},
+		{pattern: "https://*.school.org", origin: "https://app.school.org.evil.example", want: false},
+		{pattern: "https://*.school.org", origin: "https://evil.example:443/.school.org", want: false},
+		{pattern: "https://*.school.org", origin: "https://user@app.school.org", want: false},
+		{pattern: "https://*.school.org", origin: "https://evil.example:1.school.org", want: false},
+		{pattern: "https://*.school.org:8443", origin: "https://app.school.org:8443", want: true},
+		{pattern: "https://*.school.org:8443", origin: "https://app.school.org", want: false},
+	}
+	for _, tt := range tests {
+		pattern, err := parseOriginPattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("parseOriginPattern(%q) = %v", tt.pattern, err)
+		}
+		if got := pattern.matches(tt.origin); got != tt.want {
+			t.Errorf("%q matches %q = %v, want %v", tt.pattern, tt.origin, got, tt.want)
+		}
+	}
+}
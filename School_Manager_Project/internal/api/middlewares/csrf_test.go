@@ -0,0 +1,69 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"restapi/utils"
+	"testing"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	t.Setenv("CSRF_TRUSTED_ORIGINS", "https://app.school.org/, https://admin.school.org")
+	handler := CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	session := &http.Cookie{Name: utils.AccessCookieName, Value: "jwt"}
+	csrf := &http.Cookie{Name: utils.CSRFCookieName, Value: "token-1"}
+
+	tests := []struct {
+		name    string
+		method  string
+		cookies []*http.Cookie
+		headers map[string]string
+		want    int
+	}{
+		{name: "safe method", method: http.MethodGet, cookies: []*http.Cookie{session}, want: http.StatusOK},
+		{name: "no session cookie", method: http.MethodPost, want: http.StatusOK},
+		{name: "cookie and matching header", method: http.MethodPost, cookies: []*http.Cookie{session, csrf},
+			headers: map[string]string{utils.CSRFHeaderName: "token-1"}, want: http.StatusOK},
+		{name: "refresh cookie counts as a session", method: http.MethodPost,
+			cookies: []*http.Cookie{{Name: utils.RefreshCookieName, Value: "refresh"}}, want: http.StatusForbidden},
+		{name: "header missing", method: http.MethodDelete, cookies: []*http.Cookie{session, csrf}, want: http.StatusForbidden},
+		{name: "cookie missing", method: http.MethodPost, cookies: []*http.Cookie{session},
+			headers: map[string]string{utils.CSRFHeaderName: "token-1"}, want: http.StatusForbidden},
+		{name: "header differs", method: http.MethodPut, cookies: []*http.Cookie{session, csrf},
+			headers: map[string]string{utils.CSRFHeaderName: "token-2"}, want: http.StatusForbidden},
+		{name: "empty cookie and header", method: http.MethodPost, cookies: []*http.Cookie{session, {Name: utils.CSRFCookieName, Value: ""}},
+			headers: map[string]string{utils.CSRFHeaderName: ""}, want: http.StatusForbidden},
+		{name: "bearer token skips the double submit", method: http.MethodPost, cookies: []*http.Cookie{session},
+			headers: map[string]string{"Authorization": "Bearer abc"}, want: http.StatusOK},
+		{name: "api key skips the double submit", method: http.MethodPost, cookies: []*http.Cookie{session},
+			headers: map[string]string{apiKeyHeader: "key"}, want: http.StatusOK},
+		{name: "own origin", method: http.MethodPost, cookies: []*http.Cookie{session, csrf},
+			headers: map[string]string{"Origin": "https://api.school.org", utils.CSRFHeaderName: "token-1"}, want: http.StatusOK},
+		{name: "trusted origin", method: http.MethodPost, cookies: []*http.Cookie{session, csrf},
+			headers: map[string]string{"Origin": "https://app.school.org", utils.CSRFHeaderName: "token-1"}, want: http.StatusOK},
+		{name: "untrusted origin", method: http.MethodPost, cookies: []*http.Cookie{session, csrf},
+			headers: map[string]string{"Origin": "https://evil.example", utils.CSRFHeaderName: "token-1"}, want: http.StatusForbidden},
+		{name: "untrusted origin without cookies", method: http.MethodPost,
+			headers: map[string]string{"Origin": "https://evil.example"}, want: http.StatusForbidden},
+		{name: "untrusted referer", method: http.MethodPost,
+			headers: map[string]string{"Referer": "https://evil.example/page"}, want: http.StatusForbidden},
+		{name: "trusted referer", method: http.MethodPost,
+			headers: map[string]string{"Referer": "https://admin.school.org/execs"}, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "https://api.school.org/execs", nil)
+			for _, cookie := range tt.cookies {
+				r.AddCookie(cookie)
+			}
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
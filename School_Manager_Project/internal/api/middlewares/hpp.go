@@ -1,65 +1,253 @@
 package middlewares
 
 import (
-	"fmt"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"restapi/utils"
 	"strings"
+	"sync"
 )
 
+// HPPOptions guard against HTTP parameter pollution: a parameter given twice where the
+// handler expects it once, so that checks and queries may see different values
 type HPPOptions struct {
-	CheckQuery                  bool
+	CheckQuery bool
+	// CheckBody covers form bodies of CheckBodyOnlyForContentType
 	CheckBody                   bool
 	CheckBodyOnlyForContentType string
-	Whitelist                   []string
+	// CheckJSON finds duplicate keys in JSON objects, at any depth
+	CheckJSON bool
+	// MaxJSONBodyBytes caps the JSON body read for CheckJSON, larger ones are answered 413
+	MaxJSONBodyBytes int64
+	// Whitelist are parameters that may repeat on every route, on top of the ones a route
+	// registered with AllowRepeatedParams
+	Whitelist []string
+	// Reject answers 400 instead of keeping the first value
+	Reject bool
+}
+
+var routeParams = struct {
+	sync.RWMutex
+	repeatable map[string][]string
+}{repeatable: map[string][]string{}}
+
+// AllowRepeatedParams lets the query parameters repeat on the route with the given mux
+// pattern, list routes use it for their filterable fields. It only takes effect when hpp
+// runs in a route group, where the pattern is known.
+func AllowRepeatedParams(pattern string, params ...string) {
+	routeParams.Lock()
+	defer routeParams.Unlock()
+	routeParams.repeatable[pattern] = append(routeParams.repeatable[pattern], params...)
+}
+
+func repeatable(r *http.Request, param string, whitelist []string) bool {
+	if isWhiteListed(param, whitelist) {
+		return true
+	}
+	routeParams.RLock()
+	defer routeParams.RUnlock()
+	return isWhiteListed(param, routeParams.repeatable[r.Pattern])
+}
+
+// errDuplicateParam carries the name of the repeated parameter for the 400
+type errDuplicateParam struct {
+	name string
+}
+
+func (e errDuplicateParam) Error() string {
+	return utils.DuplicateParameterError.Error() + ": " + e.name
 }
 
 func Hpp(options HPPOptions) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if options.CheckBody && r.Method == http.MethodPost && isCorrectContentType(r, options.CheckBodyOnlyForContentType) {
-				// filter the body params
-				filterBodyParams(r, options.Whitelist)
+			var err error
+			if options.CheckQuery && r.URL.RawQuery != "" {
+				err = filterQueryParams(r, options)
+			}
+			if err == nil && options.CheckBody && !isSafeMethod(r.Method) && isContentType(r, options.CheckBodyOnlyForContentType) {
+				err = filterBodyParams(r, options)
+			}
+			if err == nil && options.CheckJSON && !isSafeMethod(r.Method) && isJSON(r) {
+				err = filterJSONKeys(w, r, options)
 			}
-			if options.CheckQuery && r.URL.Query() != nil {
-				filterQueryParams(r, options.Whitelist)
+			if err != nil {
+				if appErr, ok := err.(*utils.AppErrors); ok {
+					http.Error(w, appErr.Error(), appErr.GetStatusCode())
+					return
+				}
+				http.Error(w, err.Error(), utils.DuplicateParameterError.GetStatusCode())
+				return
 			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-func isCorrectContentType(r *http.Request, contentType string) bool {
-	return strings.Contains(r.Header.Get("Content-Type"), contentType)
+func isContentType(r *http.Request, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == contentType
 }
 
-func filterQueryParams(r *http.Request, whitelist []string) {
-	query := r.URL.Query()
+func isJSON(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
 
-	for key, value := range query {
-		if len(value) > 1 {
-			query.Set(key, value[0]) // first value
-		}
-		if !isWhiteListed(key, whitelist) {
-			query.Del(key)
+// dedupeValues keeps the first value of every parameter that may not repeat
+func dedupeValues(r *http.Request, values url.Values, options HPPOptions) (bool, error) {
+	changed := false
+	for key, value := range values {
+		if len(value) > 1 && !repeatable(r, key, options.Whitelist) {
+			if options.Reject {
+				return false, errDuplicateParam{name: key}
+			}
+			values[key] = value[:1]
+			changed = true
 		}
 	}
-	r.URL.RawQuery = query.Encode()
+	return changed, nil
 }
 
-func filterBodyParams(r *http.Request, whitelist []string) {
+func filterQueryParams(r *http.Request, options HPPOptions) error {
+	query := r.URL.Query()
+	changed, err := dedupeValues(r, query, options)
+	if changed {
+		r.URL.RawQuery = query.Encode()
+	}
+	return err
+}
+
+func filterBodyParams(r *http.Request, options HPPOptions) error {
 	err := r.ParseForm()
 	if err != nil {
-		fmt.Println(err)
-		return
+		// the handler reports the malformed body
+		return nil
+	}
+	_, err = dedupeValues(r, r.PostForm, options)
+	if err != nil {
+		return err
 	}
-	for key, value := range r.Form {
-		if len(value) > 1 {
-			r.Form.Set(key, value[0]) // first value
+	_, err = dedupeValues(r, r.Form, options)
+	return err
+}
+
+// filterJSONKeys rewrites the body with the first of duplicate keys. encoding/json would
+// silently take the last one, so a validator and the handler could disagree.
+func filterJSONKeys(w http.ResponseWriter, r *http.Request, options HPPOptions) error {
+	// the whole body is held in memory, it must not be whatever size the client likes
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, options.MaxJSONBodyBytes))
+	r.Body.Close()
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return utils.RequestBodyTooLargeError
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil || len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var out bytes.Buffer
+	dup, err := copyJSON(decoder, &out)
+	if err != nil || decoder.More() {
+		// not for us to judge malformed JSON, the handler answers it
+		return nil
+	}
+	if dup == "" {
+		return nil
+	}
+	if options.Reject {
+		return errDuplicateParam{name: dup}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(out.Bytes()))
+	r.ContentLength = int64(out.Len())
+	return nil
+}
+
+// copyJSON copies one value from the decoder to out, dropping repeated keys of objects. It
+// returns the first repeated key it met, or "" when there was none.
+func copyJSON(decoder *json.Decoder, out *bytes.Buffer) (string, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return "", err
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		encoded, err := json.Marshal(token)
+		if err != nil {
+			return "", err
 		}
-		if !isWhiteListed(key, whitelist) {
-			delete(r.Form, key)
+		out.Write(encoded)
+		return "", nil
+	}
+
+	dup := ""
+	switch delim {
+	case '{':
+		out.WriteByte('{')
+		seen := map[string]bool{}
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return "", err
+			}
+			key, ok := token.(string)
+			if !ok {
+				return "", errors.New("object key is not a string")
+			}
+			target := out
+			if seen[key] {
+				if dup == "" {
+					dup = key
+				}
+				target = &bytes.Buffer{}
+			} else {
+				if len(seen) > 0 {
+					out.WriteByte(',')
+				}
+				seen[key] = true
+				encoded, _ := json.Marshal(key)
+				out.Write(encoded)
+				out.WriteByte(':')
+			}
+			inner, err := copyJSON(decoder, target)
+			if err != nil {
+				return "", err
+			}
+			if dup == "" {
+				dup = inner
+			}
+		}
+		out.WriteByte('}')
+	case '[':
+		out.WriteByte('[')
+		for i := 0; decoder.More(); i++ {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			inner, err := copyJSON(decoder, out)
+			if err != nil {
+				return "", err
+			}
+			if dup == "" {
+				dup = inner
+			}
 		}
+		out.WriteByte(']')
 	}
+	// the closing delimiter
+	_, err = decoder.Token()
+	return dup, err
 }
 
 func isWhiteListed(param string, whitelist []string) bool {
@@ -0,0 +1,112 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCopyJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+		dup  string
+	}{
+		{name: "no duplicates", in: `{"a":1,"b":"x"}`, want: `{"a":1,"b":"x"}`},
+		{name: "first value kept", in: `{"role":"teacher","role":"admin"}`, want: `{"role":"teacher"}`, dup: "role"},
+		{name: "duplicate holding an object", in: `{"a":{"x":1},"b":2,"a":{"y":2}}`, want: `{"a":{"x":1},"b":2}`, dup: "a"},
+		{name: "nested duplicate", in: `{"user":{"name":"a","role":"teacher","role":"admin"}}`, want: `{"user":{"name":"a","role":"teacher"}}`, dup: "role"},
+		{name: "first duplicate reported", in: `{"a":{"b":1,"b":2},"c":1,"c":2}`, want: `{"a":{"b":1},"c":1}`, dup: "b"},
+		{name: "same key in sibling objects", in: `[{"id":1},{"id":2}]`, want: `[{"id":1},{"id":2}]`},
+		{name: "duplicates inside arrays", in: `[{"id":1,"id":2},[{"k":true,"k":false}]]`, want: `[{"id":1},[{"k":true}]]`, dup: "id"},
+		{name: "empty containers", in: `{"a":[],"b":{}}`, want: `{"a":[],"b":{}}`},
+		{name: "numbers keep their text", in: `{"id":12345678901234567890,"fee":1.10,"exp":1e400}`, want: `{"id":12345678901234567890,"fee":1.10,"exp":1e400}`},
+		// strings are encoded again by encoding/json, which escapes HTML
+		{name: "escaped strings", in: `{"a\"b":"line\nbreak <tag>"}`, want: `{"a\"b":"line\nbreak \u003ctag\u003e"}`},
+		{name: "scalar", in: `null`, want: `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder := json.NewDecoder(strings.NewReader(tt.in))
+			decoder.UseNumber()
+			var out bytes.Buffer
+			dup, err := copyJSON(decoder, &out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dup != tt.dup {
+				t.Errorf("copyJSON() duplicate = %q, want %q", dup, tt.dup)
+			}
+			if out.String() != tt.want {
+				t.Errorf("copyJSON() = %s, want %s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCopyJSONMalformed(t *testing.T) {
+	for _, in := range []string{`{"a":1`, `{"a" 1}`, `[1,]`, `{"a":}`} {
+		decoder := json.NewDecoder(strings.NewReader(in))
+		decoder.UseNumber()
+		_, err := copyJSON(decoder, &bytes.Buffer{})
+		if err == nil {
+			t.Errorf("copyJSON(%s) accepted malformed JSON", in)
+		}
+	}
+}
+
+// hppBody runs a JSON body through hpp and returns the status and the body the handler read
+func hppBody(t *testing.T, options HPPOptions, body string) (int, string) {
+	t.Helper()
+	var got string
+	handler := Hpp(options)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = string(data)
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	return rec.Code, got
+}
+
+func TestHppJSON(t *testing.T) {
+	options := HPPOptions{CheckJSON: true, MaxJSONBodyBytes: 64}
+	tests := []struct {
+		name   string
+		reject bool
+		body   string
+		status int
+		want   string
+	}{
+		{name: "duplicate dropped", body: `{"role":"teacher","role":"admin"}`, status: http.StatusOK, want: `{"role":"teacher"}`},
+		{name: "duplicate rejected", reject: true, body: `{"role":"teacher","role":"admin"}`, status: http.StatusBadRequest},
+		{name: "clean body untouched", body: `{ "a": 1.50 }`, status: http.StatusOK, want: `{ "a": 1.50 }`},
+		// a second value after the first isn't rewritten, the handler decides what it makes of it
+		{name: "trailing value", body: `{"a":1,"a":2} {"b":1}`, status: http.StatusOK, want: `{"a":1,"a":2} {"b":1}`},
+		{name: "trailing garbage", body: `{"a":1,"a":2}garbage`, status: http.StatusOK, want: `{"a":1,"a":2}garbage`},
+		{name: "malformed", body: `{"a":1,"a":`, status: http.StatusOK, want: `{"a":1,"a":`},
+		{name: "body at the limit", body: `{"a":"` + strings.Repeat("x", 56) + `"}`, status: http.StatusOK, want: `{"a":"` + strings.Repeat("x", 56) + `"}`},
+		{name: "body over the limit", body: `{"a":"` + strings.Repeat("x", 57) + `"}`, status: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options.Reject = tt.reject
+			status, got := hppBody(t, options, tt.body)
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d", status, tt.status)
+			}
+			if status == http.StatusOK && got != tt.want {
+				t.Errorf("handler read %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
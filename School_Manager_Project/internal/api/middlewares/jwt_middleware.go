@@ -3,34 +3,40 @@ package middlewares
 import (
 	"context"
 	"errors"
-	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"net/http"
-	"os"
+	"restapi/internal/keyring"
+	"restapi/internal/sqlconnect"
 	"restapi/utils"
+	"strings"
 )
 
 func JWTMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token, err := r.Cookie("Bearer")
-		if err != nil {
-			http.Error(w, "Authorization header is missing", http.StatusUnauthorized)
+		// integrations authenticate with API keys on the same routes
+		if apiKey, ok := apiKeyFromRequest(r); ok {
+			serveAPIKey(w, r, apiKey, next)
 			return
 		}
-		jwtSecret := os.Getenv("JWT_SECRET")
 
-		parsedToken, err := jwt.Parse(token.Value, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, utils.UnexpectedSigningMethodError
-			}
-			return []byte(jwtSecret), nil
-		})
+		token, ok := tokenFromRequest(r)
+		if !ok {
+			http.Error(w, utils.MissingTokenError.Error(), utils.MissingTokenError.GetStatusCode())
+			return
+		}
+
+		// the kid header picks the key, see internal/keyring
+		parsedToken, err := jwt.Parse(token, keyring.Keyfunc, jwt.WithValidMethods(keyring.ValidMethods()))
+		var appErr *utils.AppErrors
 		if errors.Is(err, jwt.ErrTokenExpired) {
 			http.Error(w, utils.TokenExpiredError.Error(), utils.TokenExpiredError.GetStatusCode())
 			return
-		} else if appErr, ok := err.(*utils.AppErrors); ok {
+		} else if errors.As(err, &appErr) {
 			http.Error(w, appErr.Error(), appErr.GetStatusCode())
 			return
+		} else if errors.Is(err, jwt.ErrTokenSignatureInvalid) || errors.Is(err, jwt.ErrTokenMalformed) || errors.Is(err, jwt.ErrTokenUnverifiable) {
+			http.Error(w, utils.InvalidLoginTokenError.Error(), utils.InvalidLoginTokenError.GetStatusCode())
+			return
 		} else if err != nil {
 			http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
 			return
@@ -41,7 +47,26 @@ func JWTMiddleware(next http.Handler) http.Handler {
 		}
 		claims, ok := parsedToken.Claims.(jwt.MapClaims)
 		if !ok {
-			fmt.Println(err)
+			http.Error(w, utils.InvalidLoginTokenError.Error(), utils.InvalidLoginTokenError.GetStatusCode())
+			return
+		}
+
+		// tokens revoked by logout, password change, an admin or an ended session are rejected before they expire
+		uid, _ := claims["uid"].(float64)
+		jti, _ := claims["jti"].(string)
+		familyID, _ := claims["fid"].(string)
+		tokenVersion, _ := claims["ver"].(float64)
+		currentVersion, revoked, err := sqlconnect.GetTokenStateDBHandler(r.Context(), int(uid), jti, familyID)
+		if err != nil {
+			if appErr, ok := err.(*utils.AppErrors); ok {
+				http.Error(w, appErr.Error(), appErr.GetStatusCode())
+				return
+			}
+			http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+			return
+		}
+		if revoked || int(tokenVersion) != currentVersion {
+			http.Error(w, utils.TokenRevokedError.Error(), utils.TokenRevokedError.GetStatusCode())
 			return
 		}
 
@@ -49,7 +74,31 @@ func JWTMiddleware(next http.Handler) http.Handler {
 		ctx = context.WithValue(ctx, "expiresAt", claims["exp"])
 		ctx = context.WithValue(ctx, "username", claims["user"])
 		ctx = context.WithValue(ctx, "role", claims["role"])
+		ctx = context.WithValue(ctx, "jti", jti)
+		ctx = context.WithValue(ctx, "familyId", familyID)
+		ctx = context.WithValue(ctx, "mfa", claims["mfa"] == true)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// tokenFromRequest prefers the Authorization header used by API clients over the cookie set for browsers
+func tokenFromRequest(r *http.Request) (string, bool) {
+	if token, ok := bearerToken(r); ok {
+		return token, true
+	}
+	cookie, err := r.Cookie(utils.AccessCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
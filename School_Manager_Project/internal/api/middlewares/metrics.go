@@ -0,0 +1,37 @@
+package middlewares
+
+import (
+	"net/http"
+	"restapi/internal/logging"
+	"restapi/internal/metrics"
+	"strconv"
+	"time"
+)
+
+// Metrics counts requests and observes their latency by route pattern, so that IDs and
+// tokens in paths don't turn into labels. Requests no route matched are labelled "unmatched".
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics.RequestsInFlight.Inc()
+		defer metrics.RequestsInFlight.Dec()
+
+		// the group chains fill in the route, without request_id the info starts here
+		info := logging.Request(r.Context())
+		if info == nil {
+			info = &logging.RequestInfo{}
+			r = r.WithContext(logging.WithRequest(r.Context(), info))
+		}
+
+		start := time.Now()
+		wrappedWriter := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(wrappedWriter, r)
+
+		route := info.Route
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(wrappedWriter.status)
+		metrics.RequestsTotal.WithLabelValues(route, r.Method, status).Inc()
+		metrics.RequestDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	})
+}
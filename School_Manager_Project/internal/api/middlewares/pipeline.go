@@ -0,0 +1,398 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type Middleware func(http.Handler) http.Handler
+
+// Route groups, every route is registered in exactly one of them by the router
+const (
+	GroupPublic        = "public"
+	GroupAuthenticated = "authenticated"
+	GroupAdmin         = "admin"
+)
+
+// MiddlewareConfig turns one middleware on or off and carries its options
+type MiddlewareConfig struct {
+	Name string `json:"name"`
+	// Enabled defaults to true
+	Enabled *bool           `json:"enabled,omitempty"`
+	Options json.RawMessage `json:"options,omitempty"`
+}
+
+func (c MiddlewareConfig) enabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
+// PipelineConfig is read from the file in MIDDLEWARE_CONFIG. Global middlewares wrap the
+// whole mux, the ones of a group only the routes registered in it.
+type PipelineConfig struct {
+	Global []MiddlewareConfig            `json:"global"`
+	Groups map[string][]MiddlewareConfig `json:"groups"`
+}
+
+func disabled() *bool {
+	off := false
+	return &off
+}
+
+// loginRateLimit guards the routes that check passwords and codes more strictly
+var loginRateLimit = json.RawMessage(`{"policies": [{
+	"name": "login",
+	"limit": 5,
+	"window": "1m",
+	"routes": ["POST /execs/login", "POST /execs/login/mfa", "POST /execs/forgotPassword",
+		"POST /execs/resetPassword/reset/{resetCode}", "POST /execs/invite/accept/{token}"]
+}]}`)
+
+// DefaultPipelineConfig is used when no config file exists
+func DefaultPipelineConfig() PipelineConfig {
+	return PipelineConfig{
+		Global: []MiddlewareConfig{
+			{Name: "tracing"},
+			{Name: "request_id"},
+			{Name: "metrics"},
+			{Name: "client_ip"},
+			{Name: "response_time"},
+			{Name: "security_headers"},
+			{Name: "cors", Enabled: disabled()},
+			{Name: "compression", Enabled: disabled()},
+		},
+		// hpp in the groups, where the route and so its repeatable parameters are known
+		Groups: map[string][]MiddlewareConfig{
+			GroupPublic:        {{Name: "hpp"}, {Name: "csrf"}, {Name: "rate_limit", Options: loginRateLimit}},
+			GroupAuthenticated: {{Name: "hpp"}, {Name: "csrf"}, {Name: "auth_rate_limit"}, {Name: "auth"}, {Name: "rate_limit"}},
+			GroupAdmin:         {{Name: "hpp"}, {Name: "csrf"}, {Name: "auth_rate_limit"}, {Name: "auth"}, {Name: "rate_limit"}},
+		},
+	}
+}
+
+// middlewareFactory builds a middleware from its options, order fixes its place in a chain
+// no matter where it is listed in the config: lower runs first
+type middlewareFactory struct {
+	order int
+	build func(options json.RawMessage) (Middleware, error)
+	// buildScoped replaces build for middlewares keeping state apart per chain, scope is
+	// "global" or the name of the group
+	buildScoped func(scope string, options json.RawMessage) (Middleware, error)
+}
+
+func withoutOptions(mw Middleware) func(json.RawMessage) (Middleware, error) {
+	return func(options json.RawMessage) (Middleware, error) {
+		if len(bytes.TrimSpace(options)) > 0 && string(bytes.TrimSpace(options)) != "null" {
+			return nil, errors.New("takes no options")
+		}
+		return mw, nil
+	}
+}
+
+var factories = map[string]middlewareFactory{
+	// outermost, the spans of everything else belong to the request span
+	"tracing": {order: 0, build: withoutOptions(Tracing)},
+	// so every log line of the request carries its ID
+	"request_id": {order: 1, build: withoutOptions(RequestID)},
+	// around everything else, it times what the client waits for
+	"metrics": {order: 2, build: withoutOptions(Metrics)},
+	// everything after it sees the client behind the proxies
+	"client_ip":        {order: 5, build: buildClientIP},
+	"response_time":    {order: 10, build: withoutOptions(ResponseTimeMiddleware)},
+	"security_headers": {order: 20, build: buildSecurityHeaders},
+	"cors":             {order: 30, build: buildCors},
+	"compression":      {order: 40, build: buildCompression},
+	"hpp":              {order: 60, build: buildHpp},
+	"csrf":             {order: 70, build: withoutOptions(CSRFMiddleware)},
+	// before auth, so clients failing it are stopped before its database lookups
+	"auth_rate_limit": {order: 75, buildScoped: buildAuthRateLimit},
+	"auth":            {order: 80, build: withoutOptions(JWTMiddleware)},
+	// after auth so authenticated requests are counted per user or API key
+	"rate_limit": {order: 90, buildScoped: buildRateLimit},
+}
+
+// decodeOptions rejects unknown fields so a typo in the config doesn't go unnoticed
+func decodeOptions(options json.RawMessage, v interface{}) error {
+	if len(bytes.TrimSpace(options)) == 0 {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(options))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+type securityHeadersOptions struct {
+	// Headers adds or overrides headers, an empty value removes one
+	Headers map[string]string `json:"headers"`
+}
+
+func buildSecurityHeaders(options json.RawMessage) (Middleware, error) {
+	var opts securityHeadersOptions
+	err := decodeOptions(options, &opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Headers) == 0 {
+		return SecurityHeaders, nil
+	}
+	return func(next http.Handler) http.Handler {
+		return SecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for name, value := range opts.Headers {
+				if value == "" {
+					w.Header().Del(name)
+				} else {
+					w.Header().Set(name, value)
+				}
+			}
+			next.ServeHTTP(w, r)
+		}))
+	}, nil
+}
+
+// buildRateLimit falls back to RATE_LIMIT and RATE_LIMIT_WINDOW, then 100 requests a minute
+func buildRateLimit(scope string, options json.RawMessage) (Middleware, error) {
+	var opts RateLimitOptions
+	opts.Limit, _ = strconv.Atoi(os.Getenv("RATE_LIMIT"))
+	opts.Window = os.Getenv("RATE_LIMIT_WINDOW")
+	err := decodeOptions(options, &opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = 100
+	}
+	if window, err := time.ParseDuration(opts.Window); err != nil || window <= 0 {
+		opts.Window = "1m"
+	}
+	store, err := rateLimitStore(opts.Store)
+	if err != nil {
+		return nil, err
+	}
+	rl, err := NewRateLimiter(scope, opts, store)
+	if err != nil {
+		return nil, err
+	}
+	return rl.Middleware, nil
+}
+
+func buildAuthRateLimit(scope string, options json.RawMessage) (Middleware, error) {
+	var opts AuthRateLimitOptions
+	err := decodeOptions(options, &opts)
+	if err != nil {
+		return nil, err
+	}
+	store, err := rateLimitStore(opts.Store)
+	if err != nil {
+		return nil, err
+	}
+	limiter, err := NewAuthRateLimiter(scope, opts, store)
+	if err != nil {
+		return nil, err
+	}
+	return limiter.Middleware, nil
+}
+
+func buildCors(options json.RawMessage) (Middleware, error) {
+	var opts CorsOptions
+	err := decodeOptions(options, &opts)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCors(opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.Middleware, nil
+}
+
+func buildCompression(options json.RawMessage) (Middleware, error) {
+	var opts CompressionOptions
+	err := decodeOptions(options, &opts)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCompression(opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.Middleware, nil
+}
+
+func buildClientIP(options json.RawMessage) (Middleware, error) {
+	var opts ClientIPOptions
+	err := decodeOptions(options, &opts)
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := NewClientIPResolver(opts)
+	if err != nil {
+		return nil, err
+	}
+	return resolver.Middleware, nil
+}
+
+type hppOptions struct {
+	CheckQuery                  bool     `json:"check_query"`
+	CheckBody                   bool     `json:"check_body"`
+	CheckBodyOnlyForContentType string   `json:"check_body_only_for_content_type"`
+	CheckJSON                   bool     `json:"check_json"`
+	MaxJSONBodyBytes            int64    `json:"max_json_body_bytes"`
+	Whitelist                   []string `json:"whitelist"`
+	Reject                      bool     `json:"reject"`
+}
+
+func buildHpp(options json.RawMessage) (Middleware, error) {
+	opts := hppOptions{
+		CheckQuery:                  true,
+		CheckBody:                   true,
+		CheckBodyOnlyForContentType: "application/x-www-form-urlencoded",
+		CheckJSON:                   true,
+		MaxJSONBodyBytes:            1 << 20,
+	}
+	err := decodeOptions(options, &opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxJSONBodyBytes <= 0 {
+		return nil, fmt.Errorf("max_json_body_bytes must be positive")
+	}
+	return Hpp(HPPOptions{
+		CheckQuery:                  opts.CheckQuery,
+		CheckBody:                   opts.CheckBody,
+		CheckBodyOnlyForContentType: opts.CheckBodyOnlyForContentType,
+		CheckJSON:                   opts.CheckJSON,
+		MaxJSONBodyBytes:            opts.MaxJSONBodyBytes,
+		Whitelist:                   opts.Whitelist,
+		Reject:                      opts.Reject,
+	}), nil
+}
+
+// Pipeline holds the built chains, see LoadPipeline
+type Pipeline struct {
+	global Middleware
+	groups map[string]Middleware
+}
+
+// LoadPipeline reads the config file at path, or uses DefaultPipelineConfig when path is
+// empty and middlewares.json doesn't exist
+func LoadPipeline(path string) (*Pipeline, error) {
+	if path == "" {
+		path = "middlewares.json"
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return NewPipeline(DefaultPipelineConfig())
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading middleware config: %w", err)
+	}
+	var cfg PipelineConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&cfg)
+	if err != nil {
+		return nil, fmt.Errorf("parsing middleware config %s: %w", path, err)
+	}
+	return NewPipeline(cfg)
+}
+
+func NewPipeline(cfg PipelineConfig) (*Pipeline, error) {
+	global, err := buildChain("global", cfg.Global)
+	if err != nil {
+		return nil, fmt.Errorf("global middlewares: %w", err)
+	}
+	p := &Pipeline{global: global, groups: map[string]Middleware{}}
+	for _, group := range []string{GroupPublic, GroupAuthenticated, GroupAdmin} {
+		chain, err := buildChain(group, cfg.Groups[group])
+		if err != nil {
+			return nil, fmt.Errorf("%s middlewares: %w", group, err)
+		}
+		p.groups[group] = chain
+	}
+	for group := range cfg.Groups {
+		if _, ok := p.groups[group]; !ok {
+			return nil, fmt.Errorf("unknown route group %q", group)
+		}
+	}
+	// a protected group without authentication would expose every route in it
+	for _, group := range []string{GroupAuthenticated, GroupAdmin} {
+		if !hasEnabled(cfg.Groups[group], "auth") {
+			return nil, fmt.Errorf("%s middlewares: auth can't be left out", group)
+		}
+	}
+	return p, nil
+}
+
+func hasEnabled(configs []MiddlewareConfig, name string) bool {
+	for _, c := range configs {
+		if c.Name == name && c.enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+func buildChain(scope string, configs []MiddlewareConfig) (Middleware, error) {
+	type entry struct {
+		order int
+		mw    Middleware
+	}
+	var chain []entry
+	seen := map[string]bool{}
+	for _, c := range configs {
+		factory, ok := factories[c.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware %q", c.Name)
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("middleware %q is listed twice", c.Name)
+		}
+		seen[c.Name] = true
+		if !c.enabled() {
+			continue
+		}
+		var mw Middleware
+		var err error
+		if factory.buildScoped != nil {
+			mw, err = factory.buildScoped(scope, c.Options)
+		} else {
+			mw, err = factory.build(c.Options)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.Name, err)
+		}
+		if c.Name != "tracing" {
+			mw = tracedMiddleware(c.Name, mw)
+		}
+		chain = append(chain, entry{order: factory.order, mw: mw})
+	}
+	sort.SliceStable(chain, func(i, j int) bool { return chain[i].order < chain[j].order })
+
+	return func(handler http.Handler) http.Handler {
+		// wrap from the inside out so the lowest order ends up outermost
+		for i := len(chain) - 1; i >= 0; i-- {
+			handler = chain[i].mw(handler)
+		}
+		return handler
+	}, nil
+}
+
+// Global wraps the mux with the middlewares every request goes through
+func (p *Pipeline) Global(handler http.Handler) http.Handler {
+	return p.global(handler)
+}
+
+// Group wraps one route with the chain of its group
+func (p *Pipeline) Group(group string, handler http.Handler) http.Handler {
+	chain, ok := p.groups[group]
+	if !ok {
+		panic("unknown route group " + group)
+	}
+	return routeInfo(chain(userInfo(tracedHandler(handler))))
+}
@@ -0,0 +1,180 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitStore keeps the token buckets of the rate limiter. Take refills the bucket at key
+// up to burst at ratePerSecond, removes cost tokens if there are that many and returns the
+// tokens left. A cost of 0 only looks at the bucket. A bucket not taken from for ttl may be
+// dropped, it would be full again anyway.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, cost int, burst int, ratePerSecond float64, ttl time.Duration, now time.Time) (bool, float64, error)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	ttl    time.Duration
+}
+
+// memoryStore is the default store, limits are counted per process
+type memoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewMemoryRateLimitStore returns a store that evicts idle buckets every minute
+func NewMemoryRateLimitStore() RateLimitStore {
+	m := &memoryStore{buckets: map[string]*bucket{}}
+	go m.evictIdle()
+	return m
+}
+
+func (m *memoryStore) Take(ctx context.Context, key string, cost int, burst int, ratePerSecond float64, ttl time.Duration, now time.Time) (bool, float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		m.buckets[key] = b
+	}
+	b.ttl = ttl
+	if now.After(b.last) {
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*ratePerSecond)
+		b.last = now
+	}
+	if b.tokens < float64(cost) {
+		return false, b.tokens, nil
+	}
+	b.tokens -= float64(cost)
+	return true, b.tokens, nil
+}
+
+func (m *memoryStore) evictIdle() {
+	for {
+		time.Sleep(time.Minute)
+		m.evict(time.Now())
+	}
+}
+
+// evict drops the buckets that have been full again for a while
+func (m *memoryStore) evict(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, b := range m.buckets {
+		if now.Sub(b.last) > b.ttl {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// takeScript is the token bucket of memoryStore.Take run atomically by Redis. The time comes
+// from the caller so the script also runs on stand-ins like miniredis.
+var takeScript = redis.NewScript(`
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local tokens = tonumber(bucket[1])
+local last = tonumber(bucket[2])
+if tokens == nil or last == nil then
+	tokens = burst
+	last = now
+end
+if now > last then
+	tokens = math.min(burst, tokens + (now - last) / 1000 * rate)
+	last = now
+end
+local cost = tonumber(ARGV[5])
+local allowed = 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(last))
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return {allowed, tostring(tokens)}
+`)
+
+// redisStore shares the buckets between every instance of the API using the same Redis
+type redisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisRateLimitStore keeps the buckets under "ratelimit:" keys of the client
+func NewRedisRateLimitStore(client redis.UniversalClient) RateLimitStore {
+	return &redisStore{client: client, prefix: "ratelimit:"}
+}
+
+func (s *redisStore) Take(ctx context.Context, key string, cost int, burst int, ratePerSecond float64, ttl time.Duration, now time.Time) (bool, float64, error) {
+	ttlMillis := ttl.Milliseconds()
+	if ttlMillis < 1000 {
+		ttlMillis = 1000
+	}
+	result, err := takeScript.Run(ctx, s.client, []string{s.prefix + key},
+		burst, strconv.FormatFloat(ratePerSecond, 'f', -1, 64), now.UnixMilli(), ttlMillis, cost).Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(result) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit script result %v", result)
+	}
+	allowed, _ := result[0].(int64)
+	tokensText, _ := result[1].(string)
+	tokens, err := strconv.ParseFloat(tokensText, 64)
+	if err != nil {
+		return false, 0, err
+	}
+	return allowed == 1, tokens, nil
+}
+
+var (
+	storesMu sync.Mutex
+	stores   = map[string]RateLimitStore{}
+)
+
+// rateLimitStore returns the store named in the config, shared by every rate_limit entry
+// whose keys carry their scope: "memory" (default) or "redis", connecting to RATE_LIMIT_REDIS_URL
+func rateLimitStore(name string) (RateLimitStore, error) {
+	if name == "" {
+		name = os.Getenv("RATE_LIMIT_STORE")
+	}
+	if name == "" {
+		name = "memory"
+	}
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	if store, ok := stores[name]; ok {
+		return store, nil
+	}
+
+	var store RateLimitStore
+	switch name {
+	case "memory":
+		store = NewMemoryRateLimitStore()
+	case "redis":
+		redisURL := os.Getenv("RATE_LIMIT_REDIS_URL")
+		if redisURL == "" {
+			return nil, fmt.Errorf("RATE_LIMIT_REDIS_URL is required for the redis store")
+		}
+		options, err := redis.ParseURL(redisURL)
+		if err != nil {
+			return nil, fmt.Errorf("RATE_LIMIT_REDIS_URL: %w", err)
+		}
+		store = NewRedisRateLimitStore(redis.NewClient(options))
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", name)
+	}
+	stores[name] = store
+	return store, nil
+}
@@ -0,0 +1,127 @@
+package middlewares
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// takeAll runs the same takes against a store: a burst of 3 at one token a second
+func takeAll(t *testing.T, store RateLimitStore) {
+	t.Helper()
+	ctx := context.Background()
+	start := time.Unix(1700000000, 0)
+	take := func(key string, at time.Duration) (bool, float64) {
+		t.Helper()
+		allowed, tokens, err := store.Take(ctx, key, 1, 3, 1, time.Minute, start.Add(at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return allowed, tokens
+	}
+
+	for i := 0; i < 3; i++ {
+		if allowed, _ := take("a", 0); !allowed {
+			t.Fatalf("take %d of the burst refused", i+1)
+		}
+	}
+	if allowed, tokens := take("a", 0); allowed || tokens != 0 {
+		t.Fatalf("take beyond the burst = %v with %v tokens, want refused with 0", allowed, tokens)
+	}
+	// half a second refills half a token, not enough
+	if allowed, tokens := take("a", 500*time.Millisecond); allowed || math.Abs(tokens-0.5) > 1e-9 {
+		t.Fatalf("take after 0.5s = %v with %v tokens, want refused with 0.5", allowed, tokens)
+	}
+	if allowed, tokens := take("a", 1500*time.Millisecond); !allowed || math.Abs(tokens-0.5) > 1e-9 {
+		t.Fatalf("take after 1.5s = %v with %v tokens, want allowed with 0.5", allowed, tokens)
+	}
+	// a long pause refills up to the burst, not beyond
+	if allowed, tokens := take("a", time.Hour); !allowed || tokens != 2 {
+		t.Fatalf("take after an hour = %v with %v tokens, want allowed with 2", allowed, tokens)
+	}
+	// a cost of 0 looks without taking
+	for i := 0; i < 2; i++ {
+		allowed, tokens, err := store.Take(ctx, "a", 0, 3, 1, time.Minute, start.Add(time.Hour))
+		if err != nil || !allowed || tokens != 2 {
+			t.Fatalf("look at the bucket = %v with %v tokens (%v), want 2 tokens", allowed, tokens, err)
+		}
+	}
+	// other keys have their own bucket
+	if allowed, tokens := take("b", 0); !allowed || tokens != 2 {
+		t.Fatalf("take from another key = %v with %v tokens, want allowed with 2", allowed, tokens)
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	takeAll(t, &memoryStore{buckets: map[string]*bucket{}})
+}
+
+func TestRedisRateLimitStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	takeAll(t, NewRedisRateLimitStore(client))
+
+	if !server.Exists("ratelimit:a") {
+		t.Fatal("bucket not stored under the ratelimit: prefix")
+	}
+	if ttl := server.TTL("ratelimit:a"); ttl != time.Minute {
+		t.Fatalf("bucket expires in %v, want 1m", ttl)
+	}
+	server.FastForward(time.Minute)
+	if server.Exists("ratelimit:a") {
+		t.Fatal("idle bucket didn't expire")
+	}
+}
+
+func TestMemoryRateLimitStoreEvict(t *testing.T) {
+	store := &memoryStore{buckets: map[string]*bucket{}}
+	ctx := context.Background()
+	now := time.Now()
+	store.Take(ctx, "idle", 1, 5, 1, 5*time.Second, now)
+	store.Take(ctx, "busy", 1, 5, 1, 5*time.Second, now.Add(4*time.Second))
+
+	store.evict(now.Add(6 * time.Second))
+	if _, ok := store.buckets["idle"]; ok {
+		t.Error("bucket idle for longer than its ttl kept")
+	}
+	if _, ok := store.buckets["busy"]; !ok {
+		t.Error("bucket within its ttl evicted")
+	}
+}
+
+func TestRateLimiterScopes(t *testing.T) {
+	store := &memoryStore{buckets: map[string]*bucket{}}
+	limiter := func(scope string, limit int) http.Handler {
+		rl, err := NewRateLimiter(scope, RateLimitOptions{RateLimitPolicy: RateLimitPolicy{Limit: limit, Window: "1m"}}, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	}
+	admin := limiter(GroupAdmin, 1)
+	authenticated := limiter(GroupAuthenticated, 2)
+
+	status := func(h http.Handler) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+	if code := status(admin); code != http.StatusOK {
+		t.Fatalf("first admin request = %d", code)
+	}
+	if code := status(admin); code != http.StatusTooManyRequests {
+		t.Fatalf("second admin request = %d, want 429", code)
+	}
+	// both default policies are named "default", the admin bucket must not be the same
+	for i := 0; i < 2; i++ {
+		if code := status(authenticated); code != http.StatusOK {
+			t.Fatalf("authenticated request %d = %d, want 200", i+1, code)
+		}
+	}
+}
@@ -2,49 +2,168 @@ package middlewares
 
 import (
 	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
-	"sync"
+	"restapi/internal/metrics"
+	"restapi/utils"
+	"strconv"
 	"time"
 )
 
+// RateLimitPolicy is a token bucket: Burst requests at once, refilled at Limit per Window
+type RateLimitPolicy struct {
+	Name   string   `json:"name"`
+	Limit  int      `json:"limit"`
+	Window string   `json:"window"`
+	Burst  int      `json:"burst"`
+	Routes []string `json:"routes"`
+
+	window time.Duration
+}
+
+// RateLimitOptions has the default policy and the policies of specific routes. Routes are
+// mux patterns like "POST /execs/login", they are only known inside a route group chain.
+type RateLimitOptions struct {
+	RateLimitPolicy
+	Policies []RateLimitPolicy `json:"policies"`
+	// Store is "memory" or "redis", see rateLimitStore
+	Store string `json:"store"`
+}
+
+func (p *RateLimitPolicy) normalize(name string) error {
+	if p.Name == "" {
+		p.Name = name
+	}
+	if p.Limit <= 0 {
+		return fmt.Errorf("policy %s: limit must be positive", p.Name)
+	}
+	window, err := time.ParseDuration(p.Window)
+	if err != nil || window <= 0 {
+		return fmt.Errorf("policy %s: invalid window %q", p.Name, p.Window)
+	}
+	p.window = window
+	if p.Burst <= 0 {
+		p.Burst = p.Limit
+	}
+	return nil
+}
+
+// rate is the refill speed in tokens per second
+func (p *RateLimitPolicy) rate() float64 {
+	return float64(p.Limit) / p.window.Seconds()
+}
+
+// rateDecision is the outcome of one request against a bucket
+type rateDecision struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration // until the bucket is full again
+	retryAfter time.Duration // until the next request is allowed, zero when allowed
+}
+
+// decide describes the bucket after a request took from it, or was refused
+func decide(allowed bool, tokens float64, policy *RateLimitPolicy) rateDecision {
+	decision := rateDecision{allowed: allowed, remaining: int(tokens)}
+	if !allowed {
+		decision.retryAfter = time.Duration((1 - tokens) / policy.rate() * float64(time.Second))
+	}
+	decision.reset = time.Duration((float64(policy.Burst) - tokens) / policy.rate() * float64(time.Second))
+	return decision
+}
+
 type rateLimiter struct {
-	mu        sync.Mutex
-	visitors  map[string]int
-	limit     int
-	resetTime time.Duration
+	// scope keeps the buckets of limiters in different chains apart, even in a shared store
+	scope         string
+	defaultPolicy RateLimitPolicy
+	routes        map[string]*RateLimitPolicy
+	store         RateLimitStore
 }
 
-func NewRateLimiter(limit int, resetTime time.Duration) *rateLimiter {
-	rl := &rateLimiter{
-		visitors:  make(map[string]int),
-		limit:     limit,
-		resetTime: resetTime,
+// NewRateLimiter checks the policies and keeps the buckets in store under the given scope,
+// the chain the limiter runs in
+func NewRateLimiter(scope string, options RateLimitOptions, store RateLimitStore) (*rateLimiter, error) {
+	rl := &rateLimiter{scope: scope, defaultPolicy: options.RateLimitPolicy, routes: map[string]*RateLimitPolicy{}, store: store}
+	if len(rl.defaultPolicy.Routes) > 0 {
+		return nil, fmt.Errorf("routes belong in policies")
 	}
-	go rl.resetVisitorCount()
-	return rl
+	err := rl.defaultPolicy.normalize("default")
+	if err != nil {
+		return nil, err
+	}
+	for i := range options.Policies {
+		policy := &options.Policies[i]
+		if policy.Window == "" {
+			policy.Window = rl.defaultPolicy.Window
+		}
+		err = policy.normalize(fmt.Sprintf("policy%d", i+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(policy.Routes) == 0 {
+			return nil, fmt.Errorf("policy %s: routes are required", policy.Name)
+		}
+		for _, route := range policy.Routes {
+			if _, taken := rl.routes[route]; taken {
+				return nil, fmt.Errorf("policy %s: route %q already has a policy", policy.Name, route)
+			}
+			rl.routes[route] = policy
+		}
+	}
+	return rl, nil
+}
+
+// maxRefill is how long an empty bucket of the policy takes to fill up
+func maxRefill(policy *RateLimitPolicy) time.Duration {
+	return time.Duration(float64(policy.Burst) / policy.rate() * float64(time.Second))
+}
+
+func (rl *rateLimiter) policyFor(r *http.Request) *RateLimitPolicy {
+	if policy, ok := rl.routes[r.Pattern]; ok {
+		return policy
+	}
+	return &rl.defaultPolicy
 }
 
-func (rl *rateLimiter) resetVisitorCount() {
-	for {
-		time.Sleep(rl.resetTime)
-		rl.mu.Lock()
-		rl.visitors = make(map[string]int)
-		rl.mu.Unlock()
+// rateLimitKey is the API key or user when the request is authenticated, the client IP otherwise
+func rateLimitKey(r *http.Request) string {
+	if id, ok := r.Context().Value("apiKeyId").(int); ok {
+		return "apikey:" + strconv.Itoa(id)
+	}
+	if uid, ok := r.Context().Value("userId").(float64); ok {
+		return "user:" + strconv.Itoa(int(uid))
 	}
+	return "ip:" + utils.ClientIP(r)
 }
 
 func (rl *rateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		rl.mu.Lock()
-		defer rl.mu.Unlock()
-		visitorIP := r.RemoteAddr // ip of the client
-		rl.visitors[visitorIP]++
-		fmt.Printf("Visitor Count from %v is %v\n", visitorIP, rl.visitors[visitorIP])
-
-		if rl.visitors[visitorIP] > rl.limit {
-			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		policy := rl.policyFor(r)
+		allowed, tokens, err := rl.store.Take(r.Context(), rl.scope+"|"+policy.Name+"|"+rateLimitKey(r), 1, policy.Burst, policy.rate(), maxRefill(policy), time.Now())
+		if err != nil {
+			// an unreachable store must not take the API down with it
+			slog.ErrorContext(r.Context(), "rate limit store failed, request let through", "err", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		decision := decide(allowed, tokens, policy)
+
+		// RateLimit header fields, draft-ietf-httpapi-ratelimit-headers
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.reset)))
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", policy.Limit, ceilSeconds(policy.window), policy.Burst))
+
+		if !decision.allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(decision.retryAfter)))
+			metrics.RateLimitRejections.WithLabelValues(policy.Name).Inc()
+			http.Error(w, utils.RateLimitExceededError.Error(), utils.RateLimitExceededError.GetStatusCode())
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,57 @@
+package middlewares
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecide(t *testing.T) {
+	// 10 a minute with a burst of 5, one token every 6 seconds
+	policy := RateLimitPolicy{Limit: 10, Window: "1m", Burst: 5}
+	if err := policy.normalize("test"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		allowed bool
+		tokens  float64
+		want    rateDecision
+	}{
+		{name: "full bucket after a take", allowed: true, tokens: 4,
+			want: rateDecision{allowed: true, remaining: 4, reset: 6 * time.Second}},
+		{name: "last token taken", allowed: true, tokens: 0.5,
+			want: rateDecision{allowed: true, remaining: 0, reset: 27 * time.Second}},
+		{name: "empty bucket refused", allowed: false, tokens: 0,
+			want: rateDecision{allowed: false, remaining: 0, reset: 30 * time.Second, retryAfter: 6 * time.Second}},
+		{name: "partly refilled refused", allowed: false, tokens: 0.75,
+			want: rateDecision{allowed: false, remaining: 0, reset: 25500 * time.Millisecond, retryAfter: 1500 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decide(tt.allowed, tt.tokens, &policy)
+			if got != tt.want {
+				t.Errorf("decide() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitPolicyNormalize(t *testing.T) {
+	policy := RateLimitPolicy{Limit: 60, Window: "1m"}
+	if err := policy.normalize("default"); err != nil {
+		t.Fatal(err)
+	}
+	if policy.Name != "default" || policy.Burst != 60 || policy.rate() != 1 || maxRefill(&policy) != time.Minute {
+		t.Errorf("normalized policy = %+v, rate %v", policy, policy.rate())
+	}
+	for _, invalid := range []RateLimitPolicy{
+		{Limit: 0, Window: "1m"},
+		{Limit: 5, Window: ""},
+		{Limit: 5, Window: "-1s"},
+		{Limit: 5, Window: "soon"},
+	} {
+		if err := invalid.normalize("bad"); err == nil {
+			t.Errorf("normalize(%+v) accepted", invalid)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package middlewares
+
+import (
+	"net/http"
+	"restapi/internal/logging"
+	"restapi/utils"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID keeps IDs from clients and proxies short and free of anything that could
+// forge log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestID takes the X-Request-ID of the request or makes one up, echoes it in the response
+// and stores it in the context where every log line of the request picks it up
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			generated, err := utils.GenerateRandomToken(16)
+			if err != nil {
+				http.Error(w, utils.UnknownInternalServerError.Error(), utils.UnknownInternalServerError.GetStatusCode())
+				return
+			}
+			id = generated
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := logging.WithRequest(r.Context(), &logging.RequestInfo{ID: id})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// routeInfo records the route of the request for its log lines and its span, group chains
+// run inside it
+func routeInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routeSpan(r)
+		if info := logging.Request(r.Context()); info != nil {
+			info.Route = r.Pattern
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// userInfo records who made the request once the group chain let it through to the handler
+func userInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := logging.Request(r.Context()); info != nil {
+			if uid, ok := r.Context().Value("userId").(float64); ok {
+				info.UserID = int(uid)
+			}
+			if id, ok := r.Context().Value("apiKeyId").(int); ok {
+				info.APIKeyID = id
+			}
+			info.Role, _ = r.Context().Value("role").(string)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,11 +1,15 @@
 package middlewares
 
 import (
-	"fmt"
+	"log/slog"
 	"net/http"
+	"restapi/internal/logging"
+	"restapi/utils"
 	"time"
 )
 
+// ResponseTimeMiddleware writes the access log, one line per request with its status and
+// latency. The request ID, route and user are added from the context by the logger.
 func ResponseTimeMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -13,17 +17,55 @@ func ResponseTimeMiddleware(next http.Handler) http.Handler {
 
 		next.ServeHTTP(wrappedWriter, r)
 		duration := time.Since(start)
-		fmt.Printf("Method: %s, URL: %s, Status: %d, Duration: %v\n", r.Method, r.URL, wrappedWriter.status, duration.String())
 
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.Int("status", wrappedWriter.status),
+			slog.Duration("latency", duration),
+			slog.Int("bytes", wrappedWriter.bytes),
+			slog.String("client_ip", utils.ClientIP(r)),
+		}
+		// matched routes are logged by pattern, their paths can hold reset codes and invite tokens
+		if info := logging.Request(r.Context()); info == nil || info.Route == "" {
+			attrs = append(attrs, slog.String("path", r.URL.Path))
+		}
+		level := slog.LevelInfo
+		if wrappedWriter.status >= 500 {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "request", attrs...)
 	})
 }
 
 type responseWriter struct {
 	http.ResponseWriter
-	status int
+	status      int
+	bytes       int
+	wroteHeader bool
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
-	rw.status = code
+	if !rw.wroteHeader && code >= 200 {
+		rw.status = code
+		rw.wroteHeader = true
+	}
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += n
+	return n, err
+}
+
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
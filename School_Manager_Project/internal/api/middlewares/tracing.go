@@ -0,0 +1,97 @@
+package middlewares
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"restapi/internal/tracing"
+	"strings"
+)
+
+// Tracing starts the span of the request, continuing the trace of a W3C traceparent header.
+// It is named after the method until the route is known, then after the route pattern. The
+// path is left out, it can hold reset codes and invite tokens.
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLScheme(scheme(r)),
+				semconv.ServerAddress(r.Host),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		wrappedWriter := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(wrappedWriter, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(wrappedWriter.status))
+		if wrappedWriter.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(wrappedWriter.status))
+		}
+	})
+}
+
+func scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// routeSpan names the span of the request after its route, once the mux picked one
+func routeSpan(r *http.Request) {
+	span := trace.SpanFromContext(r.Context())
+	name := r.Pattern
+	if !strings.Contains(name, " ") {
+		// patterns like "/" match every method
+		name = r.Method + " " + name
+	}
+	span.SetName(name)
+	span.SetAttributes(semconv.HTTPRoute(r.Pattern))
+}
+
+// middlewareSpan is the span of a middleware and the span it was started under
+type middlewareSpan struct {
+	span   trace.Span
+	parent trace.Span
+}
+
+// tracedMiddleware gives a middleware a span of its own, from the request reaching it until
+// it passes the request on or answers it. The spans of a chain and of the handler are
+// siblings under the request span, so each shows the time spent in that step only.
+func tracedMiddleware(name string, mw Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ms, ok := r.Context().Value("middlewareSpan").(*middlewareSpan); ok {
+				ms.span.End()
+				r = r.WithContext(trace.ContextWithSpan(r.Context(), ms.parent))
+			}
+			next.ServeHTTP(w, r)
+		}))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			parent := trace.SpanFromContext(r.Context())
+			ctx, span := tracing.Tracer().Start(r.Context(), "middleware "+name)
+			ctx = context.WithValue(ctx, "middlewareSpan", &middlewareSpan{span: span, parent: parent})
+			wrapped.ServeHTTP(w, r.WithContext(ctx))
+			// no-op when the middleware passed the request on
+			span.End()
+		})
+	}
+}
+
+// tracedHandler is the span of the route handler, the queries it runs are its children
+func tracedHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Tracer().Start(r.Context(), "handler")
+		defer span.End()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,12 @@
+package router
+
+import (
+	"restapi/internal/api/handlers"
+	"restapi/internal/rbac"
+)
+
+func registerAPIKeyRoutes(mux *routeMux) {
+	admin(mux, "GET /apikeys/", rbac.APIKeysManage, handlers.GetAPIKeysHandler)
+	admin(mux, "POST /apikeys/", rbac.APIKeysManage, handlers.PostAPIKeyHandler)
+	admin(mux, "DELETE /apikeys/{id}", rbac.APIKeysManage, handlers.RevokeAPIKeyHandler)
+}
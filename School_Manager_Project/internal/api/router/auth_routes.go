@@ -0,0 +1,18 @@
+package router
+
+import (
+	"restapi/internal/api/handlers"
+)
+
+func registerAuthRoutes(mux *routeMux) {
+	public(mux, "POST /auth/refresh", handlers.RefreshHandler)
+	authenticated(mux, "POST /auth/logout-all", handlers.LogoutEverywhereHandler)
+
+	authenticated(mux, "GET /auth/families", handlers.GetTokenFamiliesHandler)
+	authenticated(mux, "DELETE /auth/families/{familyId}", handlers.DeleteTokenFamilyHandler)
+
+	public(mux, "GET /.well-known/jwks.json", handlers.JWKSHandler)
+
+	public(mux, "GET /auth/oidc/login", handlers.SSOLoginHandler)
+	public(mux, "GET /auth/oidc/callback", handlers.SSOCallbackHandler)
+}
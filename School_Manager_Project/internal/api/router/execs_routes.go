@@ -1,23 +1,39 @@
 package router
 
 import (
-	"net/http"
 	"restapi/internal/api/handlers"
+	"restapi/internal/models"
+	"restapi/internal/rbac"
 )
 
-func registerExecs(mux *http.ServeMux) {
-	mux.HandleFunc("GET /execs/", handlers.GetExecsHandler)
-	mux.HandleFunc("POST /execs/", handlers.PostExecsHandler)
-	mux.HandleFunc("PATCH /execs/", handlers.PatchExecsHandler)
+func registerExecs(mux *routeMux) {
+	list(mux, "GET /execs/", rbac.ExecsRead, handlers.GetExecsHandler, models.ExecFilterFields)
+	handle(mux, "POST /execs/", rbac.ExecsWrite, handlers.PostExecsHandler)
+	handle(mux, "PATCH /execs/", rbac.ExecsWrite, handlers.PatchExecsHandler)
 
-	mux.HandleFunc("GET /execs/{id}", handlers.GetOneExecHandler)
-	mux.HandleFunc("PATCH /execs/{id}", handlers.PatchOneExecHandler)
-	mux.HandleFunc("DELETE /execs/{id}", handlers.DeleteOneExecHandler)
+	handle(mux, "GET /execs/{id}", rbac.ExecsRead, handlers.GetOneExecHandler)
+	handle(mux, "PATCH /execs/{id}", rbac.ExecsWrite, handlers.PatchOneExecHandler)
+	handle(mux, "DELETE /execs/{id}", rbac.ExecsWrite, handlers.DeleteOneExecHandler)
 
-	mux.HandleFunc("POST /execs/{id}/updatePassword", handlers.UpdatePasswordHandler)
+	// any logged-in exec may change a password
+	authenticated(mux, "POST /execs/{id}/updatePassword", handlers.UpdatePasswordHandler)
+	admin(mux, "POST /execs/{id}/revokeSessions", rbac.SessionsRevoke, handlers.RevokeExecSessionsHandler)
+	// execs manage their own sessions, admins holding sessions:revoke those of everybody
+	authenticated(mux, "GET /execs/{id}/sessions", handlers.GetSessionsHandler)
+	authenticated(mux, "DELETE /execs/{id}/sessions/{sid}", handlers.RevokeSessionHandler)
+	admin(mux, "POST /execs/{id}/unlock", rbac.LockoutsManage, handlers.UnlockExecHandler)
+	handle(mux, "GET /execs/{id}/invite", rbac.ExecsRead, handlers.GetInviteHandler)
+	handle(mux, "POST /execs/{id}/invite", rbac.ExecsWrite, handlers.ResendInviteHandler)
+	handle(mux, "DELETE /execs/{id}/invite", rbac.ExecsWrite, handlers.RevokeInviteHandler)
 
-	mux.HandleFunc("POST /execs/login", handlers.LoginHandler)
-	mux.HandleFunc("POST /execs/logout", handlers.LogoutHandler)
-	//mux.HandleFunc("POST /execs/forgotPassword", handlers.GetExecsHandler)
-	//mux.HandleFunc("POST /execs/resetPassword/reset/{resetCode}", handlers.GetExecsHandler)
+	public(mux, "POST /execs/login", handlers.LoginHandler)
+	public(mux, "POST /execs/login/mfa", handlers.LoginMFAHandler)
+	authenticated(mux, "POST /execs/logout", handlers.LogoutHandler)
+
+	authenticated(mux, "POST /execs/mfa/enroll", handlers.EnrollMFAHandler)
+	authenticated(mux, "POST /execs/mfa/verify", handlers.VerifyMFAHandler)
+	authenticated(mux, "POST /execs/mfa/disable", handlers.DisableMFAHandler)
+	public(mux, "POST /execs/forgotPassword", handlers.ForgotPasswordHandler)
+	public(mux, "POST /execs/resetPassword/reset/{resetCode}", handlers.ResetPasswordHandler)
+	public(mux, "POST /execs/invite/accept/{token}", handlers.AcceptInviteHandler)
 }
@@ -0,0 +1,11 @@
+package router
+
+import (
+	"restapi/internal/api/handlers"
+	"restapi/internal/rbac"
+)
+
+func registerLockoutRoutes(mux *routeMux) {
+	admin(mux, "GET /lockouts/events", rbac.LockoutsManage, handlers.GetLockoutEventsHandler)
+	admin(mux, "DELETE /lockouts/ip/{ip}", rbac.LockoutsManage, handlers.UnlockIPHandler)
+}
@@ -0,0 +1,16 @@
+package router
+
+import (
+	"restapi/internal/api/handlers"
+	"restapi/internal/rbac"
+)
+
+func registerRoleRoutes(mux *routeMux) {
+	admin(mux, "GET /roles/", rbac.RolesRead, handlers.GetRolesHandler)
+	admin(mux, "POST /roles/", rbac.RolesWrite, handlers.PostRoleHandler)
+	admin(mux, "PUT /roles/{name}", rbac.RolesWrite, handlers.UpdateRoleHandler)
+	admin(mux, "DELETE /roles/{name}", rbac.RolesWrite, handlers.DeleteRoleHandler)
+	admin(mux, "PUT /roles/{name}/mfa", rbac.MFAPolicy, handlers.SetRoleMFAPolicyHandler)
+
+	admin(mux, "GET /permissions/", rbac.RolesRead, handlers.GetPermissionsHandler)
+}
@@ -3,12 +3,21 @@ package router
 import (
 	"net/http"
 	"restapi/internal/api/handlers"
+	"restapi/internal/api/middlewares"
 )
 
-func Router() *http.ServeMux {
-	mux := http.NewServeMux()
+// routeMux registers every route in one of the route groups of the middleware pipeline
+type routeMux struct {
+	mux      *http.ServeMux
+	pipeline *middlewares.Pipeline
+}
+
+func Router(pipeline *middlewares.Pipeline) *http.ServeMux {
+	mux := &routeMux{mux: http.NewServeMux(), pipeline: pipeline}
+
+	public(mux, "/", handlers.HandleRoot)
 
-	mux.HandleFunc("/", handlers.HandleRoot)
+	public(mux, "GET /metrics", handlers.MetricsHandler)
 
 	registerTeacherRoutes(mux)
 
@@ -16,5 +25,40 @@ func Router() *http.ServeMux {
 
 	registerExecs(mux)
 
-	return mux
+	registerRoleRoutes(mux)
+
+	registerAuthRoutes(mux)
+
+	registerLockoutRoutes(mux)
+
+	registerAPIKeyRoutes(mux)
+
+	return mux.mux
+}
+
+// public registers a route that doesn't need a login
+func public(mux *routeMux, pattern string, handler http.HandlerFunc) {
+	mux.mux.Handle(pattern, mux.pipeline.Group(middlewares.GroupPublic, handler))
+}
+
+// authenticated registers a route any logged-in user can reach
+func authenticated(mux *routeMux, pattern string, handler http.HandlerFunc) {
+	mux.mux.Handle(pattern, mux.pipeline.Group(middlewares.GroupAuthenticated, handler))
+}
+
+// handle registers a route that can only be reached by roles holding the given permission
+func handle(mux *routeMux, pattern, permission string, handler http.HandlerFunc) {
+	mux.mux.Handle(pattern, mux.pipeline.Group(middlewares.GroupAuthenticated, middlewares.RequirePermission(permission)(handler)))
+}
+
+// list is handle for a GET route filtering its resource by fields, which together with
+// sortby may be repeated in the query
+func list(mux *routeMux, pattern, permission string, handler http.HandlerFunc, fields []string) {
+	middlewares.AllowRepeatedParams(pattern, append([]string{"sortby"}, fields...)...)
+	handle(mux, pattern, permission, handler)
+}
+
+// admin is handle for the routes administering the API itself, they run the admin chain
+func admin(mux *routeMux, pattern, permission string, handler http.HandlerFunc) {
+	mux.mux.Handle(pattern, mux.pipeline.Group(middlewares.GroupAdmin, middlewares.RequirePermission(permission)(handler)))
 }
@@ -1,18 +1,19 @@
 package router
 
 import (
-	"net/http"
 	"restapi/internal/api/handlers"
+	"restapi/internal/models"
+	"restapi/internal/rbac"
 )
 
-func registerStudentRoutes(mux *http.ServeMux) {
-	mux.HandleFunc("GET /students/", handlers.GetStudentsHandler)
-	mux.HandleFunc("POST /students/", handlers.PostStudentHandler)
-	mux.HandleFunc("DELETE /students/", handlers.DeleteStudentsHandler)
-	mux.HandleFunc("PATCH /students/", handlers.PatchStudentsHandler)
+func registerStudentRoutes(mux *routeMux) {
+	list(mux, "GET /students/", rbac.StudentsRead, handlers.GetStudentsHandler, models.StudentFilterFields)
+	handle(mux, "POST /students/", rbac.StudentsWrite, handlers.PostStudentHandler)
+	handle(mux, "DELETE /students/", rbac.StudentsWrite, handlers.DeleteStudentsHandler)
+	handle(mux, "PATCH /students/", rbac.StudentsWrite, handlers.PatchStudentsHandler)
 
-	mux.HandleFunc("GET /students/{id}", handlers.GetOneStudentHandler)
-	mux.HandleFunc("PUT /students/{id}", handlers.UpdateStudentHandler)
-	mux.HandleFunc("PATCH /students/{id}", handlers.PatchOneStudentHandler)
-	mux.HandleFunc("DELETE /students/{id}", handlers.DeleteOneStudentHandler)
+	handle(mux, "GET /students/{id}", rbac.StudentsRead, handlers.GetOneStudentHandler)
+	handle(mux, "PUT /students/{id}", rbac.StudentsWrite, handlers.UpdateStudentHandler)
+	handle(mux, "PATCH /students/{id}", rbac.StudentsWrite, handlers.PatchOneStudentHandler)
+	handle(mux, "DELETE /students/{id}", rbac.StudentsWrite, handlers.DeleteOneStudentHandler)
 }
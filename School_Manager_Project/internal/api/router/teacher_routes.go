@@ -1,21 +1,22 @@
 package router
 
 import (
-	"net/http"
 	"restapi/internal/api/handlers"
+	"restapi/internal/models"
+	"restapi/internal/rbac"
 )
 
-func registerTeacherRoutes(mux *http.ServeMux) {
-	mux.HandleFunc("GET /teachers/", handlers.GetTeachersHandler)
-	mux.HandleFunc("POST /teachers/", handlers.PostTeacherHandler)
-	mux.HandleFunc("DELETE /teachers/", handlers.DeleteTeachersHandler)
-	mux.HandleFunc("PATCH /teachers/", handlers.PatchTeachersHandler)
+func registerTeacherRoutes(mux *routeMux) {
+	list(mux, "GET /teachers/", rbac.TeachersRead, handlers.GetTeachersHandler, models.TeacherFilterFields)
+	handle(mux, "POST /teachers/", rbac.TeachersWrite, handlers.PostTeacherHandler)
+	handle(mux, "DELETE /teachers/", rbac.TeachersWrite, handlers.DeleteTeachersHandler)
+	handle(mux, "PATCH /teachers/", rbac.TeachersWrite, handlers.PatchTeachersHandler)
 
-	mux.HandleFunc("GET /teachers/{id}", handlers.GetOneTeacherHandler)
-	mux.HandleFunc("PUT /teachers/{id}", handlers.UpdateTeacherHandler)
-	mux.HandleFunc("PATCH /teachers/{id}", handlers.PatchOneTeacherHandler)
-	mux.HandleFunc("DELETE /teachers/{id}", handlers.DeleteOneTeacherHandler)
+	handle(mux, "GET /teachers/{id}", rbac.TeachersRead, handlers.GetOneTeacherHandler)
+	handle(mux, "PUT /teachers/{id}", rbac.TeachersWrite, handlers.UpdateTeacherHandler)
+	handle(mux, "PATCH /teachers/{id}", rbac.TeachersWrite, handlers.PatchOneTeacherHandler)
+	handle(mux, "DELETE /teachers/{id}", rbac.TeachersWrite, handlers.DeleteOneTeacherHandler)
 
-	mux.HandleFunc("GET /teachers/{id}/students", handlers.GetStudentsListForTeacher)
-	mux.HandleFunc("GET /teachers/{id}/studentCount", handlers.GetStudentCountForTeacher)
+	handle(mux, "GET /teachers/{id}/students", rbac.StudentsRead, handlers.GetStudentsListForTeacher)
+	handle(mux, "GET /teachers/{id}/studentCount", rbac.ReportsRead, handlers.GetStudentCountForTeacher)
 }
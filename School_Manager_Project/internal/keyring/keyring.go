@@ -0,0 +1,421 @@
+package keyring
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"github.com/golang-jwt/jwt/v5"
+	"log/slog"
+	"math/big"
+	"os"
+	"restapi/internal/models"
+	"restapi/internal/sqlconnect"
+	"restapi/utils"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+	AlgHS256 = "HS256"
+)
+
+const dbTimeFormat = "2006-01-02 15:04:05"
+
+// Key is a parsed signing key. It signs new tokens between ActivatesAt and RetiresAt and
+// keeps verifying them until ExpiresAt, so tokens issued just before a rotation stay valid.
+type Key struct {
+	ID          string
+	Alg         string
+	ActivatesAt time.Time
+	RetiresAt   time.Time
+	ExpiresAt   time.Time
+	signKey     interface{}
+	verifyKey   interface{}
+}
+
+// Algorithm is the algorithm of newly created keys, from JWT_ALG
+func Algorithm() string {
+	switch alg := os.Getenv("JWT_ALG"); alg {
+	case AlgEdDSA, AlgRS256, AlgHS256:
+		return alg
+	default:
+		return AlgEdDSA
+	}
+}
+
+// RotationInterval is how long a key signs tokens before the next one takes over
+func RotationInterval() time.Duration {
+	duration, err := time.ParseDuration(os.Getenv("JWT_KEY_ROTATION"))
+	if err != nil || duration <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return duration
+}
+
+// Overlap is how long a key is published before it signs and accepted after it retired.
+// It is never shorter than the access token lifetime.
+func Overlap() time.Duration {
+	duration, err := time.ParseDuration(os.Getenv("JWT_KEY_OVERLAP"))
+	if err != nil || duration <= 0 {
+		duration = 24 * time.Hour
+	}
+	if ttl, err := utils.AccessTokenTTL(); err == nil && duration < ttl {
+		duration = ttl
+	}
+	return duration
+}
+
+const cacheTTL = time.Minute
+
+type ring struct {
+	mu       sync.RWMutex
+	keys     []Key
+	loadedAt time.Time
+}
+
+var kr = &ring{}
+
+func (rg *ring) get() []Key {
+	rg.mu.RLock()
+	if rg.keys != nil && time.Since(rg.loadedAt) < cacheTTL {
+		keys := rg.keys
+		rg.mu.RUnlock()
+		return keys
+	}
+	rg.mu.RUnlock()
+
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	if rg.keys != nil && time.Since(rg.loadedAt) < cacheTTL {
+		return rg.keys
+	}
+	keys, err := load()
+	if err != nil {
+		// keep using the keys we have until the database is back
+		slog.Error("unable to load jwt keys", "err", err)
+		return rg.keys
+	}
+	rg.keys = keys
+	rg.loadedAt = time.Now()
+	return rg.keys
+}
+
+// reload is used when a token names a key we don't know yet, another instance may just have created it
+func (rg *ring) reload() []Key {
+	rg.mu.Lock()
+	if time.Since(rg.loadedAt) > 5*time.Second {
+		rg.loadedAt = time.Time{}
+	}
+	rg.mu.Unlock()
+	return rg.get()
+}
+
+// load reads the keys and creates the ones the rotation schedule calls for
+func load() ([]Key, error) {
+	keys, err := readKeys()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	alg := Algorithm()
+	rotation := RotationInterval()
+	overlap := Overlap()
+
+	active := signingKey(keys, now)
+	var activatesAt time.Time
+	switch {
+	case active == nil || active.Alg != alg:
+		// first start or the algorithm was changed, switch right away
+		activatesAt = now
+	case active.RetiresAt.Sub(now) <= overlap && !hasSuccessor(keys, *active):
+		// publish the successor one overlap before it starts signing
+		activatesAt = active.RetiresAt
+	default:
+		return keys, nil
+	}
+
+	err = createKey(alg, activatesAt, activatesAt.Add(rotation), activatesAt.Add(rotation+overlap))
+	if err != nil {
+		return nil, err
+	}
+	return readKeys()
+}
+
+func readKeys() ([]Key, error) {
+	// the keys are shared by every request, so loading them isn't cut short with one of them
+	stored, err := sqlconnect.GetJWTKeysDBHandler(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]Key, 0, len(stored))
+	for _, storedKey := range stored {
+		key, err := parseKey(storedKey)
+		if err != nil {
+			slog.Warn("skipping jwt key", "kid", storedKey.Kid, "err", err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func signingKey(keys []Key, now time.Time) *Key {
+	var current *Key
+	for i := range keys {
+		key := &keys[i]
+		if !now.Before(key.ActivatesAt) && now.Before(key.RetiresAt) {
+			if current == nil || key.ActivatesAt.After(current.ActivatesAt) {
+				current = key
+			}
+		}
+	}
+	return current
+}
+
+func hasSuccessor(keys []Key, active Key) bool {
+	for _, key := range keys {
+		if key.ActivatesAt.After(active.ActivatesAt) && key.Alg == active.Alg {
+			return true
+		}
+	}
+	return false
+}
+
+func createKey(alg string, activatesAt, retiresAt, expiresAt time.Time) error {
+	kid, err := utils.GenerateRandomToken(12)
+	if err != nil {
+		return err
+	}
+	var privateDER, publicDER []byte
+	switch alg {
+	case AlgEdDSA:
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return utils.ErrorGeneratingJwtToken
+		}
+		privateDER, err = x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return utils.ErrorGeneratingJwtToken
+		}
+		publicDER, err = x509.MarshalPKIXPublicKey(publicKey)
+		if err != nil {
+			return utils.ErrorGeneratingJwtToken
+		}
+	case AlgRS256:
+		bits, err := strconv.Atoi(os.Getenv("JWT_RSA_BITS"))
+		if err != nil || bits < 2048 {
+			bits = 2048
+		}
+		privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return utils.ErrorGeneratingJwtToken
+		}
+		privateDER, err = x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return utils.ErrorGeneratingJwtToken
+		}
+		publicDER, err = x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+		if err != nil {
+			return utils.ErrorGeneratingJwtToken
+		}
+	case AlgHS256:
+		privateDER = make([]byte, 32)
+		_, err = rand.Read(privateDER)
+		if err != nil {
+			return utils.ErrorGeneratingJwtToken
+		}
+	}
+
+	encryptedKey, err := utils.EncryptSecret(base64.StdEncoding.EncodeToString(privateDER))
+	if err != nil {
+		return err
+	}
+	key := models.JWTKey{
+		Kid:        kid,
+		Alg:        alg,
+		PrivateKey: encryptedKey,
+	}
+	if publicDER != nil {
+		key.PublicKey = base64.StdEncoding.EncodeToString(publicDER)
+	}
+	return sqlconnect.AddJWTKeyDBHandler(context.Background(), key, activatesAt, retiresAt, expiresAt)
+}
+
+func parseKey(stored models.JWTKey) (Key, error) {
+	key := Key{ID: stored.Kid, Alg: stored.Alg}
+	var err error
+	if key.ActivatesAt, err = time.Parse(dbTimeFormat, stored.ActivatesAt); err != nil {
+		return Key{}, err
+	}
+	if key.RetiresAt, err = time.Parse(dbTimeFormat, stored.RetiresAt); err != nil {
+		return Key{}, err
+	}
+	if key.ExpiresAt, err = time.Parse(dbTimeFormat, stored.ExpiresAt); err != nil {
+		return Key{}, err
+	}
+
+	decrypted, err := utils.DecryptSecret(stored.PrivateKey)
+	if err != nil {
+		return Key{}, err
+	}
+	der, err := base64.StdEncoding.DecodeString(decrypted)
+	if err != nil {
+		return Key{}, err
+	}
+	if stored.Alg == AlgHS256 {
+		key.signKey = der
+		key.verifyKey = der
+		return key, nil
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return Key{}, err
+	}
+	switch privateKey := privateKey.(type) {
+	case ed25519.PrivateKey:
+		if stored.Alg != AlgEdDSA {
+			return Key{}, utils.UnexpectedSigningMethodError
+		}
+		key.signKey = privateKey
+		key.verifyKey = privateKey.Public()
+	case *rsa.PrivateKey:
+		if stored.Alg != AlgRS256 {
+			return Key{}, utils.UnexpectedSigningMethodError
+		}
+		key.signKey = privateKey
+		key.verifyKey = &privateKey.PublicKey
+	default:
+		return Key{}, utils.UnexpectedSigningMethodError
+	}
+	return key, nil
+}
+
+func signingMethod(alg string) jwt.SigningMethod {
+	switch alg {
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	case AlgRS256:
+		return jwt.SigningMethodRS256
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+// Sign signs the claims with the active key and names it in the kid header
+func Sign(claims jwt.Claims) (string, error) {
+	key := signingKey(kr.get(), time.Now())
+	if key == nil {
+		return "", utils.ErrorGeneratingJwtToken
+	}
+	token := jwt.NewWithClaims(signingMethod(key.Alg), claims)
+	token.Header["kid"] = key.ID
+	signedToken, err := token.SignedString(key.signKey)
+	if err != nil {
+		return "", utils.ErrorGeneratingJwtToken
+	}
+	return signedToken, nil
+}
+
+// ValidMethods are the algorithms Keyfunc may accept, to be passed to jwt.WithValidMethods
+func ValidMethods() []string {
+	return []string{AlgEdDSA, AlgRS256, AlgHS256}
+}
+
+// Keyfunc finds the verification key named by the kid header of the token
+func Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return legacyKey(token)
+	}
+	keys := kr.get()
+	key := findKey(keys, kid)
+	if key == nil {
+		key = findKey(kr.reload(), kid)
+	}
+	if key == nil || time.Now().After(key.ExpiresAt) {
+		return nil, utils.InvalidLoginTokenError
+	}
+	// the key decides the algorithm, never the token
+	if token.Method.Alg() != key.Alg {
+		return nil, utils.UnexpectedSigningMethodError
+	}
+	return key.verifyKey, nil
+}
+
+func findKey(keys []Key, kid string) *Key {
+	for i := range keys {
+		if keys[i].ID == kid {
+			return &keys[i]
+		}
+	}
+	return nil
+}
+
+// legacyKey accepts tokens signed with JWT_SECRET before the keyring existed, until the
+// first key has been active for one overlap
+func legacyKey(token *jwt.Token) (interface{}, error) {
+	secret := os.Getenv("JWT_SECRET")
+	keys := kr.get()
+	if secret == "" || len(keys) == 0 || time.Now().After(keys[0].ActivatesAt.Add(Overlap())) {
+		return nil, utils.InvalidLoginTokenError
+	}
+	if token.Method.Alg() != AlgHS256 {
+		return nil, utils.UnexpectedSigningMethodError
+	}
+	return []byte(secret), nil
+}
+
+// PublicKeys returns the asymmetric keys that may verify tokens, including the ones that
+// will start signing soon. HS256 keys are secret and never published.
+func PublicKeys() []Key {
+	var public []Key
+	now := time.Now()
+	for _, key := range kr.get() {
+		if key.Alg != AlgHS256 && now.Before(key.ExpiresAt) {
+			public = append(public, key)
+		}
+	}
+	return public
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JWKS returns the published keys as a JWK set
+func JWKS() []JWK {
+	keys := PublicKeys()
+	jwks := make([]JWK, 0, len(keys))
+	for _, key := range keys {
+		jwk := JWK{Kid: key.ID, Alg: key.Alg, Use: "sig"}
+		switch publicKey := key.verifyKey.(type) {
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		default:
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
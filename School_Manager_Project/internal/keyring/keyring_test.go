@@ -0,0 +1,187 @@
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/golang-jwt/jwt/v5"
+	"restapi/utils"
+	"testing"
+	"time"
+)
+
+func ed25519Key(t *testing.T, id string, activatesAt, retiresAt, expiresAt time.Time) Key {
+	t.Helper()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Key{ID: id, Alg: AlgEdDSA, ActivatesAt: activatesAt, RetiresAt: retiresAt, ExpiresAt: expiresAt,
+		signKey: privateKey, verifyKey: publicKey}
+}
+
+// useKeys puts the keys in the ring as if they were just loaded, so nothing reads the database
+func useKeys(t *testing.T, keys ...Key) {
+	t.Helper()
+	kr.mu.Lock()
+	kr.keys = keys
+	kr.loadedAt = time.Now()
+	kr.mu.Unlock()
+	t.Cleanup(func() {
+		kr.mu.Lock()
+		kr.keys = nil
+		kr.loadedAt = time.Time{}
+		kr.mu.Unlock()
+	})
+}
+
+func parse(token string) error {
+	_, err := jwt.Parse(token, Keyfunc, jwt.WithValidMethods(ValidMethods()))
+	return err
+}
+
+func TestSigningKey(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	retired := Key{ID: "retired", ActivatesAt: now.Add(-60 * day), RetiresAt: now.Add(-30 * day), ExpiresAt: now.Add(-29 * day)}
+	active := Key{ID: "active", ActivatesAt: now.Add(-10 * day), RetiresAt: now.Add(20 * day), ExpiresAt: now.Add(21 * day)}
+	newer := Key{ID: "newer", ActivatesAt: now.Add(-time.Hour), RetiresAt: now.Add(30 * day), ExpiresAt: now.Add(31 * day)}
+	upcoming := Key{ID: "upcoming", ActivatesAt: now.Add(day), RetiresAt: now.Add(31 * day), ExpiresAt: now.Add(32 * day)}
+
+	tests := []struct {
+		name string
+		keys []Key
+		want string
+	}{
+		{name: "no keys", keys: nil, want: ""},
+		{name: "only retired and upcoming", keys: []Key{retired, upcoming}, want: ""},
+		{name: "active key", keys: []Key{retired, active, upcoming}, want: "active"},
+		{name: "latest activated wins", keys: []Key{newer, active, upcoming}, want: "newer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if key := signingKey(tt.keys, now); key != nil {
+				got = key.ID
+			}
+			if got != tt.want {
+				t.Errorf("signingKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if !hasSuccessor([]Key{active, upcoming}, active) || hasSuccessor([]Key{retired, active}, active) {
+		t.Error("hasSuccessor() doesn't find the upcoming key")
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	old := ed25519Key(t, "old", now.Add(-31*day), now.Add(-time.Hour), now.Add(day))
+	current := ed25519Key(t, "current", now.Add(-time.Hour), now.Add(30*day), now.Add(31*day))
+	expired := ed25519Key(t, "expired", now.Add(-62*day), now.Add(-32*day), now.Add(-31*day))
+	useKeys(t, old, current, expired)
+
+	claims := jwt.MapClaims{"uid": 1, "exp": jwt.NewNumericDate(now.Add(time.Minute))}
+	token, err := Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if err != nil || parsed.Header["kid"] != "current" || parsed.Method.Alg() != AlgEdDSA {
+		t.Fatalf("Sign() used kid %v with %v (%v), want the current EdDSA key", parsed.Header["kid"], parsed.Method.Alg(), err)
+	}
+	if err := parse(token); err != nil {
+		t.Errorf("token of the current key rejected: %v", err)
+	}
+
+	signWith := func(key Key) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+		token.Header["kid"] = key.ID
+		signed, err := token.SignedString(key.signKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	if err := parse(signWith(old)); err != nil {
+		t.Errorf("token of a retired but not expired key rejected: %v", err)
+	}
+	if err := parse(signWith(expired)); err == nil {
+		t.Error("token of an expired key accepted")
+	}
+	unknown := ed25519Key(t, "unknown", now.Add(-time.Hour), now.Add(day), now.Add(day))
+	if err := parse(signWith(unknown)); err == nil {
+		t.Error("token of an unknown key accepted")
+	}
+	// a token can't pick another algorithm for a known key
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hs.Header["kid"] = "current"
+	forged, _ := hs.SignedString([]byte("guess"))
+	if err := parse(forged); err == nil {
+		t.Error("HS256 token naming an EdDSA key accepted")
+	}
+}
+
+func TestSignWithoutKey(t *testing.T) {
+	now := time.Now()
+	useKeys(t, ed25519Key(t, "upcoming", now.Add(time.Hour), now.Add(2*time.Hour), now.Add(3*time.Hour)))
+	if _, err := Sign(jwt.MapClaims{}); err != utils.ErrorGeneratingJwtToken {
+		t.Errorf("Sign() without an active key = %v", err)
+	}
+}
+
+func TestLegacyKey(t *testing.T) {
+	t.Setenv("JWT_SECRET", "legacy-secret")
+	t.Setenv("JWT_KEY_OVERLAP", "24h")
+	now := time.Now()
+	claims := jwt.MapClaims{"uid": 1, "exp": jwt.NewNumericDate(now.Add(time.Minute))}
+	legacyToken := func(method jwt.SigningMethod, key interface{}) string {
+		t.Helper()
+		signed, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	hsToken := legacyToken(jwt.SigningMethodHS256, []byte("legacy-secret"))
+
+	t.Run("within the overlap of the first key", func(t *testing.T) {
+		useKeys(t, ed25519Key(t, "first", now.Add(-time.Hour), now.Add(30*24*time.Hour), now.Add(31*24*time.Hour)))
+		if err := parse(hsToken); err != nil {
+			t.Errorf("legacy token rejected: %v", err)
+		}
+		if err := parse(legacyToken(jwt.SigningMethodHS256, []byte("other-secret"))); err == nil {
+			t.Error("legacy token with another secret accepted")
+		}
+		_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+		if err := parse(legacyToken(jwt.SigningMethodEdDSA, privateKey)); err == nil {
+			t.Error("legacy token with another algorithm accepted")
+		}
+	})
+	t.Run("after the overlap", func(t *testing.T) {
+		useKeys(t, ed25519Key(t, "first", now.Add(-25*time.Hour), now.Add(30*24*time.Hour), now.Add(31*24*time.Hour)))
+		if err := parse(hsToken); err == nil {
+			t.Error("legacy token accepted after the overlap")
+		}
+	})
+	t.Run("without JWT_SECRET", func(t *testing.T) {
+		t.Setenv("JWT_SECRET", "")
+		useKeys(t, ed25519Key(t, "first", now.Add(-time.Hour), now.Add(30*24*time.Hour), now.Add(31*24*time.Hour)))
+		if err := parse(legacyToken(jwt.SigningMethodHS256, []byte(""))); err == nil {
+			t.Error("legacy token accepted without JWT_SECRET")
+		}
+	})
+}
+
+func TestOverlap(t *testing.T) {
+	t.Setenv("JWT_KEY_OVERLAP", "10m")
+	t.Setenv("JWT_EXPIRES_IN", "15m")
+	if got := Overlap(); got != 15*time.Minute {
+		t.Errorf("Overlap() = %v, want the access token lifetime", got)
+	}
+	t.Setenv("JWT_KEY_OVERLAP", "2h")
+	if got := Overlap(); got != 2*time.Hour {
+		t.Errorf("Overlap() = %v, want 2h", got)
+	}
+}
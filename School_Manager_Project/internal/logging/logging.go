@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Setup makes slog write to stderr in format "text" (default) or "json", from level "debug",
+// "info" (default), "warn" or "error" on. The standard log package goes through it as well.
+func Setup(format, level string) error {
+	var logLevel slog.Level
+	if level != "" {
+		err := logLevel.UnmarshalText([]byte(level))
+		if err != nil {
+			return fmt.Errorf("invalid log level %q", level)
+		}
+	}
+	options := &slog.HandlerOptions{Level: logLevel}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return fmt.Errorf("invalid log format %q, use text or json", format)
+	}
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+// RequestInfo describes the request a log record belongs to. The request_id middleware
+// creates it, the route group chains fill in the route and the user once they are known.
+type RequestInfo struct {
+	ID       string
+	Route    string
+	UserID   int
+	APIKeyID int
+	Role     string
+}
+
+// WithRequest stores info in the context, the request ID also on its own under "requestId"
+func WithRequest(ctx context.Context, info *RequestInfo) context.Context {
+	ctx = context.WithValue(ctx, "requestId", info.ID)
+	return context.WithValue(ctx, "requestInfo", info)
+}
+
+// Request returns the info of the request, nil outside of one
+func Request(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value("requestInfo").(*RequestInfo)
+	return info
+}
+
+// contextHandler adds the request and the trace of the context to every record logged with one
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if info := Request(ctx); info != nil {
+		record.AddAttrs(slog.String("request_id", info.ID))
+		if info.Route != "" {
+			record.AddAttrs(slog.String("route", info.Route))
+		}
+		if info.UserID != 0 {
+			record.AddAttrs(slog.Int("user_id", info.UserID))
+		}
+		if info.APIKeyID != 0 {
+			record.AddAttrs(slog.Int("api_key_id", info.APIKeyID))
+		}
+		if info.Role != "" {
+			record.AddAttrs(slog.String("role", info.Role))
+		}
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		record.AddAttrs(slog.String("trace_id", spanContext.TraceID().String()), slog.String("span_id", spanContext.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
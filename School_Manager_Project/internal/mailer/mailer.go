@@ -0,0 +1,110 @@
+package mailer
+
+import (
+	"fmt"
+	"log/slog"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages to users, selected with the MAILER env variable
+type Mailer interface {
+	Send(msg Message) error
+}
+
+var (
+	once    sync.Once
+	current Mailer
+)
+
+// Default returns the mailer configured in the environment:
+// MAILER=smtp uses SMTP_HOST, SMTP_PORT, SMTP_USER, SMTP_PASSWORD and MAIL_FROM,
+// anything else writes the messages into MAIL_DIR (default "mail").
+func Default() Mailer {
+	once.Do(func() {
+		switch os.Getenv("MAILER") {
+		case "smtp":
+			current = &SMTPMailer{
+				Host:     os.Getenv("SMTP_HOST"),
+				Port:     os.Getenv("SMTP_PORT"),
+				Username: os.Getenv("SMTP_USER"),
+				Password: os.Getenv("SMTP_PASSWORD"),
+				From:     os.Getenv("MAIL_FROM"),
+			}
+		default:
+			dir := os.Getenv("MAIL_DIR")
+			if dir == "" {
+				dir = "mail"
+			}
+			current = &FileMailer{Dir: dir}
+		}
+	})
+	return current
+}
+
+// SetDefault replaces the mailer returned by Default
+func SetDefault(m Mailer) {
+	once.Do(func() {})
+	current = m
+}
+
+// SendAsync sends in the background so the response time doesn't depend on the mail delivery
+func SendAsync(msg Message) {
+	go func() {
+		err := Default().Send(msg)
+		if err != nil {
+			slog.Error("sending mail failed", "subject", msg.Subject, "err", err)
+		}
+	}()
+}
+
+// FileMailer writes every message as a file, meant for local development
+type FileMailer struct {
+	Dir string
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	err := os.MkdirAll(m.Dir, 0o700)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d_%s.eml", time.Now().UnixNano(), sanitize(msg.To))
+	content := fmt.Sprintf("To: %s\r\nSubject: %s\r\nDate: %s\r\n\r\n%s\r\n", msg.To, msg.Subject, time.Now().Format(time.RFC1123Z), msg.Body)
+	return os.WriteFile(filepath.Join(m.Dir, name), []byte(content), 0o600)
+}
+
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	content := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n", m.From, msg.To, msg.Subject, msg.Body)
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{msg.To}, []byte(content))
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"restapi/internal/sqlconnect"
+)
+
+func dbDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db", name), help, nil, nil)
+}
+
+var (
+	dbMaxOpen           = dbDesc("max_open_connections", "Maximum number of open connections to the database.")
+	dbOpen              = dbDesc("open_connections", "The number of established connections, in use and idle.")
+	dbInUse             = dbDesc("in_use_connections", "The number of connections currently in use.")
+	dbIdle              = dbDesc("idle_connections", "The number of idle connections.")
+	dbWaitCount         = dbDesc("wait_count_total", "The total number of connections waited for.")
+	dbWaitDuration      = dbDesc("wait_duration_seconds_total", "The total time blocked waiting for a new connection.")
+	dbMaxIdleClosed     = dbDesc("max_idle_closed_total", "The total number of connections closed due to SetMaxIdleConns.")
+	dbMaxIdleTimeClosed = dbDesc("max_idle_time_closed_total", "The total number of connections closed due to SetConnMaxIdleTime.")
+	dbMaxLifetimeClosed = dbDesc("max_lifetime_closed_total", "The total number of connections closed due to SetConnMaxLifetime.")
+)
+
+// dbStatsCollector reads the stats of the shared pool on every scrape. Until the first query
+// opened the pool there is nothing to report.
+type dbStatsCollector struct{}
+
+func (dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{dbMaxOpen, dbOpen, dbInUse, dbIdle, dbWaitCount, dbWaitDuration, dbMaxIdleClosed, dbMaxIdleTimeClosed, dbMaxLifetimeClosed} {
+		ch <- desc
+	}
+}
+
+func (dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	db := sqlconnect.Pool()
+	if db == nil {
+		return
+	}
+	stats := db.Stats()
+	ch <- prometheus.MustNewConstMetric(dbMaxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(dbOpen, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(dbInUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(dbIdle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(dbWaitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(dbWaitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(dbMaxIdleClosed, prometheus.CounterValue, float64(stats.MaxIdleClosed))
+	ch <- prometheus.MustNewConstMetric(dbMaxIdleTimeClosed, prometheus.CounterValue, float64(stats.MaxIdleTimeClosed))
+	ch <- prometheus.MustNewConstMetric(dbMaxLifetimeClosed, prometheus.CounterValue, float64(stats.MaxLifetimeClosed))
+}
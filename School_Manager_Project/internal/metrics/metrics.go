@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+const namespace = "restapi"
+
+// registry holds the metrics of the API and of the Go runtime, nothing registers itself on
+// the global default one
+var registry = prometheus.NewRegistry()
+
+var (
+	// RequestsTotal counts finished requests by route pattern, method and status
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route pattern, method and status.",
+	}, []string{"route", "method", "status"})
+
+	// RequestDuration observes the latency of finished requests in seconds
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by route pattern, method and status.",
+		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"route", "method", "status"})
+
+	// RequestsInFlight are the requests being served right now
+	RequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests currently being served.",
+	})
+
+	// Logins counts login attempts by method (password, mfa, sso) and result (success,
+	// failure, locked)
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts by method and result.",
+	}, []string{"method", "result"})
+
+	// RateLimitRejections counts requests answered 429 by the rate limiter, by policy
+	RateLimitRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Requests rejected by the rate limiter by policy.",
+	}, []string{"policy"})
+)
+
+func init() {
+	registry.MustRegister(
+		RequestsTotal,
+		RequestDuration,
+		RequestsInFlight,
+		Logins,
+		RateLimitRejections,
+		dbStatsCollector{},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+var handler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return handler
+}
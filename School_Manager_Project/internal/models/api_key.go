@@ -0,0 +1,27 @@
+package models
+
+import "database/sql"
+
+// APIKey authenticates an integration instead of an exec. The secret part is only shown once.
+type APIKey struct {
+	ID         int            `json:"id" db:"id"`
+	Name       string         `json:"name" db:"name"`
+	Prefix     string         `json:"prefix" db:"prefix"`
+	Scopes     []string       `json:"scopes"`
+	AllowedIPs []string       `json:"allowed_ips"`
+	CreatedBy  sql.NullInt64  `json:"created_by" db:"created_by"`
+	CreatedAt  string         `json:"created_at" db:"created_at"`
+	ExpiresAt  sql.NullString `json:"expires_at" db:"expires_at"`
+	LastUsedAt sql.NullString `json:"last_used_at" db:"last_used_at"`
+	LastUsedIP sql.NullString `json:"last_used_ip" db:"last_used_ip"`
+	RevokedAt  sql.NullString `json:"revoked_at" db:"revoked_at"`
+}
+
+type APIKeyRequest struct {
+	Name   string   `json:"name" validate:"required"`
+	Scopes []string `json:"scopes" validate:"required,min=1"`
+	// single addresses or CIDR ranges, any address if empty
+	AllowedIPs []string `json:"allowed_ips"`
+	// RFC 3339, the key never expires if empty
+	ExpiresAt string `json:"expires_at"`
+}
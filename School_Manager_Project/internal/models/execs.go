@@ -8,16 +8,30 @@ type Exec struct {
 	LastName             string         `json:"last_name" db:"last_name" validate:"required"`
 	Email                string         `json:"email" db:"email" validate:"required"`
 	Username             string         `json:"username" db:"username" validate:"required"`
-	Password             string         `json:"password" db:"password" validate:"required"`
+	Password             string         `json:"password,omitempty" db:"password"`
 	PasswordChangedAt    sql.NullString `json:"password_changed_at" db:"password_changed_at"`
 	UserCreatedAt        sql.NullString `json:"user_created_at" db:"user_created_at"`
 	PasswordResetToken   sql.NullString `json:"password_reset_token" db:"password_reset_token"`
 	PasswordTokenExpires sql.NullString `json:"password_token_expires" db:"password_token_expires"`
 	InactiveStatus       bool           `json:"inactive_status" db:"inactive_status"`
 	Role                 string         `json:"role" db:"role" validate:"required"`
+	TokenVersion         int            `json:"-" db:"token_version"`
+	MfaEnabled           bool           `json:"mfa_enabled" db:"mfa_enabled"`
 }
 
+// ExecFilterFields can be searched and sorted by in GET /execs/
+var ExecFilterFields = []string{"first_name", "last_name", "email", "username", "role"}
+
 type UpdatePasswordRequest struct {
 	CurrentPassword string `json:"current_password" validate:"required"`
 	NewPassword     string `json:"new_password" validate:"required"`
 }
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	NewPassword     string `json:"new_password" validate:"required"`
+	ConfirmPassword string `json:"confirm_password" validate:"required"`
+}
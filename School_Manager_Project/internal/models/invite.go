@@ -0,0 +1,16 @@
+package models
+
+import "database/sql"
+
+// ExecInvite is the pending sign-up of an exec created by an admin, see POST /execs/
+type ExecInvite struct {
+	ExecID     int            `json:"exec_id" db:"exec_id"`
+	Email      string         `json:"email" db:"email"`
+	Status     string         `json:"status"`
+	CreatedBy  sql.NullInt64  `json:"created_by" db:"created_by"`
+	CreatedAt  string         `json:"created_at" db:"created_at"`
+	ExpiresAt  string         `json:"expires_at" db:"expires_at"`
+	SentCount  int            `json:"sent_count" db:"sent_count"`
+	AcceptedAt sql.NullString `json:"accepted_at" db:"accepted_at"`
+	RevokedAt  sql.NullString `json:"revoked_at" db:"revoked_at"`
+}
@@ -0,0 +1,12 @@
+package models
+
+// JWTKey is a signing key as stored in jwt_keys, PrivateKey is encrypted
+type JWTKey struct {
+	Kid         string `db:"kid"`
+	Alg         string `db:"alg"`
+	PrivateKey  string `db:"private_key"`
+	PublicKey   string `db:"public_key"`
+	ActivatesAt string `db:"activates_at"`
+	RetiresAt   string `db:"retires_at"`
+	ExpiresAt   string `db:"expires_at"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "database/sql"
+
+// LockoutEvent records a username or client IP being locked after too many failed logins, or unlocked by an admin
+type LockoutEvent struct {
+	ID          int            `json:"id" db:"id"`
+	Scope       string         `json:"scope" db:"scope"`
+	Subject     string         `json:"subject" db:"subject"`
+	Event       string         `json:"event" db:"event"`
+	IP          string         `json:"ip" db:"ip"`
+	Failures    int            `json:"failures" db:"failures"`
+	LockedUntil sql.NullString `json:"locked_until" db:"locked_until"`
+	ActorID     sql.NullInt64  `json:"actor_id" db:"actor_id"`
+	CreatedAt   string         `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+type MFAEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+type MFACodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MFAPolicyRequest struct {
+	Required bool `json:"required"`
+}
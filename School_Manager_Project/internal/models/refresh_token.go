@@ -0,0 +1,13 @@
+package models
+
+// TokenFamily is the chain of refresh tokens issued from one login
+type TokenFamily struct {
+	FamilyID      string `json:"family_id" db:"family_id"`
+	CreatedAt     string `json:"created_at" db:"created_at"`
+	LastRotatedAt string `json:"last_rotated_at" db:"last_rotated_at"`
+	ExpiresAt     string `json:"expires_at" db:"expires_at"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
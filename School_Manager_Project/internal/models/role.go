@@ -0,0 +1,8 @@
+package models
+
+type Role struct {
+	Name        string   `json:"name" db:"name" validate:"required"`
+	Description string   `json:"description" db:"description"`
+	Permissions []string `json:"permissions" validate:"required"`
+	MfaRequired bool     `json:"mfa_required" db:"mfa_required"`
+}
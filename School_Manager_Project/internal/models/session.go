@@ -0,0 +1,15 @@
+package models
+
+// Session is one login of an exec, its ID is the refresh token family the login started
+type Session struct {
+	ID         string `json:"id" db:"id"`
+	ExecID     int    `json:"exec_id" db:"exec_id"`
+	IP         string `json:"ip" db:"ip"`
+	UserAgent  string `json:"user_agent" db:"user_agent"`
+	CreatedAt  string `json:"created_at" db:"created_at"`
+	LastSeenAt string `json:"last_seen_at" db:"last_seen_at"`
+	// Active is false once the session was logged out, revoked or its refresh token expired
+	Active bool `json:"active"`
+	// Current marks the session the request was made with
+	Current bool `json:"current"`
+}
@@ -7,3 +7,6 @@ type Student struct {
 	Email     string `json:"email" db:"email" validate:"required,email"`
 	Class     string `json:"class" db:"class" validate:"required"`
 }
+
+// StudentFilterFields can be searched and sorted by in GET /students/
+var StudentFilterFields = []string{"first_name", "last_name", "email", "class"}
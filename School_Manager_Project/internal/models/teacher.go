@@ -8,3 +8,6 @@ type Teacher struct {
 	Class     string `json:"class" db:"class" validate:"required"`
 	Subject   string `json:"subject" db:"subject" validate:"required"`
 }
+
+// TeacherFilterFields can be searched and sorted by in GET /teachers/
+var TeacherFilterFields = []string{"first_name", "last_name", "email", "class", "subject"}
@@ -0,0 +1,146 @@
+package policy
+
+import (
+	"context"
+	"restapi/utils"
+)
+
+// Subject is the logged-in user a policy is evaluated for
+type Subject struct {
+	UserID int
+	Role   string
+}
+
+type Action string
+
+const (
+	Read   Action = "read"
+	Create Action = "create"
+	Update Action = "update"
+	Delete Action = "delete"
+)
+
+const (
+	Students      = "students"
+	ExecPasswords = "execs:password"
+)
+
+// Rule restricts what a role can do on a resource beyond its RBAC permissions
+type Rule struct {
+	// Actions allowed on the resource, every action if nil
+	Actions []Action
+	// Scope returns an SQL condition, starting with " AND", limiting the rows the subject can reach
+	Scope func(s Subject) (string, []interface{})
+	// ReadOnlyFields are json field names the subject may not change
+	ReadOnlyFields []string
+	// OwnerOnly limits the subject to the record carrying its own user ID
+	OwnerOnly bool
+}
+
+// rules per resource and role, roles without an entry are not restricted
+var rules = map[string]map[string]Rule{
+	Students: {
+		"teacher": {
+			Actions:        []Action{Read, Update},
+			Scope:          teacherClassScope,
+			ReadOnlyFields: []string{"class"},
+		},
+		"guardian": {
+			Actions: []Action{Read},
+			Scope:   linkedStudentsScope,
+		},
+		"student": {
+			Actions: []Action{Read},
+			Scope:   ownStudentScope,
+		},
+	},
+	ExecPasswords: {
+		"*": {OwnerOnly: true},
+	},
+}
+
+func SubjectFromContext(ctx context.Context) Subject {
+	var s Subject
+	switch uid := ctx.Value("userId").(type) {
+	case float64:
+		s.UserID = int(uid)
+	case int:
+		s.UserID = uid
+	}
+	s.Role, _ = ctx.Value("role").(string)
+	return s
+}
+
+func ruleFor(s Subject, resource string) (Rule, bool) {
+	byRole, ok := rules[resource]
+	if !ok {
+		return Rule{}, false
+	}
+	if rule, ok := byRole[s.Role]; ok {
+		return rule, true
+	}
+	rule, ok := byRole["*"]
+	return rule, ok
+}
+
+// Allow checks that the subject may perform the action on the resource at all
+func Allow(s Subject, resource string, action Action) error {
+	rule, ok := ruleFor(s, resource)
+	if !ok || rule.Actions == nil {
+		return nil
+	}
+	for _, allowed := range rule.Actions {
+		if allowed == action {
+			return nil
+		}
+	}
+	return utils.PermissionDeniedError
+}
+
+// Scope returns the condition list and single-record queries on the resource have to append
+func Scope(s Subject, resource string) (string, []interface{}) {
+	rule, ok := ruleFor(s, resource)
+	if !ok || rule.Scope == nil {
+		return "", nil
+	}
+	return rule.Scope(s)
+}
+
+// CheckFields rejects updates touching fields the subject may not change
+func CheckFields(s Subject, resource string, updates map[string]interface{}) error {
+	rule, ok := ruleFor(s, resource)
+	if !ok {
+		return nil
+	}
+	for _, field := range rule.ReadOnlyFields {
+		if _, found := updates[field]; found {
+			return utils.PermissionDeniedError
+		}
+	}
+	return nil
+}
+
+// CheckOwner makes sure the subject only acts on its own record when the rule requires it
+func CheckOwner(s Subject, resource string, ownerID int) error {
+	rule, ok := ruleFor(s, resource)
+	if !ok || !rule.OwnerOnly {
+		return nil
+	}
+	if s.UserID != ownerID {
+		return utils.PermissionDeniedError
+	}
+	return nil
+}
+
+// teachers are linked to their exec account by email
+func teacherClassScope(s Subject) (string, []interface{}) {
+	return " AND class IN (SELECT t.class FROM teachers t JOIN execs e ON e.email = t.email WHERE e.id = ?)", []interface{}{s.UserID}
+}
+
+func linkedStudentsScope(s Subject) (string, []interface{}) {
+	return " AND id IN (SELECT student_id FROM guardian_students WHERE guardian_id = ?)", []interface{}{s.UserID}
+}
+
+func ownStudentScope(s Subject) (string, []interface{}) {
+	return " AND email IN (SELECT email FROM execs WHERE id = ?)", []interface{}{s.UserID}
+}
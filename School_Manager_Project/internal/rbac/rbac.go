@@ -0,0 +1,194 @@
+package rbac
+
+import (
+	"context"
+	"log/slog"
+	"restapi/internal/models"
+	"restapi/internal/sqlconnect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StudentsRead   = "students:read"
+	StudentsWrite  = "students:write"
+	TeachersRead   = "teachers:read"
+	TeachersWrite  = "teachers:write"
+	ExecsRead      = "execs:read"
+	ExecsWrite     = "execs:write"
+	ReportsRead    = "reports:read"
+	RolesRead      = "roles:read"
+	RolesWrite     = "roles:write"
+	SessionsRevoke = "sessions:revoke" // log other execs out
+	MFAPolicy      = "mfa:policy"      // require two-factor authentication for a role
+	LockoutsManage = "lockouts:manage" // see lockout events, unlock accounts and IPs
+	APIKeysManage  = "apikeys:manage"  // create and revoke API keys
+)
+
+// APIKeyRole is the role put in the context of requests authenticated by an API key,
+// their permissions are the scopes of the key
+const APIKeyRole = "api-key"
+
+// AllPermissions is the list of permissions that can be granted to a role
+var AllPermissions = []string{
+	StudentsRead,
+	StudentsWrite,
+	TeachersRead,
+	TeachersWrite,
+	ExecsRead,
+	ExecsWrite,
+	ReportsRead,
+	RolesRead,
+	RolesWrite,
+	SessionsRevoke,
+	MFAPolicy,
+	LockoutsManage,
+	APIKeysManage,
+}
+
+// DefaultRoles is used when the roles table is empty.
+// "*" grants every permission, "students:*" every permission on students.
+var DefaultRoles = map[string][]string{
+	"admin":    {"*"},
+	"manager":  {"students:*", "teachers:*", ExecsRead, ReportsRead, RolesRead, MFAPolicy},
+	"exec":     {"students:*", "teachers:*", ReportsRead},
+	"teacher":  {StudentsRead, StudentsWrite, TeachersRead},
+	"guardian": {StudentsRead},
+	"student":  {TeachersRead},
+}
+
+const cacheTTL = time.Minute
+
+type registry struct {
+	mu       sync.RWMutex
+	roles    map[string]models.Role
+	loadedAt time.Time
+}
+
+var reg = &registry{}
+
+// getRoles is replaced in tests, there's no database
+var getRoles = sqlconnect.GetRolesDBHandler
+
+// get returns the cached roles, reloaded once they are older than cacheTTL. When the roles
+// can't be read the last loaded ones are kept, and without any every permission is denied.
+func (rg *registry) get() map[string]models.Role {
+	rg.mu.RLock()
+	if rg.roles != nil && time.Since(rg.loadedAt) < cacheTTL {
+		roles := rg.roles
+		rg.mu.RUnlock()
+		return roles
+	}
+	rg.mu.RUnlock()
+
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	if rg.roles != nil && time.Since(rg.loadedAt) < cacheTTL {
+		return rg.roles
+	}
+	roles, err := load()
+	if err != nil {
+		// loadedAt stays, so the next check tries again
+		if rg.roles == nil {
+			slog.Error("unable to load roles, denying every permission", "err", err)
+			return map[string]models.Role{}
+		}
+		slog.Error("unable to load roles, keeping the last loaded ones", "err", err)
+		return rg.roles
+	}
+	rg.roles = roles
+	rg.loadedAt = time.Now()
+	return rg.roles
+}
+
+func load() (map[string]models.Role, error) {
+	// the cache serves every request, so loading it isn't tied to one of them
+	roleList, err := getRoles(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if len(roleList) == 0 {
+		for name, permissions := range DefaultRoles {
+			roleList = append(roleList, models.Role{Name: name, Permissions: permissions})
+		}
+	}
+	roles := make(map[string]models.Role, len(roleList))
+	for _, role := range roleList {
+		roles[role.Name] = role
+	}
+	return roles, nil
+}
+
+// Invalidate makes the next check reload the roles from the database. The cached ones are
+// kept in case that fails.
+func Invalidate() {
+	reg.mu.Lock()
+	reg.loadedAt = time.Time{}
+	reg.mu.Unlock()
+}
+
+func HasPermission(role, permission string) bool {
+	granted, ok := reg.get()[role]
+	if !ok {
+		return false
+	}
+	for _, p := range granted.Permissions {
+		if matches(p, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+func RoleExists(role string) bool {
+	_, ok := reg.get()[role]
+	return ok
+}
+
+// MFARequired reports whether execs with the role have to log in with a second factor
+func MFARequired(role string) bool {
+	return reg.get()[role].MfaRequired
+}
+
+func Roles() []models.Role {
+	var roleList []models.Role
+	for _, role := range reg.get() {
+		roleList = append(roleList, role)
+	}
+	sort.Slice(roleList, func(i, j int) bool { return roleList[i].Name < roleList[j].Name })
+	return roleList
+}
+
+func IsKnownPermission(permission string) bool {
+	if permission == "*" {
+		return true
+	}
+	for _, p := range AllPermissions {
+		if matches(permission, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// GrantsPermission checks a list of granted permissions, like the scopes of an API key
+func GrantsPermission(granted []string, permission string) bool {
+	for _, p := range granted {
+		if matches(p, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(granted, required string) bool {
+	if granted == "*" || granted == required {
+		return true
+	}
+	if resource, found := strings.CutSuffix(granted, ":*"); found {
+		return strings.HasPrefix(required, resource+":")
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package rbac
+
+import (
+	"context"
+	"restapi/internal/models"
+	"restapi/internal/sqlconnect"
+	"restapi/utils"
+	"testing"
+	"time"
+)
+
+// useRoles makes the registry load from the given function instead of the database
+func useRoles(t *testing.T, load func(ctx context.Context) ([]models.Role, error)) {
+	t.Helper()
+	reset := func() {
+		reg.mu.Lock()
+		reg.roles = nil
+		reg.loadedAt = time.Time{}
+		reg.mu.Unlock()
+	}
+	getRoles = load
+	reset()
+	t.Cleanup(func() {
+		getRoles = sqlconnect.GetRolesDBHandler
+		reset()
+	})
+}
+
+func TestRolesUnavailable(t *testing.T) {
+	failing := func(ctx context.Context) ([]models.Role, error) {
+		return nil, utils.DatabaseQueryError
+	}
+
+	t.Run("nothing loaded yet", func(t *testing.T) {
+		useRoles(t, failing)
+		if HasPermission("admin", StudentsRead) || RoleExists("admin") {
+			t.Error("permission granted without any loaded roles")
+		}
+	})
+
+	t.Run("last loaded roles kept", func(t *testing.T) {
+		useRoles(t, func(ctx context.Context) ([]models.Role, error) {
+			return []models.Role{{Name: "teacher", Permissions: []string{StudentsRead}}}, nil
+		})
+		if !HasPermission("teacher", StudentsRead) {
+			t.Fatal("loaded permission denied")
+		}
+		getRoles = failing
+		Invalidate()
+		if !HasPermission("teacher", StudentsRead) {
+			t.Error("last loaded permission denied while the roles can't be read")
+		}
+		if HasPermission("admin", StudentsRead) {
+			t.Error("default role used while the roles can't be read")
+		}
+	})
+
+	t.Run("empty table uses the defaults", func(t *testing.T) {
+		useRoles(t, func(ctx context.Context) ([]models.Role, error) {
+			return nil, nil
+		})
+		if !HasPermission("admin", RolesWrite) || HasPermission("student", StudentsWrite) {
+			t.Error("default roles not used for an empty table")
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"restapi/internal/models"
+	"restapi/utils"
+	"strings"
+	"time"
+)
+
+const apiKeyColumns = "id, name, prefix, scopes, COALESCE(allowed_ips, ''), created_by, created_at, expires_at, last_used_at, last_used_ip, revoked_at"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner, extra ...interface{}) (models.APIKey, error) {
+	var key models.APIKey
+	var scopes, allowedIPs string
+	dest := []interface{}{
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		&scopes,
+		&allowedIPs,
+		&key.CreatedBy,
+		&key.CreatedAt,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.LastUsedIP,
+		&key.RevokedAt,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	key.Scopes = splitList(scopes)
+	key.AllowedIPs = splitList(allowedIPs)
+	return key, nil
+}
+
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func AddAPIKeyDBHandler(ctx context.Context, key models.APIKey, keyHash string, expiresAt *time.Time) (models.APIKey, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return models.APIKey{}, utils.ConnectingToDatabaseError
+	}
+
+	var expires sql.NullTime
+	if expiresAt != nil {
+		expires = sql.NullTime{Time: expiresAt.UTC(), Valid: true}
+	}
+	var allowedIPs sql.NullString
+	if len(key.AllowedIPs) > 0 {
+		allowedIPs = sql.NullString{String: strings.Join(key.AllowedIPs, ","), Valid: true}
+	}
+	res, err := db.ExecContext(ctx, "INSERT INTO api_keys (name, prefix, key_hash, scopes, allowed_ips, created_by, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, UTC_TIMESTAMP(), ?)",
+		key.Name, key.Prefix, keyHash, strings.Join(key.Scopes, ","), allowedIPs, key.CreatedBy, expires)
+	if err != nil {
+		slog.ErrorContext(ctx, "add api key failed", "err", err)
+		return models.APIKey{}, utils.DatabaseQueryError
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return models.APIKey{}, utils.DatabaseQueryError
+	}
+	key, err = scanAPIKey(db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE id = ?", id))
+	if err != nil {
+		slog.ErrorContext(ctx, "add api key failed", "err", err)
+		return models.APIKey{}, utils.DatabaseQueryError
+	}
+	return key, nil
+}
+
+func GetAPIKeysDBHandler(ctx context.Context) ([]models.APIKey, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return nil, utils.ConnectingToDatabaseError
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY id")
+	if err != nil {
+		slog.ErrorContext(ctx, "get api keys failed", "err", err)
+		return nil, utils.DatabaseQueryError
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			slog.ErrorContext(ctx, "get api keys failed", "err", err)
+			return nil, utils.DatabaseQueryError
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, utils.DatabaseQueryError
+	}
+	return keys, nil
+}
+
+// GetActiveAPIKeyDBHandler returns the key with the prefix and its hash, unless it was revoked or expired
+func GetActiveAPIKeyDBHandler(ctx context.Context, prefix string) (models.APIKey, string, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return models.APIKey{}, "", utils.ConnectingToDatabaseError
+	}
+
+	var keyHash string
+	key, err := scanAPIKey(db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+", key_hash FROM api_keys WHERE prefix = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > UTC_TIMESTAMP())", prefix), &keyHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.APIKey{}, "", utils.InvalidAPIKeyError
+	} else if err != nil {
+		slog.ErrorContext(ctx, "get active api key failed", "err", err)
+		return models.APIKey{}, "", utils.DatabaseQueryError
+	}
+	return key, keyHash, nil
+}
+
+// TouchAPIKeyDBHandler records the last use of a key, at most once a minute
+func TouchAPIKeyDBHandler(ctx context.Context, id int, ip string) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	_, err = db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = UTC_TIMESTAMP(), last_used_ip = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < UTC_TIMESTAMP() - INTERVAL 1 MINUTE OR last_used_ip <> ?)",
+		ip, id, ip)
+	if err != nil {
+		slog.ErrorContext(ctx, "touch api key failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	return nil
+}
+
+func RevokeAPIKeyDBHandler(ctx context.Context, id int) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	result, err := db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = UTC_TIMESTAMP() WHERE id = ? AND revoked_at IS NULL", id)
+	if err != nil {
+		slog.ErrorContext(ctx, "revoke api key failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return utils.DatabaseQueryError
+	}
+	if rowsAffected == 0 {
+		return utils.UnitNotFoundError
+	}
+	return nil
+}
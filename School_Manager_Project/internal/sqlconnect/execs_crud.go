@@ -1,11 +1,11 @@
 package sqlconnect
 
 import (
+	"context"
 	"database/sql"
-	"github.com/jmoiron/sqlx"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
-	"os"
 	"reflect"
 	"restapi/internal/models"
 	"restapi/utils"
@@ -14,15 +14,14 @@ import (
 	"time"
 )
 
-func GetExecByID(realID int) (models.Exec, error) {
+func GetExecByID(ctx context.Context, realID int) (models.Exec, error) {
 	db, err := ConnectDb()
 	if err != nil {
 		return models.Exec{}, utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
 
 	var exec models.Exec
-	err = db.QueryRow(
+	err = db.QueryRowContext(ctx,
 		"SELECT id, first_name, last_name, email, username, user_created_at, inactive_status, role FROM execs WHERE id = ?",
 		realID,
 	).Scan(&exec.ID,
@@ -76,53 +75,74 @@ func GetExecByID(realID int) (models.Exec, error) {
 //}
 
 func GetExecsDBHandler(r *http.Request) ([]models.Exec, error) {
+	ctx := r.Context()
 	query := "SELECT id, first_name, last_name, email, username, user_created_at, inactive_status, role FROM execs WHERE 1=1"
 	var args []interface{}
 
-	query, args = utils.AddSearchFilters(r, query, args)
-	query, err := utils.AddSortFilters(r, query)
+	query, args = utils.AddSearchFilters(r, query, args, models.ExecFilterFields)
+	query, err := utils.AddSortFilters(r, query, models.ExecFilterFields)
 	if err != nil {
 		return nil, err
 	}
 
-	db, err := sqlx.Connect("mysql", os.Getenv("CONNECTION_STRING"))
+	db, err := connectDbx()
 	if err != nil {
 		return nil, utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
 
 	var execs []models.Exec
-	err = db.Select(&execs, query, args...)
+	err = db.SelectContext(ctx, &execs, query, args...)
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(ctx, "get execs failed", "err", err)
 		return nil, utils.DatabaseQueryError
 	}
 
 	return execs, nil
 }
 
-func AddExecsDBHandler(newExecs []models.Exec) ([]models.Exec, error) {
+// AddExecsDBHandler creates the execs as inactive and stores an invite for each of them,
+// tokenHashes[i] belongs to newExecs[i]. The execs get a random password nobody knows
+// until they accept the invite.
+func AddExecsDBHandler(ctx context.Context, newExecs []models.Exec, invitedBy int, tokenHashes []string, expiresAt time.Time) ([]models.Exec, error) {
 	db, err := ConnectDb()
 	if err != nil {
 		return nil, utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
-	stmt, err := db.Prepare("INSERT INTO execs (first_name, last_name, email, username, password, role) VALUES (?, ?, ?, ?, ?, ?)") // will prepare SQL for execution
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, utils.UnableToStartTransactionError
+	}
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO execs (first_name, last_name, email, username, password, role, inactive_status, user_created_at) VALUES (?, ?, ?, ?, ?, ?, TRUE, NULL)") // will prepare SQL for execution
 	if err != nil {
-		log.Println("ERR 1:", err)
+		tx.Rollback()
+		slog.ErrorContext(ctx, "add execs failed", "err", err)
 		return nil, utils.DatabaseQueryError
 	}
 	defer stmt.Close()
 
+	// API keys create execs without a user behind them
+	var createdBy sql.NullInt64
+	if invitedBy > 0 {
+		createdBy = sql.NullInt64{Int64: int64(invitedBy), Valid: true}
+	}
+
 	addedExecs := make([]models.Exec, len(newExecs))
 	for i, exec := range newExecs {
-		exec.Password, err = utils.Hash(exec.Password)
+		placeholder, err := utils.GenerateRandomToken(32)
 		if err != nil {
+			tx.Rollback()
 			return nil, err
 		}
-		res, err := stmt.Exec(exec.FirstName, exec.LastName, exec.Email, exec.Username, exec.Password, exec.Role)
+		unusableHash, err := utils.Hash(placeholder)
 		if err != nil {
-			log.Println("ERR 2:", err)
+			tx.Rollback()
+			return nil, err
+		}
+		res, err := stmt.ExecContext(ctx, exec.FirstName, exec.LastName, exec.Email, exec.Username, unusableHash, exec.Role)
+		if err != nil {
+			tx.Rollback()
+			slog.ErrorContext(ctx, "add execs failed", "err", err)
 			if strings.Contains(err.Error(), "Duplicate entry") {
 				return nil, utils.DuplicateEmailError
 			} else if strings.Contains(err.Error(), "a foreign key constraint fails") {
@@ -132,23 +152,38 @@ func AddExecsDBHandler(newExecs []models.Exec) ([]models.Exec, error) {
 		}
 		lastID, err := res.LastInsertId()
 		if err != nil {
-			log.Println("ERR 3:", err)
+			tx.Rollback()
+			slog.ErrorContext(ctx, "add execs failed", "err", err)
 			return nil, utils.DatabaseQueryError
 		}
 		exec.ID = int(lastID)
+		exec.Password = ""
+		exec.InactiveStatus = true
+		exec.UserCreatedAt = sql.NullString{}
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO exec_invites (exec_id, token_hash, created_by, created_at, expires_at) VALUES (?, ?, ?, UTC_TIMESTAMP(), ?)",
+			exec.ID, tokenHashes[i], createdBy, expiresAt.UTC().Format(dbTimeFormat))
+		if err != nil {
+			tx.Rollback()
+			slog.ErrorContext(ctx, "add execs failed", "err", err)
+			return nil, utils.DatabaseQueryError
+		}
 		addedExecs[i] = exec
 	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, utils.ErrorCommitingTransaction
+	}
 	return addedExecs, nil
 }
 
-func PatchExecsDBHandler(updates []map[string]interface{}) error {
+func PatchExecsDBHandler(ctx context.Context, updates []map[string]interface{}) error {
 	db, err := ConnectDb()
 	if err != nil {
 		return utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return utils.UnableToStartTransactionError
 	}
@@ -164,7 +199,7 @@ func PatchExecsDBHandler(updates []map[string]interface{}) error {
 			return utils.InvalidIdError
 		}
 		var execFromDb models.Exec
-		err = db.QueryRow("SELECT id, first_name, last_name, email, username FROM execs WHERE id = ?", id).Scan(
+		err = db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, username FROM execs WHERE id = ?", id).Scan(
 			&execFromDb.ID,
 			&execFromDb.FirstName,
 			&execFromDb.LastName,
@@ -194,7 +229,7 @@ func PatchExecsDBHandler(updates []map[string]interface{}) error {
 							fieldVal.Set(val.Convert(fieldVal.Type()))
 						} else {
 							tx.Rollback()
-							log.Printf("cannot convert %v to %v", val.Type(), fieldVal.Type())
+							slog.WarnContext(ctx, "cannot convert patch value", "from", val.Type().String(), "to", fieldVal.Type().String())
 							return utils.InvalidUpdateParametersError
 						}
 					}
@@ -202,7 +237,7 @@ func PatchExecsDBHandler(updates []map[string]interface{}) error {
 				}
 			}
 		}
-		_, err = tx.Exec("UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ? WHERE id = ?",
+		_, err = tx.ExecContext(ctx, "UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ? WHERE id = ?",
 			execFromDb.FirstName,
 			execFromDb.LastName,
 			execFromDb.Email,
@@ -220,15 +255,14 @@ func PatchExecsDBHandler(updates []map[string]interface{}) error {
 	return nil
 }
 
-func PatchOneExecDBHandler(id int, updates map[string]interface{}) (models.Exec, error) {
+func PatchOneExecDBHandler(ctx context.Context, id int, updates map[string]interface{}) (models.Exec, error) {
 	db, err := ConnectDb()
 	if err != nil {
 		return models.Exec{}, utils.DatabaseQueryError
 	}
-	defer db.Close()
 
 	var existingExec models.Exec
-	err = db.QueryRow("SELECT id, first_name, last_name, email, username FROM execs WHERE id = ?", id).Scan(
+	err = db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, username FROM execs WHERE id = ?", id).Scan(
 		&existingExec.ID,
 		&existingExec.FirstName,
 		&existingExec.LastName,
@@ -256,7 +290,7 @@ func PatchOneExecDBHandler(id int, updates map[string]interface{}) (models.Exec,
 		}
 	}
 
-	_, err = db.Exec("UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ? WHERE id = ?",
+	_, err = db.ExecContext(ctx, "UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ? WHERE id = ?",
 		existingExec.FirstName,
 		existingExec.LastName,
 		existingExec.Email,
@@ -268,14 +302,13 @@ func PatchOneExecDBHandler(id int, updates map[string]interface{}) (models.Exec,
 	return existingExec, nil
 }
 
-func DeleteOneExecDBHandler(id int) error {
+func DeleteOneExecDBHandler(ctx context.Context, id int) error {
 	db, err := ConnectDb()
 	if err != nil {
 		return utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
 
-	result, err := db.Exec("DELETE FROM execs WHERE id = ?", id)
+	result, err := db.ExecContext(ctx, "DELETE FROM execs WHERE id = ?", id)
 	if err != nil {
 		return utils.DatabaseQueryError
 	}
@@ -289,15 +322,14 @@ func DeleteOneExecDBHandler(id int) error {
 	return nil
 }
 
-func LoginDBHandler(username string) (models.Exec, error) {
+func LoginDBHandler(ctx context.Context, username string) (models.Exec, error) {
 	db, err := ConnectDb()
 	if err != nil {
 		return models.Exec{}, utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
 
 	var user models.Exec
-	err = db.QueryRow("SELECT id, first_name, last_name, email, username, password, inactive_status, role FROM execs WHERE username = ?", username).Scan(
+	err = db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, username, password, inactive_status, role, token_version, mfa_enabled FROM execs WHERE username = ?", username).Scan(
 		&user.ID,
 		&user.FirstName,
 		&user.LastName,
@@ -305,48 +337,237 @@ func LoginDBHandler(username string) (models.Exec, error) {
 		&user.Username,
 		&user.Password,
 		&user.InactiveStatus,
-		&user.Role)
+		&user.Role,
+		&user.TokenVersion,
+		&user.MfaEnabled)
 	if err == sql.ErrNoRows {
-		return models.Exec{}, utils.UnitNotFoundError
+		return models.Exec{}, utils.InvalidCredentialsError
 	} else if err != nil {
-		log.Println(err)
+		slog.ErrorContext(ctx, "get exec for login failed", "err", err)
 		return models.Exec{}, utils.DatabaseQueryError
 	}
 	return user, nil
 }
 
-func UpdatePasswordInDB(userId int, newPassword, currentPassword string) (string, error) {
+// RehashPasswordDBHandler swaps the stored hash for one with the current argon2 parameters.
+// It doesn't count as a password change, so sessions and password_changed_at are left alone.
+func RehashPasswordDBHandler(ctx context.Context, execID int, oldHash, newHash string) error {
 	db, err := ConnectDb()
 	if err != nil {
-		return "", utils.ConnectingToDatabaseError
+		return utils.ConnectingToDatabaseError
 	}
-	var username string
-	var userPassword string
-	var userRole string
-	err = db.QueryRow("SELECT username, password, role FROM execs WHERE id = ?", userId).Scan(&username, &userPassword, &userRole)
 
+	// a password changed in the meantime must not be overwritten
+	_, err = db.ExecContext(ctx, "UPDATE execs SET password = ? WHERE id = ? AND password = ?", newHash, execID, oldHash)
 	if err != nil {
-		return "", utils.UnitNotFoundError
+		slog.ErrorContext(ctx, "rehash password failed", "err", err)
+		return utils.DatabaseQueryError
 	}
+	return nil
+}
 
-	_, err = utils.VerifyPassword(userPassword, currentPassword)
+// UpdatePasswordInDB changes the password and invalidates every token issued before the change
+func UpdatePasswordInDB(ctx context.Context, userId int, newPassword, currentPassword string) (models.Exec, error) {
+	db, err := ConnectDb()
 	if err != nil {
-		return "", err
+		return models.Exec{}, utils.ConnectingToDatabaseError
+	}
+
+	user := models.Exec{ID: userId}
+	err = db.QueryRowContext(ctx, "SELECT first_name, last_name, email, username, password, role, token_version, mfa_enabled FROM execs WHERE id = ?", userId).Scan(
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+		&user.Username,
+		&user.Password,
+		&user.Role,
+		&user.TokenVersion,
+		&user.MfaEnabled)
+
+	if err != nil {
+		return models.Exec{}, utils.UnitNotFoundError
+	}
+
+	_, err = utils.VerifyPassword(user.Password, currentPassword)
+	if err != nil {
+		return models.Exec{}, err
+	}
+
+	fieldErrs := utils.CheckPassword("new_password", newPassword, user.Username, user.Email, user.FirstName, user.LastName)
+	if len(fieldErrs) > 0 {
+		return models.Exec{}, fieldErrs
 	}
 
 	hashedPassword, err := utils.Hash(newPassword)
 	if err != nil {
-		return "", err
+		return models.Exec{}, err
 	}
 
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Exec{}, utils.UnableToStartTransactionError
+	}
+	err = rotatePasswordHistory(ctx, tx, userId, user.Password, newPassword, "new_password")
+	if err != nil {
+		tx.Rollback()
+		return models.Exec{}, err
+	}
 	currentTime := time.Now().Format(time.RFC3339)
-	_, err = db.Exec("UPDATE execs SET password = ?, password_changed_at = ? WHERE id = ?", hashedPassword, currentTime, userId)
+	_, err = tx.ExecContext(ctx, "UPDATE execs SET password = ?, password_changed_at = ?, token_version = token_version + 1 WHERE id = ?", hashedPassword, currentTime, userId)
+	if err != nil {
+		tx.Rollback()
+		return models.Exec{}, utils.DatabaseQueryError
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = UTC_TIMESTAMP() WHERE exec_id = ? AND revoked_at IS NULL", userId)
 	if err != nil {
-		return "", utils.DatabaseQueryError
+		tx.Rollback()
+		return models.Exec{}, utils.DatabaseQueryError
+	}
+	err = tx.Commit()
+	if err != nil {
+		return models.Exec{}, utils.ErrorCommitingTransaction
 	}
-	token, err := utils.SignToken(userId, username, userRole)
+	user.Password = ""
+	user.TokenVersion++
+	return user, nil
+}
+
+const dbTimeFormat = "2006-01-02 15:04:05"
+
+// SetPasswordResetTokenDBHandler stores the hashed reset code for the active exec with the given email
+func SetPasswordResetTokenDBHandler(ctx context.Context, email, tokenHash string, expiresAt time.Time) (models.Exec, error) {
+	db, err := ConnectDb()
 	if err != nil {
-		return "", err
+		return models.Exec{}, utils.ConnectingToDatabaseError
 	}
-	return token, nil
+
+	var exec models.Exec
+	err = db.QueryRowContext(ctx, "SELECT id, first_name, email, username FROM execs WHERE email = ? AND inactive_status = FALSE", email).Scan(
+		&exec.ID,
+		&exec.FirstName,
+		&exec.Email,
+		&exec.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Exec{}, utils.UnitNotFoundError
+	} else if err != nil {
+		slog.ErrorContext(ctx, "set password reset token failed", "err", err)
+		return models.Exec{}, utils.DatabaseQueryError
+	}
+
+	_, err = db.ExecContext(ctx, "UPDATE execs SET password_reset_token = ?, password_token_expires = ? WHERE id = ?",
+		tokenHash, expiresAt.UTC().Format(dbTimeFormat), exec.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "set password reset token failed", "err", err)
+		return models.Exec{}, utils.DatabaseQueryError
+	}
+	return exec, nil
+}
+
+// ResetPasswordDBHandler sets a new password for the exec holding the reset code. The code can
+// only be used once and every existing session of the exec is logged out.
+func ResetPasswordDBHandler(ctx context.Context, tokenHash, newPassword string) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return utils.UnableToStartTransactionError
+	}
+	var id int
+	var user models.Exec
+	err = tx.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, username, password FROM execs WHERE password_reset_token = ? AND password_token_expires > ? FOR UPDATE",
+		tokenHash, time.Now().UTC().Format(dbTimeFormat)).Scan(
+		&id,
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+		&user.Username,
+		&user.Password)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return utils.InvalidResetCodeError
+	} else if err != nil {
+		tx.Rollback()
+		return utils.DatabaseQueryError
+	}
+	fieldErrs := utils.CheckPassword("new_password", newPassword, user.Username, user.Email, user.FirstName, user.LastName)
+	if len(fieldErrs) > 0 {
+		tx.Rollback()
+		return fieldErrs
+	}
+	err = rotatePasswordHistory(ctx, tx, id, user.Password, newPassword, "new_password")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// hashing is expensive on purpose, so it only runs once the code and the password passed
+	hashedPassword, err := utils.Hash(newPassword)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	currentTime := time.Now().Format(time.RFC3339)
+	_, err = tx.ExecContext(ctx, `UPDATE execs SET password = ?, password_changed_at = ?, password_reset_token = NULL,
+		password_token_expires = NULL, token_version = token_version + 1 WHERE id = ?`, hashedPassword, currentTime, id)
+	if err != nil {
+		tx.Rollback()
+		return utils.DatabaseQueryError
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = UTC_TIMESTAMP() WHERE exec_id = ? AND revoked_at IS NULL", id)
+	if err != nil {
+		tx.Rollback()
+		return utils.DatabaseQueryError
+	}
+	err = tx.Commit()
+	if err != nil {
+		return utils.ErrorCommitingTransaction
+	}
+	return nil
+}
+
+// rotatePasswordHistory refuses a new password that matches the current one or one of the
+// previous PASSWORD_HISTORY - 1, then moves the current hash into the history
+func rotatePasswordHistory(ctx context.Context, tx *sql.Tx, execID int, currentHash, newPassword, field string) error {
+	keep := utils.PasswordHistorySize() - 1
+	hashes := []string{currentHash}
+	rows, err := tx.QueryContext(ctx, "SELECT password_hash FROM password_history WHERE exec_id = ? ORDER BY id DESC LIMIT ?", execID, keep)
+	if err != nil {
+		slog.ErrorContext(ctx, "rotate password history failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	for rows.Next() {
+		var hash string
+		err = rows.Scan(&hash)
+		if err != nil {
+			rows.Close()
+			return utils.DatabaseQueryError
+		}
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+	for _, hash := range hashes {
+		if match, _ := utils.VerifyPassword(hash, newPassword); match {
+			return utils.PasswordReusedError(field)
+		}
+	}
+
+	if keep <= 0 {
+		return nil
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO password_history (exec_id, password_hash, created_at) VALUES (?, ?, UTC_TIMESTAMP())", execID, currentHash)
+	if err != nil {
+		slog.ErrorContext(ctx, "rotate password history failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	// only the newest entries are ever compared
+	_, err = tx.ExecContext(ctx, `DELETE FROM password_history WHERE exec_id = ? AND id NOT IN (
+		SELECT id FROM (SELECT id FROM password_history WHERE exec_id = ? ORDER BY id DESC LIMIT ?) newest)`, execID, execID, keep)
+	if err != nil {
+		slog.ErrorContext(ctx, "rotate password history failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	return nil
 }
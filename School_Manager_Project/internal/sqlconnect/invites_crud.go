@@ -0,0 +1,169 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"restapi/internal/models"
+	"restapi/utils"
+	"time"
+)
+
+func GetInviteDBHandler(ctx context.Context, execID int) (models.ExecInvite, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return models.ExecInvite{}, utils.ConnectingToDatabaseError
+	}
+
+	var invite models.ExecInvite
+	err = db.QueryRowContext(ctx, `SELECT i.exec_id, e.email, i.created_by, i.created_at, i.expires_at, i.sent_count, i.accepted_at, i.revoked_at
+		FROM exec_invites i JOIN execs e ON e.id = i.exec_id WHERE i.exec_id = ?`, execID).Scan(
+		&invite.ExecID,
+		&invite.Email,
+		&invite.CreatedBy,
+		&invite.CreatedAt,
+		&invite.ExpiresAt,
+		&invite.SentCount,
+		&invite.AcceptedAt,
+		&invite.RevokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.ExecInvite{}, utils.UnitNotFoundError
+	} else if err != nil {
+		slog.ErrorContext(ctx, "get invite failed", "err", err)
+		return models.ExecInvite{}, utils.DatabaseQueryError
+	}
+
+	switch {
+	case invite.AcceptedAt.Valid:
+		invite.Status = "accepted"
+	case invite.RevokedAt.Valid:
+		invite.Status = "revoked"
+	case invite.ExpiresAt <= time.Now().UTC().Format(dbTimeFormat):
+		invite.Status = "expired"
+	default:
+		invite.Status = "pending"
+	}
+	return invite, nil
+}
+
+// ResendInviteDBHandler replaces the invite token of an exec that hasn't accepted yet, which
+// also reopens revoked or expired invites. It returns the exec to mail the new link to.
+func ResendInviteDBHandler(ctx context.Context, execID, actorID int, tokenHash string, expiresAt time.Time) (models.Exec, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return models.Exec{}, utils.ConnectingToDatabaseError
+	}
+
+	var exec models.Exec
+	err = db.QueryRowContext(ctx, "SELECT id, first_name, email, role, user_created_at FROM execs WHERE id = ?", execID).Scan(
+		&exec.ID,
+		&exec.FirstName,
+		&exec.Email,
+		&exec.Role,
+		&exec.UserCreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Exec{}, utils.UnitNotFoundError
+	} else if err != nil {
+		slog.ErrorContext(ctx, "resend invite failed", "err", err)
+		return models.Exec{}, utils.DatabaseQueryError
+	}
+	if exec.UserCreatedAt.Valid {
+		return models.Exec{}, utils.InviteAlreadyAcceptedError
+	}
+
+	var createdBy sql.NullInt64
+	if actorID > 0 {
+		createdBy = sql.NullInt64{Int64: int64(actorID), Valid: true}
+	}
+	_, err = db.ExecContext(ctx, `INSERT INTO exec_invites (exec_id, token_hash, created_by, created_at, expires_at) VALUES (?, ?, ?, UTC_TIMESTAMP(), ?)
+		ON DUPLICATE KEY UPDATE token_hash = VALUES(token_hash), created_by = VALUES(created_by), expires_at = VALUES(expires_at),
+		sent_count = sent_count + 1, revoked_at = NULL`,
+		execID, tokenHash, createdBy, expiresAt.UTC().Format(dbTimeFormat))
+	if err != nil {
+		slog.ErrorContext(ctx, "resend invite failed", "err", err)
+		return models.Exec{}, utils.DatabaseQueryError
+	}
+	return exec, nil
+}
+
+func RevokeInviteDBHandler(ctx context.Context, execID int) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	result, err := db.ExecContext(ctx, "UPDATE exec_invites SET revoked_at = UTC_TIMESTAMP() WHERE exec_id = ? AND accepted_at IS NULL AND revoked_at IS NULL", execID)
+	if err != nil {
+		slog.ErrorContext(ctx, "revoke invite failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return utils.DatabaseQueryError
+	}
+	if rowsAffected == 0 {
+		return utils.UnitNotFoundError
+	}
+	return nil
+}
+
+// AcceptInviteDBHandler sets the password the invited exec picked and activates the account.
+// The invite can only be used once.
+func AcceptInviteDBHandler(ctx context.Context, execID int, tokenHash, newPassword string) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return utils.UnableToStartTransactionError
+	}
+	var user models.Exec
+	err = tx.QueryRowContext(ctx, `SELECT e.first_name, e.last_name, e.email, e.username FROM exec_invites i JOIN execs e ON e.id = i.exec_id
+		WHERE i.exec_id = ? AND i.token_hash = ? AND i.accepted_at IS NULL AND i.revoked_at IS NULL AND i.expires_at > ? FOR UPDATE`,
+		execID, tokenHash, time.Now().UTC().Format(dbTimeFormat)).Scan(
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+		&user.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return utils.InvalidInviteError
+	} else if err != nil {
+		tx.Rollback()
+		slog.ErrorContext(ctx, "accept invite failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	fieldErrs := utils.CheckPassword("new_password", newPassword, user.Username, user.Email, user.FirstName, user.LastName)
+	if len(fieldErrs) > 0 {
+		tx.Rollback()
+		return fieldErrs
+	}
+	hashedPassword, err := utils.Hash(newPassword)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	currentTime := time.Now().Format(time.RFC3339)
+	_, err = tx.ExecContext(ctx, `UPDATE execs SET password = ?, password_changed_at = ?, user_created_at = UTC_TIMESTAMP(),
+		inactive_status = FALSE, token_version = token_version + 1 WHERE id = ?`, hashedPassword, currentTime, execID)
+	if err != nil {
+		tx.Rollback()
+		slog.ErrorContext(ctx, "accept invite failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE exec_invites SET accepted_at = UTC_TIMESTAMP() WHERE exec_id = ?", execID)
+	if err != nil {
+		tx.Rollback()
+		slog.ErrorContext(ctx, "accept invite failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	err = tx.Commit()
+	if err != nil {
+		return utils.ErrorCommitingTransaction
+	}
+	return nil
+}
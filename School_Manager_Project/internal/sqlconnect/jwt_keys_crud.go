@@ -0,0 +1,60 @@
+package sqlconnect
+
+import (
+	"context"
+	"log/slog"
+	"restapi/internal/models"
+	"restapi/utils"
+	"time"
+)
+
+// GetJWTKeysDBHandler returns every key that can still verify tokens, oldest first
+func GetJWTKeysDBHandler(ctx context.Context) ([]models.JWTKey, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return nil, utils.ConnectingToDatabaseError
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT kid, alg, private_key, COALESCE(public_key, ''), activates_at, retires_at, expires_at FROM jwt_keys WHERE expires_at > UTC_TIMESTAMP() ORDER BY activates_at")
+	if err != nil {
+		slog.ErrorContext(ctx, "get jwt keys failed", "err", err)
+		return nil, utils.DatabaseQueryError
+	}
+	defer rows.Close()
+
+	var keys []models.JWTKey
+	for rows.Next() {
+		var key models.JWTKey
+		err = rows.Scan(&key.Kid, &key.Alg, &key.PrivateKey, &key.PublicKey, &key.ActivatesAt, &key.RetiresAt, &key.ExpiresAt)
+		if err != nil {
+			slog.ErrorContext(ctx, "get jwt keys failed", "err", err)
+			return nil, utils.DatabaseQueryError
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "get jwt keys failed", "err", err)
+		return nil, utils.DatabaseQueryError
+	}
+	return keys, nil
+}
+
+// AddJWTKeyDBHandler stores a new signing key and drops the expired ones
+func AddJWTKeyDBHandler(ctx context.Context, key models.JWTKey, activatesAt, retiresAt, expiresAt time.Time) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	_, err = db.ExecContext(ctx, "INSERT INTO jwt_keys (kid, alg, private_key, public_key, activates_at, retires_at, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())",
+		key.Kid, key.Alg, key.PrivateKey, key.PublicKey, activatesAt.UTC(), retiresAt.UTC(), expiresAt.UTC())
+	if err != nil {
+		slog.ErrorContext(ctx, "add jwt key failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	_, err = db.ExecContext(ctx, "DELETE FROM jwt_keys WHERE expires_at < UTC_TIMESTAMP()")
+	if err != nil {
+		slog.ErrorContext(ctx, "add jwt key failed", "err", err)
+	}
+	return nil
+}
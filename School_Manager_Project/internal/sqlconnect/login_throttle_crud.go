@@ -0,0 +1,206 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"restapi/internal/models"
+	"restapi/utils"
+	"time"
+)
+
+const (
+	ThrottleScopeAccount = "account"
+	ThrottleScopeIP      = "ip"
+)
+
+// GetLoginLockDBHandler returns until when logins for the username or from the ip are blocked,
+// the zero time if they aren't
+func GetLoginLockDBHandler(ctx context.Context, username, ip string) (time.Time, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return time.Time{}, utils.ConnectingToDatabaseError
+	}
+
+	var lockedUntil sql.NullString
+	err = db.QueryRowContext(ctx, `SELECT MAX(locked_until) FROM login_throttle
+		WHERE ((scope = ? AND subject = ?) OR (scope = ? AND subject = ?)) AND locked_until > UTC_TIMESTAMP()`,
+		ThrottleScopeAccount, username, ThrottleScopeIP, ip).Scan(&lockedUntil)
+	if err != nil {
+		slog.ErrorContext(ctx, "get login lock failed", "err", err)
+		return time.Time{}, utils.DatabaseQueryError
+	}
+	if !lockedUntil.Valid {
+		return time.Time{}, nil
+	}
+	until, err := time.Parse(dbTimeFormat, lockedUntil.String)
+	if err != nil {
+		return time.Time{}, utils.DatabaseQueryError
+	}
+	return until, nil
+}
+
+// RecordLoginFailureDBHandler counts a failed login against the username and the ip and locks
+// whichever went over its limit. It returns until when the login is now blocked.
+func RecordLoginFailureDBHandler(ctx context.Context, username, ip string) (time.Time, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return time.Time{}, utils.ConnectingToDatabaseError
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, utils.UnableToStartTransactionError
+	}
+	accountUntil, err := recordFailure(ctx, tx, ThrottleScopeAccount, username, ip, utils.LoginMaxAttempts())
+	if err != nil {
+		tx.Rollback()
+		return time.Time{}, err
+	}
+	ipUntil, err := recordFailure(ctx, tx, ThrottleScopeIP, ip, ip, utils.LoginMaxAttemptsPerIP())
+	if err != nil {
+		tx.Rollback()
+		return time.Time{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return time.Time{}, utils.ErrorCommitingTransaction
+	}
+	if ipUntil.After(accountUntil) {
+		return ipUntil, nil
+	}
+	return accountUntil, nil
+}
+
+func recordFailure(ctx context.Context, tx *sql.Tx, scope, subject, ip string, limit int) (time.Time, error) {
+	now := time.Now().UTC()
+	var failures int
+	var lastActivity string
+	// a lockout counts as activity so the backoff keeps growing when attempts resume right after it
+	err := tx.QueryRowContext(ctx, "SELECT failures, GREATEST(last_failed_at, COALESCE(locked_until, last_failed_at)) FROM login_throttle WHERE scope = ? AND subject = ? FOR UPDATE", scope, subject).Scan(
+		&failures,
+		&lastActivity)
+	if errors.Is(err, sql.ErrNoRows) {
+		failures = 0
+	} else if err != nil {
+		slog.ErrorContext(ctx, "record login failure failed", "err", err)
+		return time.Time{}, utils.DatabaseQueryError
+	} else if last, err := time.Parse(dbTimeFormat, lastActivity); err != nil || now.Sub(last) > utils.LoginFailureWindow() {
+		// failures older than the window are forgotten
+		failures = 0
+	}
+	failures++
+
+	var lockedUntil sql.NullTime
+	if duration := utils.LockoutDuration(failures, limit); duration > 0 {
+		lockedUntil = sql.NullTime{Time: now.Add(duration), Valid: true}
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO login_throttle (scope, subject, failures, last_failed_at, locked_until) VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE failures = VALUES(failures), last_failed_at = VALUES(last_failed_at), locked_until = VALUES(locked_until)`,
+		scope, subject, failures, now, lockedUntil)
+	if err != nil {
+		slog.ErrorContext(ctx, "record login failure failed", "err", err)
+		return time.Time{}, utils.DatabaseQueryError
+	}
+	if !lockedUntil.Valid {
+		return time.Time{}, nil
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO lockout_events (scope, subject, event, ip, failures, locked_until, created_at) VALUES (?, ?, 'locked', ?, ?, ?, UTC_TIMESTAMP())",
+		scope, subject, ip, failures, lockedUntil)
+	if err != nil {
+		slog.ErrorContext(ctx, "record login failure failed", "err", err)
+		return time.Time{}, utils.DatabaseQueryError
+	}
+	return lockedUntil.Time, nil
+}
+
+// ClearLoginFailuresDBHandler resets the failed attempts of a username after a successful login
+func ClearLoginFailuresDBHandler(ctx context.Context, username string) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	_, err = db.ExecContext(ctx, "DELETE FROM login_throttle WHERE scope = ? AND subject = ?", ThrottleScopeAccount, username)
+	if err != nil {
+		slog.ErrorContext(ctx, "clear login failures failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	// rows that are neither locked nor inside the failure window are not needed anymore
+	_, err = db.ExecContext(ctx, "DELETE FROM login_throttle WHERE (locked_until IS NULL OR locked_until < UTC_TIMESTAMP()) AND last_failed_at < ?",
+		time.Now().UTC().Add(-utils.LoginFailureWindow()))
+	if err != nil {
+		slog.ErrorContext(ctx, "clear login failures failed", "err", err)
+	}
+	return nil
+}
+
+// UnlockExecDBHandler lifts the lockout of an exec's username
+func UnlockExecDBHandler(ctx context.Context, execID, actorID int) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	var username string
+	err = db.QueryRowContext(ctx, "SELECT username FROM execs WHERE id = ?", execID).Scan(&username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return utils.UnitNotFoundError
+	} else if err != nil {
+		slog.ErrorContext(ctx, "unlock exec failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	return unlock(ctx, db, ThrottleScopeAccount, username, actorID)
+}
+
+// UnlockIPDBHandler lifts the lockout of a client IP
+func UnlockIPDBHandler(ctx context.Context, ip string, actorID int) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	return unlock(ctx, db, ThrottleScopeIP, ip, actorID)
+}
+
+func unlock(ctx context.Context, db *sql.DB, scope, subject string, actorID int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return utils.UnableToStartTransactionError
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM login_throttle WHERE scope = ? AND subject = ?", scope, subject)
+	if err != nil {
+		tx.Rollback()
+		slog.ErrorContext(ctx, "unlock failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO lockout_events (scope, subject, event, actor_id, created_at) VALUES (?, ?, 'unlocked', ?, UTC_TIMESTAMP())",
+		scope, subject, actorID)
+	if err != nil {
+		tx.Rollback()
+		slog.ErrorContext(ctx, "unlock failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	err = tx.Commit()
+	if err != nil {
+		return utils.ErrorCommitingTransaction
+	}
+	return nil
+}
+
+// GetLockoutEventsDBHandler returns the latest lockout events, newest first
+func GetLockoutEventsDBHandler(ctx context.Context, limit int) ([]models.LockoutEvent, error) {
+	db, err := connectDbx()
+	if err != nil {
+		return nil, utils.ConnectingToDatabaseError
+	}
+
+	var events []models.LockoutEvent
+	err = db.SelectContext(ctx, &events, "SELECT id, scope, subject, event, ip, failures, locked_until, actor_id, created_at FROM lockout_events ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		slog.ErrorContext(ctx, "get lockout events failed", "err", err)
+		return nil, utils.DatabaseQueryError
+	}
+	return events, nil
+}
@@ -0,0 +1,222 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"restapi/internal/models"
+	"restapi/utils"
+)
+
+// GetMFAStateDBHandler returns the exec with its decrypted TOTP secret, empty if none was enrolled
+func GetMFAStateDBHandler(ctx context.Context, execID int) (models.Exec, string, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return models.Exec{}, "", utils.ConnectingToDatabaseError
+	}
+
+	var user models.Exec
+	var encryptedSecret sql.NullString
+	err = db.QueryRowContext(ctx, "SELECT id, email, username, inactive_status, role, token_version, mfa_enabled, mfa_secret FROM execs WHERE id = ?", execID).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Username,
+		&user.InactiveStatus,
+		&user.Role,
+		&user.TokenVersion,
+		&user.MfaEnabled,
+		&encryptedSecret)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Exec{}, "", utils.UnitNotFoundError
+	} else if err != nil {
+		slog.ErrorContext(ctx, "get mfa state failed", "err", err)
+		return models.Exec{}, "", utils.DatabaseQueryError
+	}
+	if !encryptedSecret.Valid || encryptedSecret.String == "" {
+		return user, "", nil
+	}
+	secret, err := utils.DecryptSecret(encryptedSecret.String)
+	if err != nil {
+		return models.Exec{}, "", err
+	}
+	return user, secret, nil
+}
+
+// SetPendingMFASecretDBHandler stores a new secret that only becomes active once a code is verified
+func SetPendingMFASecretDBHandler(ctx context.Context, execID int, secret string) error {
+	encryptedSecret, err := utils.EncryptSecret(secret)
+	if err != nil {
+		return err
+	}
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	result, err := db.ExecContext(ctx, "UPDATE execs SET mfa_secret = ?, mfa_last_step = 0 WHERE id = ? AND mfa_enabled = FALSE", encryptedSecret, execID)
+	if err != nil {
+		slog.ErrorContext(ctx, "set pending mfa secret failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return utils.DatabaseQueryError
+	}
+	if rowsAffected == 0 {
+		return utils.MFAAlreadyEnabledError
+	}
+	return nil
+}
+
+// UseTOTPStepDBHandler records the time step of an accepted code, a step can only be used once
+func UseTOTPStepDBHandler(ctx context.Context, execID int, step int64) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	result, err := db.ExecContext(ctx, "UPDATE execs SET mfa_last_step = ? WHERE id = ? AND mfa_last_step < ?", step, execID, step)
+	if err != nil {
+		return utils.DatabaseQueryError
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return utils.DatabaseQueryError
+	}
+	if rowsAffected == 0 {
+		return utils.InvalidMFACodeError
+	}
+	return nil
+}
+
+// EnableMFADBHandler turns on MFA, replaces the recovery codes and logs out every other session
+func EnableMFADBHandler(ctx context.Context, execID int, recoveryCodeHashes []string) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return utils.UnableToStartTransactionError
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE execs SET mfa_enabled = TRUE, token_version = token_version + 1 WHERE id = ?", execID)
+	if err != nil {
+		tx.Rollback()
+		return utils.DatabaseQueryError
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = UTC_TIMESTAMP() WHERE exec_id = ? AND revoked_at IS NULL", execID)
+	if err != nil {
+		tx.Rollback()
+		return utils.DatabaseQueryError
+	}
+	err = replaceRecoveryCodes(ctx, tx, execID, recoveryCodeHashes)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return utils.ErrorCommitingTransaction
+	}
+	return nil
+}
+
+func DisableMFADBHandler(ctx context.Context, execID int) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return utils.UnableToStartTransactionError
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE execs SET mfa_enabled = FALSE, mfa_secret = NULL, mfa_last_step = 0, token_version = token_version + 1 WHERE id = ?", execID)
+	if err != nil {
+		tx.Rollback()
+		return utils.DatabaseQueryError
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = UTC_TIMESTAMP() WHERE exec_id = ? AND revoked_at IS NULL", execID)
+	if err != nil {
+		tx.Rollback()
+		return utils.DatabaseQueryError
+	}
+	err = replaceRecoveryCodes(ctx, tx, execID, nil)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return utils.ErrorCommitingTransaction
+	}
+	return nil
+}
+
+// UseRecoveryCodeDBHandler checks the code against the unused recovery codes of the exec and burns it
+func UseRecoveryCodeDBHandler(ctx context.Context, execID int, code string) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT id, code_hash FROM mfa_recovery_codes WHERE exec_id = ? AND used_at IS NULL", execID)
+	if err != nil {
+		slog.ErrorContext(ctx, "use recovery code failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	defer rows.Close()
+
+	matchedID := 0
+	for rows.Next() {
+		var id int
+		var codeHash string
+		err = rows.Scan(&id, &codeHash)
+		if err != nil {
+			return utils.DatabaseQueryError
+		}
+		if ok, _ := utils.VerifyPassword(codeHash, code); ok {
+			matchedID = id
+			break
+		}
+	}
+	rows.Close()
+	if matchedID == 0 {
+		return utils.InvalidMFACodeError
+	}
+
+	result, err := db.ExecContext(ctx, "UPDATE mfa_recovery_codes SET used_at = UTC_TIMESTAMP() WHERE id = ? AND used_at IS NULL", matchedID)
+	if err != nil {
+		return utils.DatabaseQueryError
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return utils.DatabaseQueryError
+	}
+	if rowsAffected == 0 {
+		return utils.InvalidMFACodeError
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, execID int, codeHashes []string) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE exec_id = ?", execID)
+	if err != nil {
+		return utils.DatabaseQueryError
+	}
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO mfa_recovery_codes (exec_id, code_hash) VALUES (?, ?)")
+	if err != nil {
+		return utils.DatabaseQueryError
+	}
+	defer stmt.Close()
+	for _, codeHash := range codeHashes {
+		_, err = stmt.ExecContext(ctx, execID, codeHash)
+		if err != nil {
+			slog.ErrorContext(ctx, "replace recovery codes failed", "err", err)
+			return utils.DatabaseQueryError
+		}
+	}
+	return nil
+}
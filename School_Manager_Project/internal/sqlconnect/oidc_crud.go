@@ -0,0 +1,94 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"restapi/internal/models"
+	"restapi/utils"
+)
+
+const ssoExecColumns = "id, first_name, last_name, email, username, inactive_status, role, token_version, mfa_enabled"
+
+func scanSSOExec(row *sql.Row) (models.Exec, error) {
+	var user models.Exec
+	err := row.Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+		&user.Username,
+		&user.InactiveStatus,
+		&user.Role,
+		&user.TokenVersion,
+		&user.MfaEnabled)
+	return user, err
+}
+
+// GetExecForSSODBHandler finds the exec linked to the identity provider account. An exec
+// that isn't linked yet is matched by email and linked, nobody is created on the fly.
+func GetExecForSSODBHandler(ctx context.Context, issuer, subject, email string) (models.Exec, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return models.Exec{}, utils.ConnectingToDatabaseError
+	}
+
+	user, err := scanSSOExec(db.QueryRowContext(ctx, "SELECT "+ssoExecColumns+" FROM execs WHERE oidc_issuer = ? AND oidc_subject = ?", issuer, subject))
+	if err == nil {
+		return user, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		slog.ErrorContext(ctx, "get exec for sso failed", "err", err)
+		return models.Exec{}, utils.DatabaseQueryError
+	}
+
+	if email == "" {
+		return models.Exec{}, utils.SSOAccountNotProvisionedError
+	}
+	user, err = scanSSOExec(db.QueryRowContext(ctx, "SELECT "+ssoExecColumns+" FROM execs WHERE email = ? AND oidc_subject IS NULL", email))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Exec{}, utils.SSOAccountNotProvisionedError
+	} else if err != nil {
+		slog.ErrorContext(ctx, "get exec for sso failed", "err", err)
+		return models.Exec{}, utils.DatabaseQueryError
+	}
+	_, err = db.ExecContext(ctx, "UPDATE execs SET oidc_issuer = ?, oidc_subject = ? WHERE id = ? AND oidc_subject IS NULL", issuer, subject, user.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "get exec for sso failed", "err", err)
+		return models.Exec{}, utils.DatabaseQueryError
+	}
+	return user, nil
+}
+
+// SetExecRoleDBHandler changes the role of an exec, used when the identity provider groups
+// changed. Access tokens issued for the old role stop working, the new token version is returned
+// for the tokens of the login in progress.
+func SetExecRoleDBHandler(ctx context.Context, execID int, role string) (int, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return 0, utils.ConnectingToDatabaseError
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, utils.UnableToStartTransactionError
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE execs SET role = ?, token_version = token_version + 1 WHERE id = ?", role, execID)
+	if err != nil {
+		tx.Rollback()
+		slog.ErrorContext(ctx, "set exec role failed", "err", err)
+		return 0, utils.DatabaseQueryError
+	}
+	var tokenVersion int
+	err = tx.QueryRowContext(ctx, "SELECT token_version FROM execs WHERE id = ?", execID).Scan(&tokenVersion)
+	if err != nil {
+		tx.Rollback()
+		slog.ErrorContext(ctx, "set exec role failed", "err", err)
+		return 0, utils.DatabaseQueryError
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, utils.ErrorCommitingTransaction
+	}
+	return tokenVersion, nil
+}
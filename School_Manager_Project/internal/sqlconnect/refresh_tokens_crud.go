@@ -0,0 +1,163 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"restapi/internal/models"
+	"restapi/utils"
+	"time"
+)
+
+func CreateRefreshTokenDBHandler(ctx context.Context, execID int, familyID, tokenHash string) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	_, err = db.ExecContext(ctx, "INSERT INTO refresh_tokens (token_hash, family_id, exec_id, created_at, expires_at) VALUES (?, ?, ?, UTC_TIMESTAMP(), ?)",
+		tokenHash, familyID, execID, time.Now().UTC().Add(utils.RefreshTokenTTL()))
+	if err != nil {
+		slog.ErrorContext(ctx, "create refresh token failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	return nil
+}
+
+// RotateRefreshTokenDBHandler exchanges a refresh token for a new one of the same family.
+// Presenting a token that was already rotated revokes the whole family and its session.
+func RotateRefreshTokenDBHandler(ctx context.Context, tokenHash, newTokenHash string) (models.Exec, string, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return models.Exec{}, "", utils.ConnectingToDatabaseError
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Exec{}, "", utils.UnableToStartTransactionError
+	}
+
+	var familyID string
+	var execID int
+	var replacedBy, revokedAt sql.NullString
+	var expired bool
+	err = tx.QueryRowContext(ctx, "SELECT family_id, exec_id, replaced_by, revoked_at, expires_at <= UTC_TIMESTAMP() FROM refresh_tokens WHERE token_hash = ? FOR UPDATE", tokenHash).Scan(
+		&familyID,
+		&execID,
+		&replacedBy,
+		&revokedAt,
+		&expired)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return models.Exec{}, "", utils.InvalidRefreshTokenError
+	} else if err != nil {
+		tx.Rollback()
+		return models.Exec{}, "", utils.DatabaseQueryError
+	}
+
+	if replacedBy.Valid {
+		// the token was used before, someone else holds a copy of it
+		_, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = UTC_TIMESTAMP() WHERE family_id = ? AND revoked_at IS NULL", familyID)
+		if err != nil {
+			tx.Rollback()
+			return models.Exec{}, "", utils.DatabaseQueryError
+		}
+		// the login ends with it, like RevokeSessionDBHandler: the session and its access token too
+		_, err = tx.ExecContext(ctx, "UPDATE exec_sessions SET revoked_at = UTC_TIMESTAMP() WHERE id = ? AND exec_id = ? AND revoked_at IS NULL", familyID, execID)
+		if err != nil {
+			tx.Rollback()
+			slog.ErrorContext(ctx, "revoke session after refresh token reuse failed", "err", err)
+			return models.Exec{}, "", utils.DatabaseQueryError
+		}
+		_, err = tx.ExecContext(ctx, `INSERT IGNORE INTO revoked_tokens (jti, exec_id, expires_at)
+			SELECT token_id, exec_id, token_expires_at FROM exec_sessions
+			WHERE id = ? AND exec_id = ? AND token_id IS NOT NULL AND token_expires_at > UTC_TIMESTAMP()`, familyID, execID)
+		if err != nil {
+			tx.Rollback()
+			slog.ErrorContext(ctx, "revoke session after refresh token reuse failed", "err", err)
+			return models.Exec{}, "", utils.DatabaseQueryError
+		}
+		err = tx.Commit()
+		if err != nil {
+			return models.Exec{}, "", utils.ErrorCommitingTransaction
+		}
+		slog.WarnContext(ctx, "refresh token reuse detected, family and session revoked", "exec_id", execID, "family_id", familyID)
+		return models.Exec{}, "", utils.RefreshTokenReuseError
+	}
+	if revokedAt.Valid || expired {
+		tx.Rollback()
+		return models.Exec{}, "", utils.InvalidRefreshTokenError
+	}
+
+	var user models.Exec
+	err = tx.QueryRowContext(ctx, "SELECT id, username, inactive_status, role, token_version, mfa_enabled FROM execs WHERE id = ?", execID).Scan(
+		&user.ID,
+		&user.Username,
+		&user.InactiveStatus,
+		&user.Role,
+		&user.TokenVersion,
+		&user.MfaEnabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return models.Exec{}, "", utils.InvalidRefreshTokenError
+	} else if err != nil {
+		tx.Rollback()
+		return models.Exec{}, "", utils.DatabaseQueryError
+	}
+	if user.InactiveStatus {
+		tx.Rollback()
+		return models.Exec{}, "", utils.AccountInactiveError
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO refresh_tokens (token_hash, family_id, exec_id, created_at, expires_at) VALUES (?, ?, ?, UTC_TIMESTAMP(), ?)",
+		newTokenHash, familyID, execID, time.Now().UTC().Add(utils.RefreshTokenTTL()))
+	if err != nil {
+		tx.Rollback()
+		return models.Exec{}, "", utils.DatabaseQueryError
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET replaced_by = ? WHERE token_hash = ?", newTokenHash, tokenHash)
+	if err != nil {
+		tx.Rollback()
+		return models.Exec{}, "", utils.DatabaseQueryError
+	}
+	err = tx.Commit()
+	if err != nil {
+		return models.Exec{}, "", utils.ErrorCommitingTransaction
+	}
+	return user, familyID, nil
+}
+
+// GetTokenFamiliesDBHandler lists the families of an exec that still hold a usable refresh token
+func GetTokenFamiliesDBHandler(ctx context.Context, execID int) ([]models.TokenFamily, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return nil, utils.ConnectingToDatabaseError
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT family_id, MIN(created_at), MAX(created_at), MAX(expires_at) FROM refresh_tokens
+		WHERE exec_id = ? GROUP BY family_id
+		HAVING SUM(revoked_at IS NULL AND replaced_by IS NULL AND expires_at > UTC_TIMESTAMP()) > 0
+		ORDER BY MAX(created_at) DESC`, execID)
+	if err != nil {
+		slog.ErrorContext(ctx, "get token families failed", "err", err)
+		return nil, utils.DatabaseQueryError
+	}
+	defer rows.Close()
+
+	families := []models.TokenFamily{}
+	for rows.Next() {
+		var family models.TokenFamily
+		err = rows.Scan(&family.FamilyID, &family.CreatedAt, &family.LastRotatedAt, &family.ExpiresAt)
+		if err != nil {
+			slog.ErrorContext(ctx, "get token families failed", "err", err)
+			return nil, utils.DatabaseQueryError
+		}
+		families = append(families, family)
+	}
+	if err = rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "get token families failed", "err", err)
+		return nil, utils.DatabaseQueryError
+	}
+	return families, nil
+}
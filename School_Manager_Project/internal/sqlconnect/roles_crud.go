@@ -0,0 +1,203 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"restapi/internal/models"
+	"restapi/utils"
+	"strings"
+)
+
+func GetRolesDBHandler(ctx context.Context) ([]models.Role, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return nil, utils.ConnectingToDatabaseError
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT r.name, r.description, r.mfa_required, rp.permission FROM roles r LEFT JOIN role_permissions rp ON rp.role = r.name ORDER BY r.name")
+	if err != nil {
+		slog.ErrorContext(ctx, "get roles failed", "err", err)
+		return nil, utils.DatabaseQueryError
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		var name, description string
+		var mfaRequired bool
+		var permission sql.NullString
+		err = rows.Scan(&name, &description, &mfaRequired, &permission)
+		if err != nil {
+			slog.ErrorContext(ctx, "get roles failed", "err", err)
+			return nil, utils.DatabaseQueryError
+		}
+		if len(roles) == 0 || roles[len(roles)-1].Name != name {
+			roles = append(roles, models.Role{Name: name, Description: description, Permissions: []string{}, MfaRequired: mfaRequired})
+		}
+		if permission.Valid {
+			last := &roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, permission.String)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "get roles failed", "err", err)
+		return nil, utils.DatabaseQueryError
+	}
+	return roles, nil
+}
+
+func AddRoleDBHandler(ctx context.Context, role models.Role) (models.Role, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return models.Role{}, utils.ConnectingToDatabaseError
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Role{}, utils.UnableToStartTransactionError
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO roles (name, description) VALUES (?, ?)", role.Name, role.Description)
+	if err != nil {
+		tx.Rollback()
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			return models.Role{}, utils.DuplicateRoleError
+		}
+		return models.Role{}, utils.DatabaseQueryError
+	}
+	err = insertRolePermissions(ctx, tx, role.Name, role.Permissions)
+	if err != nil {
+		tx.Rollback()
+		return models.Role{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return models.Role{}, utils.ErrorCommitingTransaction
+	}
+	return role, nil
+}
+
+// UpdateRoleDBHandler replaces the description and the whole permission set of a role
+func UpdateRoleDBHandler(ctx context.Context, name string, role models.Role) (models.Role, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return models.Role{}, utils.ConnectingToDatabaseError
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Role{}, utils.UnableToStartTransactionError
+	}
+	var existing string
+	err = tx.QueryRowContext(ctx, "SELECT name FROM roles WHERE name = ?", name).Scan(&existing)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return models.Role{}, utils.UnitNotFoundError
+	} else if err != nil {
+		tx.Rollback()
+		return models.Role{}, utils.DatabaseQueryError
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE roles SET description = ? WHERE name = ?", role.Description, name)
+	if err != nil {
+		tx.Rollback()
+		return models.Role{}, utils.DatabaseQueryError
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM role_permissions WHERE role = ?", name)
+	if err != nil {
+		tx.Rollback()
+		return models.Role{}, utils.DatabaseQueryError
+	}
+	err = insertRolePermissions(ctx, tx, name, role.Permissions)
+	if err != nil {
+		tx.Rollback()
+		return models.Role{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return models.Role{}, utils.ErrorCommitingTransaction
+	}
+	role.Name = name
+	return role, nil
+}
+
+func SetRoleMFARequiredDBHandler(ctx context.Context, name string, required bool) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	var existing string
+	err = db.QueryRowContext(ctx, "SELECT name FROM roles WHERE name = ?", name).Scan(&existing)
+	if errors.Is(err, sql.ErrNoRows) {
+		return utils.UnitNotFoundError
+	} else if err != nil {
+		return utils.DatabaseQueryError
+	}
+	_, err = db.ExecContext(ctx, "UPDATE roles SET mfa_required = ? WHERE name = ?", required, name)
+	if err != nil {
+		return utils.DatabaseQueryError
+	}
+	return nil
+}
+
+func DeleteRoleDBHandler(ctx context.Context, name string) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	var execCount int
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM execs WHERE role = ?", name).Scan(&execCount)
+	if err != nil {
+		return utils.DatabaseQueryError
+	}
+	if execCount > 0 {
+		return utils.RoleInUseError
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return utils.UnableToStartTransactionError
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM role_permissions WHERE role = ?", name)
+	if err != nil {
+		tx.Rollback()
+		return utils.DatabaseQueryError
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM roles WHERE name = ?", name)
+	if err != nil {
+		tx.Rollback()
+		return utils.DatabaseQueryError
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return utils.DatabaseQueryError
+	}
+	if rowsAffected == 0 {
+		tx.Rollback()
+		return utils.UnitNotFoundError
+	}
+	err = tx.Commit()
+	if err != nil {
+		return utils.ErrorCommitingTransaction
+	}
+	return nil
+}
+
+func insertRolePermissions(ctx context.Context, tx *sql.Tx, role string, permissions []string) error {
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO role_permissions (role, permission) VALUES (?, ?)")
+	if err != nil {
+		return utils.DatabaseQueryError
+	}
+	defer stmt.Close()
+	for _, permission := range permissions {
+		_, err = stmt.ExecContext(ctx, role, permission)
+		if err != nil {
+			slog.ErrorContext(ctx, "insert role permissions failed", "err", err)
+			return utils.DatabaseQueryError
+		}
+	}
+	return nil
+}
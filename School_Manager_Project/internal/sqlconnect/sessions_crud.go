@@ -0,0 +1,142 @@
+package sqlconnect
+
+import (
+	"context"
+	"log/slog"
+	"restapi/internal/models"
+	"restapi/utils"
+	"time"
+)
+
+// CreateSessionDBHandler records a login and reports whether it came from a device the exec
+// hasn't used before. Without a device cookie the user agent and IP have to match an earlier
+// login. The very first login of an exec doesn't count as a new device.
+func CreateSessionDBHandler(ctx context.Context, session models.Session, deviceHash string, hasDeviceCookie bool, tokenID string, tokenExpiresAt time.Time) (bool, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return false, utils.ConnectingToDatabaseError
+	}
+
+	var previous, known bool
+	err = db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM exec_sessions WHERE exec_id = ?),
+		EXISTS(SELECT 1 FROM exec_sessions WHERE exec_id = ? AND (device_hash = ? OR (? AND user_agent = ? AND ip = ?)))`,
+		session.ExecID, session.ExecID, deviceHash, !hasDeviceCookie, session.UserAgent, session.IP).Scan(&previous, &known)
+	if err != nil {
+		slog.ErrorContext(ctx, "create session failed", "err", err)
+		return false, utils.DatabaseQueryError
+	}
+
+	_, err = db.ExecContext(ctx, `INSERT INTO exec_sessions (id, exec_id, ip, user_agent, device_hash, token_id, token_expires_at, created_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP())`,
+		session.ID, session.ExecID, session.IP, session.UserAgent, deviceHash, tokenID, tokenExpiresAt.UTC().Format(dbTimeFormat))
+	if err != nil {
+		slog.ErrorContext(ctx, "create session failed", "err", err)
+		return false, utils.DatabaseQueryError
+	}
+	return previous && !known, nil
+}
+
+// TouchSessionDBHandler updates the session when its access token is refreshed
+func TouchSessionDBHandler(ctx context.Context, sessionID, ip, tokenID string, tokenExpiresAt time.Time) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	_, err = db.ExecContext(ctx, "UPDATE exec_sessions SET last_seen_at = UTC_TIMESTAMP(), ip = ?, token_id = ?, token_expires_at = ? WHERE id = ?",
+		ip, tokenID, tokenExpiresAt.UTC().Format(dbTimeFormat), sessionID)
+	if err != nil {
+		slog.ErrorContext(ctx, "touch session failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	return nil
+}
+
+// GetSessionsDBHandler lists the latest logins of the exec, active ones first
+func GetSessionsDBHandler(ctx context.Context, execID int) ([]models.Session, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return nil, utils.ConnectingToDatabaseError
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT s.id, s.exec_id, s.ip, s.user_agent, s.created_at, s.last_seen_at,
+		s.revoked_at IS NULL AND EXISTS(SELECT 1 FROM refresh_tokens t WHERE t.family_id = s.id AND t.revoked_at IS NULL
+			AND t.replaced_by IS NULL AND t.expires_at > UTC_TIMESTAMP()) AS active
+		FROM exec_sessions s WHERE s.exec_id = ? ORDER BY active DESC, s.last_seen_at DESC LIMIT 100`, execID)
+	if err != nil {
+		slog.ErrorContext(ctx, "get sessions failed", "err", err)
+		return nil, utils.DatabaseQueryError
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		err = rows.Scan(&session.ID, &session.ExecID, &session.IP, &session.UserAgent, &session.CreatedAt, &session.LastSeenAt, &session.Active)
+		if err != nil {
+			slog.ErrorContext(ctx, "get sessions failed", "err", err)
+			return nil, utils.DatabaseQueryError
+		}
+		sessions = append(sessions, session)
+	}
+	if err = rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "get sessions failed", "err", err)
+		return nil, utils.DatabaseQueryError
+	}
+	return sessions, nil
+}
+
+// RevokeSessionDBHandler logs one session out: its refresh tokens are revoked and its current
+// access token is denied, so it ends right away instead of when the token expires. The session
+// is the refresh token family of the login, families from before sessions were recorded have
+// no row in exec_sessions and only lose their refresh tokens.
+func RevokeSessionDBHandler(ctx context.Context, execID int, sessionID string) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return utils.UnableToStartTransactionError
+	}
+	result, err := tx.ExecContext(ctx, "UPDATE exec_sessions SET revoked_at = UTC_TIMESTAMP() WHERE id = ? AND exec_id = ? AND revoked_at IS NULL", sessionID, execID)
+	if err != nil {
+		tx.Rollback()
+		slog.ErrorContext(ctx, "revoke session failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	sessionsRevoked, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return utils.DatabaseQueryError
+	}
+	result, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = UTC_TIMESTAMP() WHERE exec_id = ? AND family_id = ? AND revoked_at IS NULL", execID, sessionID)
+	if err != nil {
+		tx.Rollback()
+		slog.ErrorContext(ctx, "revoke session failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	tokensRevoked, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return utils.DatabaseQueryError
+	}
+	if sessionsRevoked == 0 && tokensRevoked == 0 {
+		tx.Rollback()
+		return utils.UnitNotFoundError
+	}
+	_, err = tx.ExecContext(ctx, `INSERT IGNORE INTO revoked_tokens (jti, exec_id, expires_at)
+		SELECT token_id, exec_id, token_expires_at FROM exec_sessions
+		WHERE id = ? AND exec_id = ? AND token_id IS NOT NULL AND token_expires_at > UTC_TIMESTAMP()`, sessionID, execID)
+	if err != nil {
+		tx.Rollback()
+		slog.ErrorContext(ctx, "revoke session failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	err = tx.Commit()
+	if err != nil {
+		return utils.ErrorCommitingTransaction
+	}
+	return nil
+}
@@ -2,19 +2,61 @@ package sqlconnect
 
 import (
 	"database/sql"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
 	"os"
 	"restapi/utils"
+	"sync"
+	"time"
 )
 
+var pool struct {
+	sync.Mutex
+	db *sql.DB
+}
+
+// ConnectDb returns the connection pool every query shares. It is opened and pinged on the
+// first call, a failed attempt is retried on the next one. Callers must not close it.
 func ConnectDb() (*sql.DB, error) {
-	connectionString := os.Getenv("CONNECTION_STRING")
-	db, err := sql.Open("mysql", connectionString)
+	pool.Lock()
+	defer pool.Unlock()
+	if pool.db != nil {
+		return pool.db, nil
+	}
+
+	cfg, err := mysql.ParseDSN(os.Getenv("CONNECTION_STRING"))
+	if err != nil {
+		return nil, utils.ConnectingToDatabaseError
+	}
+	connector, err := mysql.NewConnector(cfg)
 	if err != nil {
 		return nil, utils.ConnectingToDatabaseError
 	}
+	// every statement gets a span, see tracing.go
+	db := sql.OpenDB(tracedConnector{connector})
 	if err = db.Ping(); err != nil {
+		db.Close()
 		return nil, utils.ConnectingToDatabaseError
 	}
+	// MySQL drops idle connections after wait_timeout, recycle them well before that
+	db.SetConnMaxLifetime(3 * time.Minute)
+	db.SetMaxIdleConns(10)
+	pool.db = db
 	return db, nil
 }
+
+// connectDbx is ConnectDb for the queries that scan with sqlx
+func connectDbx() (*sqlx.DB, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return nil, err
+	}
+	return sqlx.NewDb(db, "mysql"), nil
+}
+
+// Pool returns the shared pool, nil until the first query opened it
+func Pool() *sql.DB {
+	pool.Lock()
+	defer pool.Unlock()
+	return pool.db
+}
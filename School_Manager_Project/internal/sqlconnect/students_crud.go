@@ -1,30 +1,30 @@
 package sqlconnect
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"github.com/jmoiron/sqlx"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
 	"reflect"
 	"restapi/internal/models"
+	"restapi/internal/policy"
 	"restapi/utils"
 	"strconv"
 	"strings"
 )
 
-func GetStudentByID(realID int) (models.Student, error) {
+func GetStudentByID(ctx context.Context, subject policy.Subject, realID int) (models.Student, error) {
 	db, err := ConnectDb()
 	if err != nil {
 		return models.Student{}, utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
 
+	scope, scopeArgs := policy.Scope(subject, policy.Students)
 	var student models.Student
-	err = db.QueryRow(
-		"SELECT id, first_name, last_name, email, class FROM students WHERE id = ?",
-		realID,
+	err = db.QueryRowContext(ctx,
+		"SELECT id, first_name, last_name, email, class FROM students WHERE id = ?"+scope,
+		append([]interface{}{realID}, scopeArgs...)...,
 	).Scan(&student.ID,
 		&student.FirstName,
 		&student.LastName,
@@ -73,49 +73,52 @@ func GetStudentByID(realID int) (models.Student, error) {
 //}
 
 func GetStudentsDBHandler(studentList []models.Student, r *http.Request) ([]models.Student, error) {
+	ctx := r.Context()
 	query := "SELECT id, first_name, last_name, email, class FROM students WHERE 1=1"
 	var args []interface{}
 
-	query, args = utils.AddSearchFilters(r, query, args)
-	query, err := utils.AddSortFilters(r, query)
+	query, args = utils.AddSearchFilters(r, query, args, models.StudentFilterFields)
+	// only the rows the logged-in user is allowed to see
+	scope, scopeArgs := policy.Scope(policy.SubjectFromContext(r.Context()), policy.Students)
+	query += scope
+	args = append(args, scopeArgs...)
+	query, err := utils.AddSortFilters(r, query, models.StudentFilterFields)
 	if err != nil {
 		return nil, err
 	}
 
-	db, err := sqlx.Connect("mysql", os.Getenv("CONNECTION_STRING"))
+	db, err := connectDbx()
 	if err != nil {
 		return nil, utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
 
 	var students []models.Student
-	err = db.Select(&students, query, args...)
+	err = db.SelectContext(ctx, &students, query, args...)
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(ctx, "get students failed", "err", err)
 		return nil, utils.DatabaseQueryError
 	}
 
 	return students, nil
 }
 
-func AddStudentsDBHandler(newStudents []models.Student) ([]models.Student, error) {
+func AddStudentsDBHandler(ctx context.Context, newStudents []models.Student) ([]models.Student, error) {
 	db, err := ConnectDb()
 	if err != nil {
 		return nil, utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
-	stmt, err := db.Prepare("INSERT INTO students (first_name, last_name, email, class) VALUES (?, ?, ?, ?)") // will prepare SQL for execution
+	stmt, err := db.PrepareContext(ctx, "INSERT INTO students (first_name, last_name, email, class) VALUES (?, ?, ?, ?)") // will prepare SQL for execution
 	if err != nil {
-		log.Println("ERR 1:", err)
+		slog.ErrorContext(ctx, "add students failed", "err", err)
 		return nil, utils.DatabaseQueryError
 	}
 	defer stmt.Close()
 
 	addedStudents := make([]models.Student, len(newStudents))
 	for i, student := range newStudents {
-		res, err := stmt.Exec(student.FirstName, student.LastName, student.Email, student.Class)
+		res, err := stmt.ExecContext(ctx, student.FirstName, student.LastName, student.Email, student.Class)
 		if err != nil {
-			log.Println("ERR 2:", err)
+			slog.ErrorContext(ctx, "add students failed", "err", err)
 			if strings.Contains(err.Error(), "Duplicate entry") {
 				return nil, utils.DuplicateEmailError
 			} else if strings.Contains(err.Error(), "a foreign key constraint fails") {
@@ -125,7 +128,7 @@ func AddStudentsDBHandler(newStudents []models.Student) ([]models.Student, error
 		}
 		lastID, err := res.LastInsertId()
 		if err != nil {
-			log.Println("ERR 3:", err)
+			slog.ErrorContext(ctx, "add students failed", "err", err)
 			return nil, utils.DatabaseQueryError
 		}
 		student.ID = int(lastID)
@@ -134,25 +137,33 @@ func AddStudentsDBHandler(newStudents []models.Student) ([]models.Student, error
 	return addedStudents, nil
 }
 
-func UpdateStudentDBHandler(id int, updatedStudent models.Student) (models.Student, error) {
+func UpdateStudentDBHandler(ctx context.Context, subject policy.Subject, id int, updatedStudent models.Student) (models.Student, error) {
 	db, err := ConnectDb()
 	if err != nil {
 		return models.Student{}, utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
 
+	scope, scopeArgs := policy.Scope(subject, policy.Students)
 	var existingStudent models.Student
-	err = db.QueryRow("SELECT id, first_name, last_name, email, class FROM students WHERE id = ?", id).Scan(
+	err = db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class FROM students WHERE id = ?"+scope, append([]interface{}{id}, scopeArgs...)...).Scan(
 		&existingStudent.ID,
 		&existingStudent.FirstName,
 		&existingStudent.LastName,
 		&existingStudent.Email,
 		&existingStudent.Class)
-	if err != nil {
+	if err == sql.ErrNoRows {
+		return models.Student{}, utils.UnitNotFoundError
+	} else if err != nil {
 		return models.Student{}, utils.DatabaseQueryError
 	}
+	if updatedStudent.Class != existingStudent.Class {
+		err = policy.CheckFields(subject, policy.Students, map[string]interface{}{"class": updatedStudent.Class})
+		if err != nil {
+			return models.Student{}, err
+		}
+	}
 	updatedStudent.ID = existingStudent.ID
-	_, err = db.Exec("UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?",
+	_, err = db.ExecContext(ctx, "UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?",
 		updatedStudent.FirstName,
 		updatedStudent.LastName,
 		updatedStudent.Email,
@@ -164,14 +175,13 @@ func UpdateStudentDBHandler(id int, updatedStudent models.Student) (models.Stude
 	return updatedStudent, nil
 }
 
-func PatchStudentsDBHandler(updates []map[string]interface{}) error {
+func PatchStudentsDBHandler(ctx context.Context, subject policy.Subject, updates []map[string]interface{}) error {
 	db, err := ConnectDb()
 	if err != nil {
 		return utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return utils.UnableToStartTransactionError
 	}
@@ -186,8 +196,14 @@ func PatchStudentsDBHandler(updates []map[string]interface{}) error {
 			tx.Rollback()
 			return utils.InvalidIdError
 		}
+		err = policy.CheckFields(subject, policy.Students, update)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		scope, scopeArgs := policy.Scope(subject, policy.Students)
 		var studentFromDb models.Student
-		err = db.QueryRow("SELECT id, first_name, last_name, email, class FROM students WHERE id = ?", id).Scan(
+		err = db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class FROM students WHERE id = ?"+scope, append([]interface{}{id}, scopeArgs...)...).Scan(
 			&studentFromDb.ID,
 			&studentFromDb.FirstName,
 			&studentFromDb.LastName,
@@ -217,7 +233,7 @@ func PatchStudentsDBHandler(updates []map[string]interface{}) error {
 							fieldVal.Set(val.Convert(fieldVal.Type()))
 						} else {
 							tx.Rollback()
-							log.Printf("cannot convert %v to %v", val.Type(), fieldVal.Type())
+							slog.WarnContext(ctx, "cannot convert patch value", "from", val.Type().String(), "to", fieldVal.Type().String())
 							return utils.InvalidUpdateParametersError
 						}
 					}
@@ -225,7 +241,7 @@ func PatchStudentsDBHandler(updates []map[string]interface{}) error {
 				}
 			}
 		}
-		_, err = tx.Exec("UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?",
+		_, err = tx.ExecContext(ctx, "UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?",
 			studentFromDb.FirstName,
 			studentFromDb.LastName,
 			studentFromDb.Email,
@@ -244,15 +260,19 @@ func PatchStudentsDBHandler(updates []map[string]interface{}) error {
 	return nil
 }
 
-func PatchOneStudentDBHandler(id int, updates map[string]interface{}) (models.Student, error) {
+func PatchOneStudentDBHandler(ctx context.Context, subject policy.Subject, id int, updates map[string]interface{}) (models.Student, error) {
+	err := policy.CheckFields(subject, policy.Students, updates)
+	if err != nil {
+		return models.Student{}, err
+	}
 	db, err := ConnectDb()
 	if err != nil {
 		return models.Student{}, utils.DatabaseQueryError
 	}
-	defer db.Close()
 
+	scope, scopeArgs := policy.Scope(subject, policy.Students)
 	var existingStudent models.Student
-	err = db.QueryRow("SELECT id, first_name, last_name, email, class FROM students WHERE id = ?", id).Scan(
+	err = db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class FROM students WHERE id = ?"+scope, append([]interface{}{id}, scopeArgs...)...).Scan(
 		&existingStudent.ID,
 		&existingStudent.FirstName,
 		&existingStudent.LastName,
@@ -280,7 +300,7 @@ func PatchOneStudentDBHandler(id int, updates map[string]interface{}) (models.St
 		}
 	}
 
-	_, err = db.Exec("UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?",
+	_, err = db.ExecContext(ctx, "UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?",
 		existingStudent.FirstName,
 		existingStudent.LastName,
 		existingStudent.Email,
@@ -292,14 +312,13 @@ func PatchOneStudentDBHandler(id int, updates map[string]interface{}) (models.St
 	return existingStudent, nil
 }
 
-func DeleteOneStudentDBHandler(id int) error {
+func DeleteOneStudentDBHandler(ctx context.Context, id int) error {
 	db, err := ConnectDb()
 	if err != nil {
 		return utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
 
-	result, err := db.Exec("DELETE FROM students WHERE id = ?", id)
+	result, err := db.ExecContext(ctx, "DELETE FROM students WHERE id = ?", id)
 	if err != nil {
 		return utils.DatabaseQueryError
 	}
@@ -313,28 +332,26 @@ func DeleteOneStudentDBHandler(id int) error {
 	return nil
 }
 
-func DeleteStudentsDBHandler(ids []int) ([]int, error) {
+func DeleteStudentsDBHandler(ctx context.Context, ids []int) ([]int, error) {
 	db, err := ConnectDb()
 	if err != nil {
 		return nil, utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, utils.UnableToStartTransactionError
 	}
 
-	stm, err := tx.Prepare("DELETE FROM students WHERE id = ?")
+	stm, err := tx.PrepareContext(ctx, "DELETE FROM students WHERE id = ?")
 	if err != nil {
 		tx.Rollback()
 		return nil, utils.DatabaseQueryError
 	}
-	defer db.Close()
 
 	deletedIds := []int{}
 
 	for _, id := range ids {
-		res, err := stm.Exec(id)
+		res, err := stm.ExecContext(ctx, id)
 		if err != nil {
 			return nil, utils.DatabaseQueryError
 		}
@@ -1,28 +1,27 @@
 package sqlconnect
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"github.com/jmoiron/sqlx"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
 	"reflect"
 	"restapi/internal/models"
+	"restapi/internal/policy"
 	"restapi/utils"
 	"strconv"
 )
 
-func GetTeacherByID(realID int) (models.Teacher, error) {
+func GetTeacherByID(ctx context.Context, realID int) (models.Teacher, error) {
 	db, err := ConnectDb()
 	if err != nil {
 		return models.Teacher{}, utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
 
 	var teacher models.Teacher
-	err = db.QueryRow(
+	err = db.QueryRowContext(ctx,
 		"SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ?",
 		realID,
 	).Scan(&teacher.ID,
@@ -74,38 +73,37 @@ func GetTeacherByID(realID int) (models.Teacher, error) {
 //}
 
 func GetTeachersDBHandler(r *http.Request) ([]models.Teacher, error) {
+	ctx := r.Context()
 	query := "SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE 1=1"
 	var args []interface{}
 
-	query, args = utils.AddSearchFilters(r, query, args)
-	query, err := utils.AddSortFilters(r, query)
+	query, args = utils.AddSearchFilters(r, query, args, models.TeacherFilterFields)
+	query, err := utils.AddSortFilters(r, query, models.TeacherFilterFields)
 	if err != nil {
 		return nil, err
 	}
 
-	db, err := sqlx.Connect("mysql", os.Getenv("CONNECTION_STRING"))
+	db, err := connectDbx()
 	if err != nil {
 		return nil, utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
 
 	var teachers []models.Teacher
-	err = db.Select(&teachers, query, args...)
+	err = db.SelectContext(ctx, &teachers, query, args...)
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(ctx, "get teachers failed", "err", err)
 		return nil, utils.DatabaseQueryError
 	}
 
 	return teachers, nil
 }
 
-func AddTeachersDBHandler(newTeachers []models.Teacher) ([]models.Teacher, error) {
+func AddTeachersDBHandler(ctx context.Context, newTeachers []models.Teacher) ([]models.Teacher, error) {
 	db, err := ConnectDb()
 	if err != nil {
 		return nil, utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
-	stmt, err := db.Prepare("INSERT INTO teachers (first_name, last_name, email, class, subject) VALUES (?, ?, ?, ?, ?)") // will prepare SQL for execution
+	stmt, err := db.PrepareContext(ctx, "INSERT INTO teachers (first_name, last_name, email, class, subject) VALUES (?, ?, ?, ?, ?)") // will prepare SQL for execution
 	if err != nil {
 		return nil, utils.DatabaseQueryError
 	}
@@ -113,7 +111,7 @@ func AddTeachersDBHandler(newTeachers []models.Teacher) ([]models.Teacher, error
 
 	addedTeachers := make([]models.Teacher, len(newTeachers))
 	for i, teacher := range newTeachers {
-		res, err := stmt.Exec(teacher.FirstName, teacher.LastName, teacher.Email, teacher.Class, teacher.Subject)
+		res, err := stmt.ExecContext(ctx, teacher.FirstName, teacher.LastName, teacher.Email, teacher.Class, teacher.Subject)
 		if err != nil {
 			return nil, utils.DatabaseQueryError
 		}
@@ -127,15 +125,14 @@ func AddTeachersDBHandler(newTeachers []models.Teacher) ([]models.Teacher, error
 	return addedTeachers, nil
 }
 
-func UpdateTeacherDBHandler(id int, updatedTeacher models.Teacher) (models.Teacher, error) {
+func UpdateTeacherDBHandler(ctx context.Context, id int, updatedTeacher models.Teacher) (models.Teacher, error) {
 	db, err := ConnectDb()
 	if err != nil {
 		return models.Teacher{}, utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
 
 	var existingTeacher models.Teacher
-	err = db.QueryRow("SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ?", id).Scan(
+	err = db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ?", id).Scan(
 		&existingTeacher.ID,
 		&existingTeacher.FirstName,
 		&existingTeacher.LastName,
@@ -146,7 +143,7 @@ func UpdateTeacherDBHandler(id int, updatedTeacher models.Teacher) (models.Teach
 		return models.Teacher{}, utils.DatabaseQueryError
 	}
 	updatedTeacher.ID = existingTeacher.ID
-	_, err = db.Exec("UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?",
+	_, err = db.ExecContext(ctx, "UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?",
 		updatedTeacher.FirstName,
 		updatedTeacher.LastName,
 		updatedTeacher.Email,
@@ -159,14 +156,13 @@ func UpdateTeacherDBHandler(id int, updatedTeacher models.Teacher) (models.Teach
 	return updatedTeacher, nil
 }
 
-func PatchTeachersDBHandler(updates []map[string]interface{}) error {
+func PatchTeachersDBHandler(ctx context.Context, updates []map[string]interface{}) error {
 	db, err := ConnectDb()
 	if err != nil {
 		return utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return utils.UnableToStartTransactionError
 	}
@@ -182,7 +178,7 @@ func PatchTeachersDBHandler(updates []map[string]interface{}) error {
 			return utils.InvalidIdError
 		}
 		var teacherFromDb models.Teacher
-		err = db.QueryRow("SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ?", id).Scan(
+		err = db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ?", id).Scan(
 			&teacherFromDb.ID,
 			&teacherFromDb.FirstName,
 			&teacherFromDb.LastName,
@@ -213,7 +209,7 @@ func PatchTeachersDBHandler(updates []map[string]interface{}) error {
 							fieldVal.Set(val.Convert(fieldVal.Type()))
 						} else {
 							tx.Rollback()
-							log.Printf("cannot convert %v to %v", val.Type(), fieldVal.Type())
+							slog.WarnContext(ctx, "cannot convert patch value", "from", val.Type().String(), "to", fieldVal.Type().String())
 							return utils.InvalidUpdateParametersError
 						}
 					}
@@ -221,7 +217,7 @@ func PatchTeachersDBHandler(updates []map[string]interface{}) error {
 				}
 			}
 		}
-		_, err = tx.Exec("UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?",
+		_, err = tx.ExecContext(ctx, "UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?",
 			teacherFromDb.FirstName,
 			teacherFromDb.LastName,
 			teacherFromDb.Email,
@@ -241,15 +237,14 @@ func PatchTeachersDBHandler(updates []map[string]interface{}) error {
 	return nil
 }
 
-func PatchOneTeacherDBHandler(id int, updates map[string]interface{}) (models.Teacher, error) {
+func PatchOneTeacherDBHandler(ctx context.Context, id int, updates map[string]interface{}) (models.Teacher, error) {
 	db, err := ConnectDb()
 	if err != nil {
 		return models.Teacher{}, utils.DatabaseQueryError
 	}
-	defer db.Close()
 
 	var existingTeacher models.Teacher
-	err = db.QueryRow("SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ?", id).Scan(
+	err = db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ?", id).Scan(
 		&existingTeacher.ID,
 		&existingTeacher.FirstName,
 		&existingTeacher.LastName,
@@ -278,7 +273,7 @@ func PatchOneTeacherDBHandler(id int, updates map[string]interface{}) (models.Te
 		}
 	}
 
-	_, err = db.Exec("UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?",
+	_, err = db.ExecContext(ctx, "UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?",
 		existingTeacher.FirstName,
 		existingTeacher.LastName,
 		existingTeacher.Email,
@@ -291,14 +286,13 @@ func PatchOneTeacherDBHandler(id int, updates map[string]interface{}) (models.Te
 	return existingTeacher, nil
 }
 
-func DeleteOneTeacherDBHandler(id int) error {
+func DeleteOneTeacherDBHandler(ctx context.Context, id int) error {
 	db, err := ConnectDb()
 	if err != nil {
 		return utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
 
-	result, err := db.Exec("DELETE FROM teachers WHERE id = ?", id)
+	result, err := db.ExecContext(ctx, "DELETE FROM teachers WHERE id = ?", id)
 	if err != nil {
 		return utils.DatabaseQueryError
 	}
@@ -312,28 +306,26 @@ func DeleteOneTeacherDBHandler(id int) error {
 	return nil
 }
 
-func DeleteTeachersDBHandler(ids []int) ([]int, error) {
+func DeleteTeachersDBHandler(ctx context.Context, ids []int) ([]int, error) {
 	db, err := ConnectDb()
 	if err != nil {
 		return nil, utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, utils.UnableToStartTransactionError
 	}
 
-	stm, err := tx.Prepare("DELETE FROM teachers WHERE id = ?")
+	stm, err := tx.PrepareContext(ctx, "DELETE FROM teachers WHERE id = ?")
 	if err != nil {
 		tx.Rollback()
 		return nil, utils.DatabaseQueryError
 	}
-	defer db.Close()
 
 	deletedIds := []int{}
 
 	for _, id := range ids {
-		res, err := stm.Exec(id)
+		res, err := stm.ExecContext(ctx, id)
 		if err != nil {
 			return nil, utils.DatabaseQueryError
 		}
@@ -363,16 +355,15 @@ func DeleteTeachersDBHandler(ids []int) ([]int, error) {
 	return deletedIds, nil
 }
 
-func GetStudentsListForTeacherDBHandler(id int) ([]models.Student, error) {
+func GetStudentsListForTeacherDBHandler(ctx context.Context, subject policy.Subject, id int) ([]models.Student, error) {
 	db, err := ConnectDb()
 	if err != nil {
 		return nil, utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
 
 	var studentsList []models.Student
 	var class string
-	err = db.QueryRow("SELECT class FROM teachers WHERE id = ?", id).Scan(&class)
+	err = db.QueryRowContext(ctx, "SELECT class FROM teachers WHERE id = ?", id).Scan(&class)
 
 	if err == sql.ErrNoRows {
 		return nil, utils.UnitNotFoundError
@@ -380,9 +371,10 @@ func GetStudentsListForTeacherDBHandler(id int) ([]models.Student, error) {
 		return nil, utils.DatabaseQueryError
 	}
 
-	rows, err := db.Query("SELECT id, first_name, last_name, email, class FROM students WHERE class = ?", class)
+	scope, scopeArgs := policy.Scope(subject, policy.Students)
+	rows, err := db.QueryContext(ctx, "SELECT id, first_name, last_name, email, class FROM students WHERE class = ?"+scope, append([]interface{}{class}, scopeArgs...)...)
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(ctx, "get students list for teacher failed", "err", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -395,28 +387,27 @@ func GetStudentsListForTeacherDBHandler(id int) ([]models.Student, error) {
 			&student.Email,
 			&student.Class)
 		if err != nil {
-			log.Println(err)
+			slog.ErrorContext(ctx, "get students list for teacher failed", "err", err)
 			return nil, err
 		}
 		studentsList = append(studentsList, student)
 	}
 	err = rows.Err()
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(ctx, "get students list for teacher failed", "err", err)
 		return nil, err
 	}
 	return studentsList, nil
 }
 
-func GetStudentCountForTeacherDBHandler(id int) (int, error) {
+func GetStudentCountForTeacherDBHandler(ctx context.Context, id int) (int, error) {
 	db, err := ConnectDb()
 	if err != nil {
 		return 0, utils.ConnectingToDatabaseError
 	}
-	defer db.Close()
 
 	var class string
-	err = db.QueryRow("SELECT class FROM teachers WHERE id = ?", id).Scan(&class)
+	err = db.QueryRowContext(ctx, "SELECT class FROM teachers WHERE id = ?", id).Scan(&class)
 	if errors.Is(err, sql.ErrNoRows) {
 		return 0, utils.UnitNotFoundError
 	} else if err != nil {
@@ -424,7 +415,7 @@ func GetStudentCountForTeacherDBHandler(id int) (int, error) {
 	}
 
 	var count int
-	err = db.QueryRow("SELECT COUNT(*) FROM students WHERE class = ?", class).Scan(&count)
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM students WHERE class = ?", class).Scan(&count)
 	if errors.Is(err, sql.ErrNoRows) {
 		return 0, utils.UnitNotFoundError
 	} else if err != nil {
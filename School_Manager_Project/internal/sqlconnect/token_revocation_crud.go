@@ -0,0 +1,94 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"restapi/utils"
+	"time"
+)
+
+// GetTokenStateDBHandler returns the current token version of the exec and whether the token was
+// revoked, on its own by jti or with its login: the session was ended or a refresh token of the
+// family revoked. Rotated tokens are only marked replaced, so any revoked one means the family is.
+func GetTokenStateDBHandler(ctx context.Context, execID int, jti, familyID string) (int, bool, error) {
+	db, err := ConnectDb()
+	if err != nil {
+		return 0, false, utils.ConnectingToDatabaseError
+	}
+
+	var tokenVersion int
+	var revoked bool
+	err = db.QueryRowContext(ctx, `SELECT token_version,
+		EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = ?)
+		OR EXISTS(SELECT 1 FROM exec_sessions WHERE id = ? AND revoked_at IS NOT NULL)
+		OR EXISTS(SELECT 1 FROM refresh_tokens WHERE family_id = ? AND revoked_at IS NOT NULL)
+		FROM execs WHERE id = ?`, jti, familyID, familyID, execID).Scan(
+		&tokenVersion,
+		&revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, utils.InvalidLoginTokenError
+	} else if err != nil {
+		slog.ErrorContext(ctx, "get token state failed", "err", err)
+		return 0, false, utils.DatabaseQueryError
+	}
+	return tokenVersion, revoked, nil
+}
+
+// RevokeAccessTokenDBHandler puts the jti on the denylist until the token would have expired anyway
+func RevokeAccessTokenDBHandler(ctx context.Context, execID int, jti string, expiresAt time.Time) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	_, err = db.ExecContext(ctx, "INSERT IGNORE INTO revoked_tokens (jti, exec_id, expires_at) VALUES (?, ?, ?)", jti, execID, expiresAt.UTC())
+	if err != nil {
+		slog.ErrorContext(ctx, "revoke access token failed", "err", err)
+		return utils.DatabaseQueryError
+	}
+	// entries of expired tokens are not needed anymore
+	_, err = db.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at < UTC_TIMESTAMP()")
+	if err != nil {
+		slog.ErrorContext(ctx, "revoke access token failed", "err", err)
+	}
+	return nil
+}
+
+// RevokeAllSessionsDBHandler invalidates every access and refresh token of the exec
+func RevokeAllSessionsDBHandler(ctx context.Context, execID int) error {
+	db, err := ConnectDb()
+	if err != nil {
+		return utils.ConnectingToDatabaseError
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return utils.UnableToStartTransactionError
+	}
+	result, err := tx.ExecContext(ctx, "UPDATE execs SET token_version = token_version + 1 WHERE id = ?", execID)
+	if err != nil {
+		tx.Rollback()
+		return utils.DatabaseQueryError
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return utils.DatabaseQueryError
+	}
+	if rowsAffected == 0 {
+		tx.Rollback()
+		return utils.UnitNotFoundError
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = UTC_TIMESTAMP() WHERE exec_id = ? AND revoked_at IS NULL", execID)
+	if err != nil {
+		tx.Rollback()
+		return utils.DatabaseQueryError
+	}
+	err = tx.Commit()
+	if err != nil {
+		return utils.ErrorCommitingTransaction
+	}
+	return nil
+}
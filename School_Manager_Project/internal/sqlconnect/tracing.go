@@ -0,0 +1,338 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql/driver"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"reflect"
+	"restapi/internal/tracing"
+	"strings"
+	"time"
+)
+
+// The mysql driver is wrapped so that every statement run through the shared pool gets a
+// span, named after its operation and table ("SELECT students"), with the query text and the
+// number of rows returned or affected. Arguments are never recorded.
+
+// statementName is the operation and the table of a query, like "UPDATE students"
+func statementName(query string) (operation, table string) {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "SQL", ""
+	}
+	operation = strings.ToUpper(fields[0])
+	var before string
+	switch operation {
+	case "SELECT", "DELETE":
+		before = "FROM"
+	case "INSERT", "REPLACE":
+		before = "INTO"
+	case "UPDATE":
+		if len(fields) > 1 {
+			table = fields[1]
+		}
+	}
+	if before != "" {
+		for i := 1; i < len(fields)-1; i++ {
+			if strings.EqualFold(fields[i], before) {
+				table = fields[i+1]
+				break
+			}
+		}
+	}
+	table = strings.Trim(table, "`(),;")
+	return operation, table
+}
+
+// startQuerySpan starts the span of a statement that began at start
+func startQuerySpan(ctx context.Context, query string, start time.Time) trace.Span {
+	operation, table := statementName(query)
+	name := operation
+	attrs := []attribute.KeyValue{semconv.DBSystemMySQL, semconv.DBOperationName(operation), semconv.DBQueryText(query)}
+	if table != "" {
+		name += " " + table
+		attrs = append(attrs, semconv.DBCollectionName(table))
+	}
+	_, span := tracing.Tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(start),
+		trace.WithAttributes(attrs...),
+	)
+	return span
+}
+
+func endQuerySpan(span trace.Span, err error) {
+	if err != nil && err != io.EOF {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func endExecSpan(span trace.Span, result driver.Result, err error) {
+	if err == nil {
+		if affected, err := result.RowsAffected(); err == nil {
+			span.SetAttributes(attribute.Int64("db.rows_affected", affected))
+		}
+	}
+	endQuerySpan(span, err)
+}
+
+type tracedConnector struct {
+	driver.Connector
+}
+
+func (c tracedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{Conn: conn}, nil
+}
+
+// tracedConn passes everything the mysql connection can do on to it. Statements with
+// arguments come back with driver.ErrSkip and are run again prepared, they get their span
+// from tracedStmt.
+type tracedConn struct {
+	driver.Conn
+}
+
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tracedStmt{Stmt: stmt, query: query}, nil
+}
+
+func (c *tracedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var tx driver.Tx
+	var err error
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = beginner.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tracedTx{Tx: tx, ctx: ctx}, nil
+}
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	if err == driver.ErrSkip {
+		return nil, err
+	}
+	endExecSpan(startQuerySpan(ctx, query, start), result, err)
+	return result, err
+}
+
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err == driver.ErrSkip {
+		return nil, err
+	}
+	span := startQuerySpan(ctx, query, start)
+	if err != nil {
+		endQuerySpan(span, err)
+		return nil, err
+	}
+	return &tracedRows{Rows: rows, span: span}, nil
+}
+
+func (c *tracedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// CheckNamedValue keeps the argument conversions of the mysql driver
+func (c *tracedConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
+
+type tracedStmt struct {
+	driver.Stmt
+	query string
+}
+
+func (s *tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	span := startQuerySpan(ctx, s.query, time.Now())
+	var result driver.Result
+	var err error
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		result, err = execer.ExecContext(ctx, args)
+	} else {
+		result, err = s.Stmt.Exec(namedToValues(args))
+	}
+	endExecSpan(span, result, err)
+	return result, err
+}
+
+func (s *tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	span := startQuerySpan(ctx, s.query, time.Now())
+	var rows driver.Rows
+	var err error
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Stmt.Query(namedToValues(args))
+	}
+	if err != nil {
+		endQuerySpan(span, err)
+		return nil, err
+	}
+	return &tracedRows{Rows: rows, span: span}, nil
+}
+
+func (s *tracedStmt) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
+
+func namedToValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
+
+// tracedRows ends the span of its query once the rows are closed, so reading them counts
+type tracedRows struct {
+	driver.Rows
+	span  trace.Span
+	count int64
+}
+
+func (r *tracedRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err == nil {
+		r.count++
+	} else if err != io.EOF {
+		r.span.RecordError(err)
+		r.span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+func (r *tracedRows) Close() error {
+	err := r.Rows.Close()
+	r.span.SetAttributes(attribute.Int64("db.response.returned_rows", r.count))
+	endQuerySpan(r.span, err)
+	return err
+}
+
+func (r *tracedRows) HasNextResultSet() bool {
+	if next, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return next.HasNextResultSet()
+	}
+	return false
+}
+
+func (r *tracedRows) NextResultSet() error {
+	if next, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return next.NextResultSet()
+	}
+	return io.EOF
+}
+
+func (r *tracedRows) ColumnTypeScanType(index int) reflect.Type {
+	if columns, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return columns.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(any)).Elem()
+}
+
+func (r *tracedRows) ColumnTypeDatabaseTypeName(index int) string {
+	if columns, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return columns.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r *tracedRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if columns, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return columns.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func (r *tracedRows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if columns, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return columns.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+func (r *tracedRows) ColumnTypeLength(index int) (length int64, ok bool) {
+	if columns, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return columns.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+// tracedTx times commits and rollbacks, under the span that began the transaction
+type tracedTx struct {
+	driver.Tx
+	ctx context.Context
+}
+
+func (tx *tracedTx) Commit() error {
+	span := startQuerySpan(tx.ctx, "COMMIT", time.Now())
+	err := tx.Tx.Commit()
+	endQuerySpan(span, err)
+	return err
+}
+
+func (tx *tracedTx) Rollback() error {
+	span := startQuerySpan(tx.ctx, "ROLLBACK", time.Now())
+	err := tx.Tx.Rollback()
+	endQuerySpan(span, err)
+	return err
+}
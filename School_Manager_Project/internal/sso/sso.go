@@ -0,0 +1,241 @@
+package sso
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"log/slog"
+	"os"
+	"restapi/utils"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FlowTTL is how long a user has to finish the login at the identity provider
+const FlowTTL = 10 * time.Minute
+
+// Config of the OpenID Connect relying party, read from the OIDC_* environment variables
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// GroupsClaim is the ID token claim listing the groups of the user
+	GroupsClaim string
+	// GroupRoles maps identity provider groups to roles, the first group the user is in wins
+	GroupRoles []GroupRole
+	// AllowUnverifiedEmail is for providers that never send email_verified
+	AllowUnverifiedEmail bool
+}
+
+type GroupRole struct {
+	Group string
+	Role  string
+}
+
+// LoadConfig returns false when OIDC_ISSUER is not set and single sign-on is disabled
+func LoadConfig() (Config, bool) {
+	cfg := Config{
+		Issuer:               strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/"),
+		ClientID:             os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:         os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:          os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:               []string{oidc.ScopeOpenID, "email", "profile"},
+		GroupsClaim:          os.Getenv("OIDC_GROUPS_CLAIM"),
+		AllowUnverifiedEmail: os.Getenv("OIDC_ALLOW_UNVERIFIED_EMAIL") == "true",
+	}
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return Config{}, false
+	}
+	if scopes := strings.Fields(os.Getenv("OIDC_SCOPES")); len(scopes) > 0 {
+		cfg.Scopes = scopes
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	// OIDC_GROUP_ROLES=school-admins=admin,staff=exec,teachers=teacher
+	for _, pair := range strings.Split(os.Getenv("OIDC_GROUP_ROLES"), ",") {
+		group, role, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if found && group != "" && role != "" {
+			cfg.GroupRoles = append(cfg.GroupRoles, GroupRole{Group: group, Role: role})
+		}
+	}
+	return cfg, true
+}
+
+var (
+	providerMu sync.Mutex
+	providers  = map[string]*oidc.Provider{}
+)
+
+// provider fetches the discovery document once, failed attempts are retried on the next login
+func provider(ctx context.Context, issuer string) (*oidc.Provider, error) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	if p, ok := providers[issuer]; ok {
+		return p, nil
+	}
+	p, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		slog.ErrorContext(ctx, "oidc discovery failed", "issuer", issuer, "err", err)
+		return nil, utils.SSOProviderError
+	}
+	providers[issuer] = p
+	return p, nil
+}
+
+func (cfg Config) oauth2Config(p *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Endpoint:     p.Endpoint(),
+		Scopes:       cfg.Scopes,
+	}
+}
+
+// Flow is what the callback needs to finish the login, kept in an encrypted cookie
+type Flow struct {
+	State      string `json:"state"`
+	Nonce      string `json:"nonce"`
+	Verifier   string `json:"verifier"`
+	RedirectTo string `json:"redirect_to,omitempty"`
+	ExpiresAt  int64  `json:"expires_at"`
+}
+
+// Begin starts an authorization code flow with PKCE and returns the URL to send the user to
+func Begin(ctx context.Context, cfg Config, redirectTo string) (string, Flow, error) {
+	p, err := provider(ctx, cfg.Issuer)
+	if err != nil {
+		return "", Flow{}, err
+	}
+	state, err := utils.GenerateRandomToken(24)
+	if err != nil {
+		return "", Flow{}, err
+	}
+	nonce, err := utils.GenerateRandomToken(24)
+	if err != nil {
+		return "", Flow{}, err
+	}
+	flow := Flow{
+		State:      state,
+		Nonce:      nonce,
+		Verifier:   oauth2.GenerateVerifier(),
+		RedirectTo: redirectTo,
+		ExpiresAt:  time.Now().Add(FlowTTL).Unix(),
+	}
+	authURL := cfg.oauth2Config(p).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(flow.Verifier))
+	return authURL, flow, nil
+}
+
+func EncodeFlow(flow Flow) (string, error) {
+	data, err := json.Marshal(flow)
+	if err != nil {
+		return "", utils.ErrorEncryptingSecret
+	}
+	return utils.EncryptSecret(string(data))
+}
+
+func DecodeFlow(encoded string) (Flow, error) {
+	data, err := utils.DecryptSecret(encoded)
+	if err != nil {
+		return Flow{}, utils.InvalidSSOResponseError
+	}
+	var flow Flow
+	err = json.Unmarshal([]byte(data), &flow)
+	if err != nil || time.Now().Unix() > flow.ExpiresAt {
+		return Flow{}, utils.InvalidSSOResponseError
+	}
+	return flow, nil
+}
+
+// Identity is what the identity provider tells us about the user
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Groups        []string
+	// MFA is set when the provider reports a multi-factor login in the amr claim
+	MFA bool
+}
+
+// Finish exchanges the code for tokens and verifies the ID token against the flow
+func Finish(ctx context.Context, cfg Config, flow Flow, state, code string) (Identity, error) {
+	if state == "" || code == "" || subtle.ConstantTimeCompare([]byte(state), []byte(flow.State)) != 1 {
+		return Identity{}, utils.InvalidSSOResponseError
+	}
+	p, err := provider(ctx, cfg.Issuer)
+	if err != nil {
+		return Identity{}, err
+	}
+	token, err := cfg.oauth2Config(p).Exchange(ctx, code, oauth2.VerifierOption(flow.Verifier))
+	if err != nil {
+		slog.WarnContext(ctx, "oidc code exchange failed", "err", err)
+		return Identity{}, utils.InvalidSSOResponseError
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, utils.InvalidSSOResponseError
+	}
+	idToken, err := p.Verifier(&oidc.Config{ClientID: cfg.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		slog.WarnContext(ctx, "oidc id token rejected", "err", err)
+		return Identity{}, utils.InvalidSSOResponseError
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(flow.Nonce)) != 1 {
+		return Identity{}, utils.InvalidSSOResponseError
+	}
+
+	var claims map[string]interface{}
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return Identity{}, utils.InvalidSSOResponseError
+	}
+	identity := Identity{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+		Groups:  stringList(claims[cfg.GroupsClaim]),
+	}
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	for _, method := range stringList(claims["amr"]) {
+		if method == "mfa" {
+			identity.MFA = true
+		}
+	}
+	return identity, nil
+}
+
+// RoleForGroups returns the role of the first configured group the user is in
+func RoleForGroups(cfg Config, groups []string) (string, bool) {
+	for _, mapping := range cfg.GroupRoles {
+		for _, group := range groups {
+			if group == mapping.Group {
+				return mapping.Role, true
+			}
+		}
+	}
+	return "", false
+}
+
+// stringList reads a claim that is either a list of strings or a single string
+func stringList(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		list := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// mockIdP is an identity provider serving discovery, its keys and a token endpoint that
+// hands out the ID token built by idToken for the single code it accepts
+type mockIdP struct {
+	server  *httptest.Server
+	key     *rsa.PrivateKey
+	code    string
+	idToken func(issuer string) jwt.MapClaims
+	// verifier is the PKCE verifier the last token request proved
+	verifier string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{key: key, code: "good-code"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != idp.code {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		idp.verifier = r.PostFormValue("code_verifier")
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, idp.idToken(idp.server.URL))
+		token.Header["kid"] = "test"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   300,
+			"id_token":     signed,
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func TestFinish(t *testing.T) {
+	idp := newMockIdP(t)
+	cfg := Config{
+		Issuer:      idp.server.URL,
+		ClientID:    "school-api",
+		RedirectURL: "https://school.example/auth/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+		GroupsClaim: "groups",
+	}
+	ctx := context.Background()
+	_, flow, err := Begin(ctx, cfg, "/dashboard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := func(issuer string) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            issuer,
+			"sub":            "user-1",
+			"aud":            cfg.ClientID,
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          flow.Nonce,
+			"email":          "head@school.example",
+			"email_verified": true,
+			"groups":         []string{"staff", "school-admins"},
+			"amr":            []string{"pwd", "mfa"},
+		}
+	}
+
+	t.Run("valid login", func(t *testing.T) {
+		idp.idToken = claims
+		identity, err := Finish(ctx, cfg, flow, flow.State, idp.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Identity{
+			Issuer:        idp.server.URL,
+			Subject:       "user-1",
+			Email:         "head@school.example",
+			EmailVerified: true,
+			Groups:        []string{"staff", "school-admins"},
+			MFA:           true,
+		}
+		if !reflect.DeepEqual(identity, want) {
+			t.Fatalf("Finish() = %+v, want %+v", identity, want)
+		}
+		if idp.verifier != flow.Verifier {
+			t.Errorf("token request sent verifier %q, want the one of the flow", idp.verifier)
+		}
+	})
+
+	rejected := []struct {
+		name    string
+		state   string
+		code    string
+		idToken func(issuer string) jwt.MapClaims
+	}{
+		{name: "wrong state", state: "other", code: idp.code, idToken: claims},
+		{name: "missing state", state: "", code: idp.code, idToken: claims},
+		{name: "code refused by the provider", state: flow.State, code: "bad-code", idToken: claims},
+		{
+			name: "wrong nonce", state: flow.State, code: idp.code,
+			idToken: func(issuer string) jwt.MapClaims {
+				c := claims(issuer)
+				c["nonce"] = "replayed"
+				return c
+			},
+		},
+		{
+			name: "other audience", state: flow.State, code: idp.code,
+			idToken: func(issuer string) jwt.MapClaims {
+				c := claims(issuer)
+				c["aud"] = "another-app"
+				return c
+			},
+		},
+		{
+			name: "expired ID token", state: flow.State, code: idp.code,
+			idToken: func(issuer string) jwt.MapClaims {
+				c := claims(issuer)
+				c["exp"] = time.Now().Add(-time.Hour).Unix()
+				return c
+			},
+		},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			idp.idToken = tt.idToken
+			_, err := Finish(ctx, cfg, flow, tt.state, tt.code)
+			if err == nil {
+				t.Fatal("Finish() accepted the login")
+			}
+		})
+	}
+}
+
+func TestRoleForGroups(t *testing.T) {
+	cfg := Config{GroupRoles: []GroupRole{
+		{Group: "school-admins", Role: "admin"},
+		{Group: "staff", Role: "exec"},
+	}}
+	tests := []struct {
+		name   string
+		groups []string
+		want   string
+		found  bool
+	}{
+		{name: "first configured group wins", groups: []string{"staff", "school-admins"}, want: "admin", found: true},
+		{name: "single group", groups: []string{"staff"}, want: "exec", found: true},
+		{name: "no mapped group", groups: []string{"parents"}, found: false},
+		{name: "no groups", groups: nil, found: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role, found := RoleForGroups(cfg, tt.groups)
+			if role != tt.want || found != tt.found {
+				t.Errorf("RoleForGroups() = %q, %v, want %q, %v", role, found, tt.want, tt.found)
+			}
+		})
+	}
+}
+
+func TestStringList(t *testing.T) {
+	tests := []struct {
+		name  string
+		claim interface{}
+		want  []string
+	}{
+		{name: "single string", claim: "staff", want: []string{"staff"}},
+		{name: "list", claim: []interface{}{"staff", "teachers"}, want: []string{"staff", "teachers"}},
+		{name: "non strings skipped", claim: []interface{}{"staff", 42.0, nil}, want: []string{"staff"}},
+		{name: "empty list", claim: []interface{}{}, want: []string{}},
+		{name: "missing claim", claim: nil, want: nil},
+		{name: "other type", claim: true, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := stringList(tt.claim)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stringList(%v) = %#v, want %#v", tt.claim, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"strings"
+)
+
+const serviceName = "school-manager-api"
+
+// Tracer starts the spans of the API, a no-op one until Setup installed an exporter
+func Tracer() trace.Tracer {
+	return otel.Tracer("restapi")
+}
+
+// Setup exports spans with exporter "otlp" (OTLP over HTTP, configured by the standard
+// OTEL_EXPORTER_OTLP_* variables), "stdout", "file" (JSON lines appended to file, default
+// traces.json) or "none" (default). W3C traceparent is propagated either way, so the trace
+// ID of a caller still reaches the logs. The returned function flushes the spans left.
+func Setup(ctx context.Context, exporter, file string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(exporter) {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		spanExporter, err = otlptracehttp.New(ctx)
+	case "stdout":
+		spanExporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "file":
+		if file == "" {
+			file = "traces.json"
+		}
+		var f *os.File
+		f, err = os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err == nil {
+			spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+		}
+	default:
+		return nil, fmt.Errorf("invalid traces exporter %q, use otlp, stdout, file or none", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating %s traces exporter: %w", exporter, err)
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("describing the service: %w", err)
+	}
+	// the sampler follows OTEL_TRACES_SAMPLER, by default every request a caller didn't
+	// decide against
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,36 @@
+-- roles and the permissions granted to them, managed through /roles/
+CREATE TABLE IF NOT EXISTS roles (
+    name        VARCHAR(50) PRIMARY KEY,
+    description VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role       VARCHAR(50) NOT NULL,
+    permission VARCHAR(100) NOT NULL,
+    PRIMARY KEY (role, permission),
+    FOREIGN KEY (role) REFERENCES roles (name) ON DELETE CASCADE
+);
+
+INSERT IGNORE INTO roles (name, description) VALUES
+    ('admin', 'full access'),
+    ('manager', 'manages students, teachers and reads execs'),
+    ('exec', 'manages students and teachers'),
+    ('teacher', 'reads and updates students of their class'),
+    ('guardian', 'reads linked students'),
+    ('student', 'reads teachers');
+
+INSERT IGNORE INTO role_permissions (role, permission) VALUES
+    ('admin', '*'),
+    ('manager', 'students:*'),
+    ('manager', 'teachers:*'),
+    ('manager', 'execs:read'),
+    ('manager', 'reports:read'),
+    ('manager', 'roles:read'),
+    ('exec', 'students:*'),
+    ('exec', 'teachers:*'),
+    ('exec', 'reports:read'),
+    ('teacher', 'students:read'),
+    ('teacher', 'students:write'),
+    ('teacher', 'teachers:read'),
+    ('guardian', 'students:read'),
+    ('student', 'teachers:read');
@@ -0,0 +1,8 @@
+-- links guardian exec accounts to the students they may see
+CREATE TABLE IF NOT EXISTS guardian_students (
+    guardian_id INT NOT NULL,
+    student_id  INT NOT NULL,
+    PRIMARY KEY (guardian_id, student_id),
+    FOREIGN KEY (guardian_id) REFERENCES execs (id) ON DELETE CASCADE,
+    FOREIGN KEY (student_id) REFERENCES students (id) ON DELETE CASCADE
+);
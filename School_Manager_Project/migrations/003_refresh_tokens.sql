@@ -0,0 +1,14 @@
+-- refresh tokens, stored as sha256 hashes. Every login starts a new family,
+-- every refresh adds a token to it and marks the previous one as replaced.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    token_hash  CHAR(64) PRIMARY KEY,
+    family_id   VARCHAR(32) NOT NULL,
+    exec_id     INT NOT NULL,
+    created_at  DATETIME NOT NULL,
+    expires_at  DATETIME NOT NULL,
+    replaced_by CHAR(64) NULL,
+    revoked_at  DATETIME NULL,
+    INDEX idx_refresh_tokens_family (family_id),
+    INDEX idx_refresh_tokens_exec (exec_id),
+    FOREIGN KEY (exec_id) REFERENCES execs (id) ON DELETE CASCADE
+);
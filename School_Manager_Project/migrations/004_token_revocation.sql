@@ -0,0 +1,10 @@
+-- bumping token_version invalidates every access token issued before
+ALTER TABLE execs ADD COLUMN IF NOT EXISTS token_version INT NOT NULL DEFAULT 0;
+
+-- access tokens revoked before their expiry (logout)
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti        VARCHAR(32) PRIMARY KEY,
+    exec_id    INT NOT NULL,
+    expires_at DATETIME NOT NULL,
+    INDEX idx_revoked_tokens_expires (expires_at)
+);
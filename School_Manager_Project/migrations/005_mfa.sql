@@ -0,0 +1,17 @@
+-- TOTP two-factor authentication, mfa_secret is encrypted with MFA_ENCRYPTION_KEY
+ALTER TABLE execs
+    ADD COLUMN IF NOT EXISTS mfa_enabled   BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS mfa_secret    VARCHAR(255) NULL,
+    ADD COLUMN IF NOT EXISTS mfa_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id        INT AUTO_INCREMENT PRIMARY KEY,
+    exec_id   INT NOT NULL,
+    code_hash VARCHAR(255) NOT NULL,
+    used_at   DATETIME NULL,
+    FOREIGN KEY (exec_id) REFERENCES execs (id) ON DELETE CASCADE
+);
+
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS mfa_required BOOLEAN NOT NULL DEFAULT FALSE;
+
+INSERT IGNORE INTO role_permissions (role, permission) VALUES ('manager', 'mfa:policy');
@@ -0,0 +1,23 @@
+-- failed logins are counted per username (known or not, so a lockout reveals nothing) and per client IP
+CREATE TABLE IF NOT EXISTS login_throttle (
+    scope          VARCHAR(16)  NOT NULL,
+    subject        VARCHAR(255) NOT NULL,
+    failures       INT          NOT NULL DEFAULT 0,
+    last_failed_at DATETIME     NOT NULL,
+    locked_until   DATETIME     NULL,
+    PRIMARY KEY (scope, subject),
+    INDEX idx_login_throttle_last_failed (last_failed_at)
+);
+
+CREATE TABLE IF NOT EXISTS lockout_events (
+    id           INT AUTO_INCREMENT PRIMARY KEY,
+    scope        VARCHAR(16)  NOT NULL,
+    subject      VARCHAR(255) NOT NULL,
+    event        VARCHAR(16)  NOT NULL,
+    ip           VARCHAR(64)  NOT NULL DEFAULT '',
+    failures     INT          NOT NULL DEFAULT 0,
+    locked_until DATETIME     NULL,
+    actor_id     INT          NULL,
+    created_at   DATETIME     NOT NULL,
+    INDEX idx_lockout_events_created (created_at)
+);
@@ -0,0 +1,9 @@
+-- previous password hashes of each exec, PASSWORD_HISTORY of them can't be used again
+CREATE TABLE IF NOT EXISTS password_history (
+    id            INT AUTO_INCREMENT PRIMARY KEY,
+    exec_id       INT          NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at    DATETIME     NOT NULL,
+    INDEX idx_password_history_exec (exec_id, id),
+    FOREIGN KEY (exec_id) REFERENCES execs (id) ON DELETE CASCADE
+);
@@ -0,0 +1,13 @@
+-- signing keys of the access tokens, private keys are encrypted with MFA_ENCRYPTION_KEY.
+-- a key signs between activates_at and retires_at and is still accepted until expires_at
+CREATE TABLE IF NOT EXISTS jwt_keys (
+    kid          VARCHAR(32) PRIMARY KEY,
+    alg          VARCHAR(16) NOT NULL,
+    private_key  TEXT        NOT NULL,
+    public_key   TEXT        NULL,
+    activates_at DATETIME    NOT NULL,
+    retires_at   DATETIME    NOT NULL,
+    expires_at   DATETIME    NOT NULL,
+    created_at   DATETIME    NOT NULL,
+    INDEX idx_jwt_keys_expires (expires_at)
+);
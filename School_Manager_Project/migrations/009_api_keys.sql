@@ -0,0 +1,16 @@
+-- keys for machine-to-machine integrations, sent as "Authorization: Bearer smk_<prefix>_<secret>".
+-- only the sha256 of the whole key is stored, the prefix finds the row
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           INT AUTO_INCREMENT PRIMARY KEY,
+    name         VARCHAR(255) NOT NULL,
+    prefix       VARCHAR(16)  NOT NULL UNIQUE,
+    key_hash     VARCHAR(64)  NOT NULL,
+    scopes       TEXT         NOT NULL,
+    allowed_ips  TEXT         NULL,
+    created_by   INT          NULL,
+    created_at   DATETIME     NOT NULL,
+    expires_at   DATETIME     NULL,
+    last_used_at DATETIME     NULL,
+    last_used_ip VARCHAR(64)  NULL,
+    revoked_at   DATETIME     NULL
+);
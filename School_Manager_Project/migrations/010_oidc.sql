@@ -0,0 +1,5 @@
+-- single sign-on: the identity provider account an exec is linked to after the first login
+ALTER TABLE execs
+    ADD COLUMN IF NOT EXISTS oidc_issuer  VARCHAR(255) NULL,
+    ADD COLUMN IF NOT EXISTS oidc_subject VARCHAR(255) NULL,
+    ADD UNIQUE INDEX IF NOT EXISTS idx_execs_oidc (oidc_issuer, oidc_subject);
@@ -0,0 +1,16 @@
+-- invited execs stay inactive without a creation date until they accept and pick a password
+ALTER TABLE execs
+    MODIFY user_created_at TIMESTAMP NULL DEFAULT NULL;
+
+-- one invite per exec, resending replaces the token so older links stop working
+CREATE TABLE IF NOT EXISTS exec_invites (
+    exec_id     INT          NOT NULL PRIMARY KEY,
+    token_hash  CHAR(64)     NOT NULL,
+    created_by  INT          NULL,
+    created_at  DATETIME     NOT NULL,
+    expires_at  DATETIME     NOT NULL,
+    sent_count  INT          NOT NULL DEFAULT 1,
+    accepted_at DATETIME     NULL,
+    revoked_at  DATETIME     NULL,
+    FOREIGN KEY (exec_id) REFERENCES execs(id) ON DELETE CASCADE
+);
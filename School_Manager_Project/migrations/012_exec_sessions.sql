@@ -0,0 +1,18 @@
+-- one row per login, the id is the refresh token family the login started
+CREATE TABLE IF NOT EXISTS exec_sessions (
+    id               VARCHAR(32)  NOT NULL PRIMARY KEY,
+    exec_id          INT          NOT NULL,
+    ip               VARCHAR(64)  NOT NULL DEFAULT '',
+    user_agent       VARCHAR(512) NOT NULL DEFAULT '',
+    -- sha256 of the device_id cookie, used to spot logins from new devices
+    device_hash      CHAR(64)     NOT NULL,
+    -- jti and expiry of the latest access token, denied when the session is revoked
+    token_id         VARCHAR(32)  NULL,
+    token_expires_at DATETIME     NULL,
+    created_at       DATETIME     NOT NULL,
+    last_seen_at     DATETIME     NOT NULL,
+    revoked_at       DATETIME     NULL,
+    INDEX idx_exec_sessions_exec (exec_id, last_seen_at),
+    INDEX idx_exec_sessions_device (exec_id, device_hash),
+    FOREIGN KEY (exec_id) REFERENCES execs (id) ON DELETE CASCADE
+);
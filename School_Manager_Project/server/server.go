@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"fmt"
 	"github.com/joho/godotenv"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"restapi/internal/api/middlewares"
 	"restapi/internal/api/router"
+	"restapi/internal/logging"
+	"restapi/internal/tracing"
+	"restapi/utils"
 )
 
 func main() {
@@ -17,15 +20,40 @@ func main() {
 		return
 	}
 
+	// LOG_FORMAT is text or json, LOG_LEVEL debug, info, warn or error
+	err = logging.Setup(os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		slog.Error("Error configuring the logger", "err", err)
+		os.Exit(1)
+	}
+
+	// OTEL_TRACES_EXPORTER is otlp, stdout, file (OTEL_TRACES_FILE) or none
+	shutdownTracing, err := tracing.Setup(context.Background(), os.Getenv("OTEL_TRACES_EXPORTER"), os.Getenv("OTEL_TRACES_FILE"))
+	if err != nil {
+		slog.Error("Error configuring tracing", "err", err)
+		os.Exit(1)
+	}
+
+	// MFA_ENCRYPTION_KEY encrypts TOTP seeds and private keys and signs MFA challenges and invites
+	err = utils.CheckSecrets()
+	if err != nil {
+		slog.Error("Error checking the secrets", "err", err)
+		os.Exit(1)
+	}
+
 	port := os.Getenv("API_PORT")
 
 	cert := "cert.pem"
 	key := "key.pem"
 
-	mux := router.Router()
-	//jwtRouter := middlewares.JWTMiddleware(mux)
-	jwtMiddleware := middlewares.MiddlewaresExcludePaths(middlewares.JWTMiddleware, "/execs/login")
-	handler := jwtMiddleware(mux)
+	// middlewares, their options and the chains of the route groups come from MIDDLEWARE_CONFIG
+	pipeline, err := middlewares.LoadPipeline(os.Getenv("MIDDLEWARE_CONFIG"))
+	if err != nil {
+		slog.Error("Error loading the middleware config", "err", err)
+		os.Exit(1)
+	}
+	mux := router.Router(pipeline)
+	handler := pipeline.Global(mux)
 	tlfConfig := &tls.Config{
 		MinVersion: tls.VersionTLS12,
 	}
@@ -36,9 +64,11 @@ func main() {
 		TLSConfig: tlfConfig,
 	}
 
-	fmt.Println("Server is running on port:", port)
+	slog.Info("Server is running", "port", port)
 	err2 := server.ListenAndServeTLS(cert, key)
 	if err2 != nil {
-		log.Fatal("Error starting the server:", err2)
+		slog.Error("Error starting the server", "err", err2)
+		shutdownTracing(context.Background())
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP returns the address of the client without the port, as resolved by the client_ip
+// middleware behind trusted proxies, or the address of the peer when it didn't run
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value("clientIp").(string); ok {
+		return ip
+	}
+	return peerIP(r)
+}
+
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Headers proxies report the client address in, see ResolveClientIP
+const (
+	ForwardedHeader     = "Forwarded"
+	XForwardedForHeader = "X-Forwarded-For"
+	XRealIPHeader       = "X-Real-Ip"
+)
+
+// ParseTrustedProxies turns addresses and ranges into networks, a single address is a
+// network of one
+func ParseTrustedProxies(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", value)
+			}
+			if ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func trustedProxy(ip net.IP, trusted []*net.IPNet) bool {
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveClientIP returns the client address of a request that may have passed through
+// proxies. The header is only believed when the peer is a trusted proxy, no other header is
+// looked at. The hops it lists are walked from the nearest: trusted proxies are skipped and
+// the first other address is the client. A hop that can't be parsed ("unknown", obfuscated
+// identifiers) ends the walk at the last address seen.
+func ResolveClientIP(r *http.Request, trusted []*net.IPNet, header string) string {
+	peer := peerIP(r)
+	peerAddr := net.ParseIP(peer)
+	if peerAddr == nil || !trustedProxy(peerAddr, trusted) {
+		return peer
+	}
+	client := peerAddr
+	hops := forwardedHops(r, header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHop(hops[i])
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !trustedProxy(ip, trusted) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// forwardedHops lists the addresses of a header from the client to the nearest proxy,
+// repeated headers are read in order as one list
+func forwardedHops(r *http.Request, header string) []string {
+	var hops []string
+	values := r.Header.Values(header)
+	switch http.CanonicalHeaderKey(header) {
+	case ForwardedHeader:
+		// Forwarded: for=192.0.2.60;proto=https;by=203.0.113.43, for="[2001:db8::17]:4711"
+		for _, value := range values {
+			for _, element := range strings.Split(value, ",") {
+				hop := ""
+				for _, pair := range strings.Split(element, ";") {
+					key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+					if found && strings.EqualFold(key, "for") {
+						hop = strings.Trim(val, `"`)
+					}
+				}
+				hops = append(hops, hop)
+			}
+		}
+	case XRealIPHeader:
+		// a single address set by the nearest proxy
+		if len(values) > 0 {
+			hops = append(hops, values[len(values)-1])
+		}
+	default:
+		for _, value := range values {
+			hops = append(hops, strings.Split(value, ",")...)
+		}
+	}
+	return hops
+}
+
+// parseHop accepts an address with or without port, IPv6 optionally in brackets
+func parseHop(hop string) net.IP {
+	hop = strings.TrimSpace(hop)
+	if ip := net.ParseIP(hop); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+}
+
+// ValidIPOrCIDR accepts a single address like 10.0.0.7 or a range like 10.0.0.0/24
+func ValidIPOrCIDR(value string) bool {
+	if strings.Contains(value, "/") {
+		_, _, err := net.ParseCIDR(value)
+		return err == nil
+	}
+	return net.ParseIP(value) != nil
+}
+
+// IPAllowed reports whether ip is one of the addresses or ranges of the allowlist,
+// an empty allowlist allows every address
+func IPAllowed(ip string, allowlist []string) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, allowed := range allowlist {
+		if strings.Contains(allowed, "/") {
+			_, network, err := net.ParseCIDR(allowed)
+			if err == nil && network.Contains(parsed) {
+				return true
+			}
+		} else if allowedIP := net.ParseIP(allowed); allowedIP != nil && allowedIP.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
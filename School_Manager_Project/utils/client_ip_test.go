@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "2001:db8:ffff::/48"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		peer    string
+		header  string
+		headers map[string][]string
+		want    string
+	}{
+		{
+			name:    "untrusted peer, headers ignored",
+			peer:    "203.0.113.9:5000",
+			header:  XForwardedForHeader,
+			headers: map[string][]string{XForwardedForHeader: {"198.51.100.1"}},
+			want:    "203.0.113.9",
+		},
+		{
+			name:   "trusted peer without header",
+			peer:   "10.0.0.2:5000",
+			header: XForwardedForHeader,
+			want:   "10.0.0.2",
+		},
+		{
+			name:    "appended X-Forwarded-For, spoofed first hop",
+			peer:    "10.0.0.2:5000",
+			header:  XForwardedForHeader,
+			headers: map[string][]string{XForwardedForHeader: {"127.0.0.1, 203.0.113.9"}},
+			want:    "203.0.113.9",
+		},
+		{
+			name:   "spoofed Forwarded is ignored when X-Forwarded-For is configured",
+			peer:   "10.0.0.2:5000",
+			header: XForwardedForHeader,
+			headers: map[string][]string{
+				ForwardedHeader:     {"for=127.0.0.1"},
+				XForwardedForHeader: {"203.0.113.9"},
+			},
+			want: "203.0.113.9",
+		},
+		{
+			name:    "spoofed X-Real-IP doesn't replace a missing X-Forwarded-For",
+			peer:    "10.0.0.2:5000",
+			header:  XForwardedForHeader,
+			headers: map[string][]string{XRealIPHeader: {"127.0.0.1"}},
+			want:    "10.0.0.2",
+		},
+		{
+			name:    "chain of trusted hops",
+			peer:    "10.0.0.2:5000",
+			header:  XForwardedForHeader,
+			headers: map[string][]string{XForwardedForHeader: {"198.51.100.1, 203.0.113.9, 10.1.1.1", "10.2.2.2"}},
+			want:    "203.0.113.9",
+		},
+		{
+			name:    "every hop trusted",
+			peer:    "10.0.0.2:5000",
+			header:  XForwardedForHeader,
+			headers: map[string][]string{XForwardedForHeader: {"10.1.1.1, 10.2.2.2"}},
+			want:    "10.1.1.1",
+		},
+		{
+			name:    "unparsable hop ends the walk",
+			peer:    "10.0.0.2:5000",
+			header:  XForwardedForHeader,
+			headers: map[string][]string{XForwardedForHeader: {"198.51.100.1, unknown, 10.1.1.1"}},
+			want:    "10.1.1.1",
+		},
+		{
+			name:    "Forwarded IPv6 with brackets and port",
+			peer:    "10.0.0.2:5000",
+			header:  ForwardedHeader,
+			headers: map[string][]string{ForwardedHeader: {`for="[2001:db8::17]:4711";proto=https`}},
+			want:    "2001:db8::17",
+		},
+		{
+			name:    "Forwarded IPv6 with brackets and no port",
+			peer:    "10.0.0.2:5000",
+			header:  ForwardedHeader,
+			headers: map[string][]string{ForwardedHeader: {`for="[2001:db8::17]"`}},
+			want:    "2001:db8::17",
+		},
+		{
+			name:    "Forwarded chain behind a trusted IPv6 proxy",
+			peer:    "[2001:db8:ffff::1]:443",
+			header:  ForwardedHeader,
+			headers: map[string][]string{ForwardedHeader: {`for=192.0.2.60:8080;by=203.0.113.43, for="[2001:db8:ffff::5]"`}},
+			want:    "192.0.2.60",
+		},
+		{
+			name:    "X-Real-IP takes the last value",
+			peer:    "10.0.0.2:5000",
+			header:  XRealIPHeader,
+			headers: map[string][]string{XRealIPHeader: {"127.0.0.1", "203.0.113.9"}},
+			want:    "203.0.113.9",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.peer
+			for name, values := range tt.headers {
+				for _, value := range values {
+					r.Header.Add(name, value)
+				}
+			}
+			got := ResolveClientIP(r, trusted, tt.header)
+			if got != tt.want {
+				t.Errorf("ResolveClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	networks, err := ParseTrustedProxies([]string{" 10.0.0.1 ", "", "::1", "192.168.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 3 {
+		t.Fatalf("got %d networks, want 3", len(networks))
+	}
+	_, err = ParseTrustedProxies([]string{"10.0.0.300"})
+	if err == nil {
+		t.Error("invalid address accepted")
+	}
+}
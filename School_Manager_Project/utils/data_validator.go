@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"fmt"
 	"github.com/go-playground/validator/v10"
 	"restapi/internal/models"
+	"time"
 )
 
 var validate = validator.New()
@@ -37,9 +39,68 @@ func ValidateExecPost(newExecs []models.Exec) error {
 	return nil
 }
 
+// RejectExecPasswords refuses passwords in a POST /execs/ body, new execs pick their own
+// password when they accept the invite
+func RejectExecPasswords(newExecs []models.Exec) error {
+	var errs FieldErrors
+	for i, exec := range newExecs {
+		if exec.Password == "" {
+			continue
+		}
+		field := "password"
+		if len(newExecs) > 1 {
+			field = fmt.Sprintf("[%d].password", i)
+		}
+		errs = append(errs, FieldError{Field: field, Code: "not_allowed", Message: "new execs set their password through the invite link"})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
 func ValidateExecPasswordUpdate(data models.UpdatePasswordRequest) error {
 	if data.CurrentPassword == "" || data.NewPassword == "" {
 		return MissingFieldsError
 	}
 	return nil
 }
+
+func ValidateRole(role models.Role) error {
+	err := validate.Struct(role)
+	if err != nil {
+		return MissingFieldsError
+	}
+	return nil
+}
+
+// ValidateAPIKeyRequest checks the request and returns the parsed expiry, nil for keys that don't expire
+func ValidateAPIKeyRequest(data models.APIKeyRequest) (*time.Time, error) {
+	err := validate.Struct(data)
+	if err != nil {
+		return nil, InvalidAPIKeyRequestError
+	}
+	for _, allowed := range data.AllowedIPs {
+		if !ValidIPOrCIDR(allowed) {
+			return nil, InvalidAPIKeyRequestError
+		}
+	}
+	if data.ExpiresAt == "" {
+		return nil, nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, data.ExpiresAt)
+	if err != nil || !expiresAt.After(time.Now()) {
+		return nil, InvalidAPIKeyRequestError
+	}
+	return &expiresAt, nil
+}
+
+func ValidatePasswordReset(data models.ResetPasswordRequest) error {
+	if data.NewPassword == "" || data.ConfirmPassword == "" {
+		return MissingFieldsError
+	}
+	if data.NewPassword != data.ConfirmPassword {
+		return PasswordsDoNotMatchError
+	}
+	return nil
+}
@@ -5,22 +5,22 @@ import (
 	"strings"
 )
 
-func isValidSortField(field string) bool {
-	validFields := map[string]bool{
-		"first_name": true,
-		"last_name":  true,
-		"email":      true,
-		"class":      true,
-		"subject":    true,
+func isFilterField(field string, fields []string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
 	}
-	return validFields[field]
+	return false
 }
 
 func isValidSortOrder(order string) bool {
 	return order == "asc" || order == "desc"
 }
 
-func AddSortFilters(r *http.Request, query string) (string, error) {
+// AddSortFilters orders by every sortby=field:order of the query, fields are the filterable
+// fields of the resource
+func AddSortFilters(r *http.Request, query string, fields []string) (string, error) {
 	sortParams := r.URL.Query()["sortby"]
 	if len(sortParams) > 0 {
 		query += " ORDER BY"
@@ -30,7 +30,7 @@ func AddSortFilters(r *http.Request, query string) (string, error) {
 				return query, InvalidSortParameterError
 			}
 			field, order := parts[0], parts[1]
-			if !isValidSortField(field) || !isValidSortOrder(order) {
+			if !isFilterField(field, fields) || !isValidSortOrder(order) {
 				return query, InvalidSortParameterError
 			}
 			if i > 0 {
@@ -42,19 +42,27 @@ func AddSortFilters(r *http.Request, query string) (string, error) {
 	return query, nil
 }
 
-func AddSearchFilters(r *http.Request, query string, args []interface{}) (string, []interface{}) {
-	params := map[string]string{
-		"first_name": "first_name",
-		"last_name":  "last_name",
-		"email":      "email",
-		"class":      "class",
-		"subject":    "subject",
-	}
-	for param, _ := range params {
-		value := r.URL.Query().Get(param)
-		if value != "" {
-			query += " AND " + param + " = ?"
-			args = append(args, value)
+// AddSearchFilters matches the filterable fields given in the query, a field given more
+// than once matches any of its values
+func AddSearchFilters(r *http.Request, query string, args []interface{}, fields []string) (string, []interface{}) {
+	values := r.URL.Query()
+	for _, field := range fields {
+		var matches []string
+		for _, value := range values[field] {
+			if value != "" {
+				matches = append(matches, value)
+			}
+		}
+		switch len(matches) {
+		case 0:
+		case 1:
+			query += " AND " + field + " = ?"
+			args = append(args, matches[0])
+		default:
+			query += " AND " + field + " IN (?" + strings.Repeat(", ?", len(matches)-1) + ")"
+			for _, value := range matches {
+				args = append(args, value)
+			}
 		}
 	}
 	return query, args
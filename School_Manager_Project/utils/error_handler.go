@@ -123,4 +123,160 @@ var (
 	UserNotAuthorizedError = &AppErrors{
 		errMessage: "user not authorized",
 		statusCode: http.StatusUnauthorized}
+
+	PermissionDeniedError = &AppErrors{
+		errMessage: "permission denied",
+		statusCode: http.StatusForbidden}
+
+	InvalidRoleError = &AppErrors{
+		errMessage: "invalid role",
+		statusCode: http.StatusBadRequest}
+
+	UnknownPermissionError = &AppErrors{
+		errMessage: "unknown permission",
+		statusCode: http.StatusBadRequest}
+
+	DuplicateRoleError = &AppErrors{
+		errMessage: "duplicate role - role name must be unique",
+		statusCode: http.StatusBadRequest}
+
+	RoleInUseError = &AppErrors{
+		errMessage: "role is still assigned to execs",
+		statusCode: http.StatusConflict}
+
+	ErrorGeneratingToken = &AppErrors{
+		errMessage: "error generating token",
+		statusCode: http.StatusInternalServerError}
+
+	InvalidRefreshTokenError = &AppErrors{
+		errMessage: "invalid refresh token",
+		statusCode: http.StatusUnauthorized}
+
+	RefreshTokenReuseError = &AppErrors{
+		errMessage: "refresh token reuse detected - session revoked",
+		statusCode: http.StatusUnauthorized}
+
+	TokenRevokedError = &AppErrors{
+		errMessage: "token has been revoked",
+		statusCode: http.StatusUnauthorized}
+
+	PasswordsDoNotMatchError = &AppErrors{
+		errMessage: "passwords do not match",
+		statusCode: http.StatusBadRequest}
+
+	InvalidResetCodeError = &AppErrors{
+		errMessage: "invalid or expired reset code",
+		statusCode: http.StatusBadRequest}
+
+	ErrorEncryptingSecret = &AppErrors{
+		errMessage: "error processing secret",
+		statusCode: http.StatusInternalServerError}
+
+	MissingEncryptionKeyError = &AppErrors{
+		errMessage: "MFA_ENCRYPTION_KEY is not set",
+		statusCode: http.StatusInternalServerError}
+
+	InvalidMFACodeError = &AppErrors{
+		errMessage: "invalid two-factor code",
+		statusCode: http.StatusUnauthorized}
+
+	InvalidMFAChallengeError = &AppErrors{
+		errMessage: "invalid or expired two-factor challenge",
+		statusCode: http.StatusUnauthorized}
+
+	MFANotEnrolledError = &AppErrors{
+		errMessage: "two-factor authentication is not set up",
+		statusCode: http.StatusBadRequest}
+
+	MFAAlreadyEnabledError = &AppErrors{
+		errMessage: "two-factor authentication is already enabled",
+		statusCode: http.StatusConflict}
+
+	MFARequiredError = &AppErrors{
+		errMessage: "two-factor authentication is required for this role - enroll at /execs/mfa/enroll",
+		statusCode: http.StatusForbidden}
+
+	InvalidCredentialsError = &AppErrors{
+		errMessage: "invalid username or password",
+		statusCode: http.StatusUnauthorized}
+
+	TooManyLoginAttemptsError = &AppErrors{
+		errMessage: "too many failed login attempts, try again later",
+		statusCode: http.StatusTooManyRequests}
+
+	InvalidLimitError = &AppErrors{
+		errMessage: "limit must be between 1 and 500",
+		statusCode: http.StatusBadRequest}
+
+	MissingTokenError = &AppErrors{
+		errMessage: "access token is missing, send it as Authorization: Bearer <token> or in the Bearer cookie",
+		statusCode: http.StatusUnauthorized}
+
+	CSRFTokenError = &AppErrors{
+		errMessage: "missing or invalid CSRF token",
+		statusCode: http.StatusForbidden}
+
+	UntrustedOriginError = &AppErrors{
+		errMessage: "request origin is not trusted",
+		statusCode: http.StatusForbidden}
+
+	InvalidAPIKeyError = &AppErrors{
+		errMessage: "invalid or expired API key",
+		statusCode: http.StatusUnauthorized}
+
+	APIKeyIPNotAllowedError = &AppErrors{
+		errMessage: "API key is not allowed from this address",
+		statusCode: http.StatusForbidden}
+
+	InvalidAPIKeyRequestError = &AppErrors{
+		errMessage: "invalid API key request: name and scopes are required, allowed_ips must be addresses or CIDR ranges and expires_at an RFC 3339 time in the future",
+		statusCode: http.StatusBadRequest}
+
+	SSONotConfiguredError = &AppErrors{
+		errMessage: "single sign-on is not configured",
+		statusCode: http.StatusNotFound}
+
+	SSOProviderError = &AppErrors{
+		errMessage: "identity provider is not reachable",
+		statusCode: http.StatusBadGateway}
+
+	InvalidSSOResponseError = &AppErrors{
+		errMessage: "invalid or expired sign-on response, start the login again",
+		statusCode: http.StatusUnauthorized}
+
+	SSOEmailNotVerifiedError = &AppErrors{
+		errMessage: "the identity provider did not verify the email address",
+		statusCode: http.StatusForbidden}
+
+	SSOAccountNotProvisionedError = &AppErrors{
+		errMessage: "no account is set up for this identity, ask an administrator",
+		statusCode: http.StatusForbidden}
+
+	InvalidInviteError = &AppErrors{
+		errMessage: "invalid or expired invite link, ask an administrator to resend it",
+		statusCode: http.StatusBadRequest}
+
+	InviteAlreadyAcceptedError = &AppErrors{
+		errMessage: "the invite has already been accepted",
+		statusCode: http.StatusConflict}
+
+	RateLimitExceededError = &AppErrors{
+		errMessage: "too many requests, slow down",
+		statusCode: http.StatusTooManyRequests}
+
+	CorsOriginNotAllowedError = &AppErrors{
+		errMessage: "origin is not allowed by CORS",
+		statusCode: http.StatusForbidden}
+
+	DuplicateParameterError = &AppErrors{
+		errMessage: "parameter is given more than once",
+		statusCode: http.StatusBadRequest}
+
+	RequestBodyTooLargeError = &AppErrors{
+		errMessage: "request body is too large",
+		statusCode: http.StatusRequestEntityTooLarge}
+
+	MetricsNotAllowedError = &AppErrors{
+		errMessage: "metrics are not available from this address",
+		statusCode: http.StatusForbidden}
 )
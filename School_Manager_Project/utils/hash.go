@@ -9,40 +9,130 @@ import (
 	"strings"
 )
 
+// Argon2Params are the argon2id cost parameters, stored next to every hash so they can
+// be raised later without breaking existing passwords
+type Argon2Params struct {
+	Memory  uint32 // KiB
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// legacyArgon2Params were used by the old `salt.hash` format that didn't store them
+var legacyArgon2Params = Argon2Params{Memory: 64 * 1024, Time: 1, Threads: 4, SaltLen: 16, KeyLen: 32}
+
+// CurrentArgon2Params reads the hashing policy from ARGON2_MEMORY (KiB), ARGON2_TIME,
+// ARGON2_THREADS and ARGON2_KEY_LENGTH, falling back to the legacy values
+func CurrentArgon2Params() Argon2Params {
+	threads := envInt("ARGON2_THREADS", int(legacyArgon2Params.Threads))
+	if threads > 255 {
+		threads = 255
+	}
+	return Argon2Params{
+		Memory:  uint32(envInt("ARGON2_MEMORY", int(legacyArgon2Params.Memory))),
+		Time:    uint32(envInt("ARGON2_TIME", int(legacyArgon2Params.Time))),
+		Threads: uint8(threads),
+		SaltLen: legacyArgon2Params.SaltLen,
+		KeyLen:  uint32(envInt("ARGON2_KEY_LENGTH", int(legacyArgon2Params.KeyLen))),
+	}
+}
+
+// Hash encodes the password in the PHC string format:
+// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>
 func Hash(password string) (string, error) {
-	salt := make([]byte, 16)
+	params := CurrentArgon2Params()
+	salt := make([]byte, params.SaltLen)
 	_, err := rand.Read(salt)
 	if err != nil {
 		return "", ErrorGeneratingSaltForHashing
 	}
 
-	hash := argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)
-	saltBase64 := base64.StdEncoding.EncodeToString(salt)
-	hashBase64 := base64.StdEncoding.EncodeToString(hash)
+	hash := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+	saltBase64 := base64.RawStdEncoding.EncodeToString(salt)
+	hashBase64 := base64.RawStdEncoding.EncodeToString(hash)
 
-	encodedHash := fmt.Sprintf("%s.%s", saltBase64, hashBase64)
+	encodedHash := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Time, params.Threads, saltBase64, hashBase64)
 	return encodedHash, nil
 }
 
+// VerifyPassword accepts PHC encoded hashes as well as the legacy `salt.hash` format
 func VerifyPassword(passwordFromDB, providedPassword string) (bool, error) {
-	parts := strings.Split(passwordFromDB, ".")
-	if len(parts) != 2 {
-		return false, InvalidEncodedHashFormat
-	}
-	saltBase64 := parts[0]
-	hashedPasswordBase64 := parts[1]
-	salt, err := base64.StdEncoding.DecodeString(saltBase64)
+	params, salt, hashedPassword, err := decodeHash(passwordFromDB)
 	if err != nil {
-		return false, FailedToDecodeSalt
+		return false, err
 	}
-	hashedPassword, err := base64.StdEncoding.DecodeString(hashedPasswordBase64)
-	if err != nil {
-		return false, FailedToDecodeHashError
-	}
-	hash := argon2.IDKey([]byte(providedPassword), salt, 1, 64*1024, 4, 32)
+	hash := argon2.IDKey([]byte(providedPassword), salt, params.Time, params.Memory, params.Threads, uint32(len(hashedPassword)))
 
 	if len(hash) != len(hashedPassword) || subtle.ConstantTimeCompare(hash, hashedPassword) != 1 {
 		return false, IncorrectPasswordError
 	}
 	return true, nil
 }
+
+// NeedsRehash reports whether the stored hash uses the legacy format or weaker
+// parameters than the current policy
+func NeedsRehash(passwordFromDB string) bool {
+	if !strings.HasPrefix(passwordFromDB, "$argon2id$") {
+		return true
+	}
+	params, _, hash, err := decodeHash(passwordFromDB)
+	if err != nil {
+		return true
+	}
+	current := CurrentArgon2Params()
+	return params.Memory < current.Memory ||
+		params.Time < current.Time ||
+		params.Threads < current.Threads ||
+		uint32(len(hash)) < current.KeyLen
+}
+
+func decodeHash(encodedHash string) (Argon2Params, []byte, []byte, error) {
+	if !strings.HasPrefix(encodedHash, "$") {
+		return decodeLegacyHash(encodedHash)
+	}
+
+	// "", "argon2id", "v=19", "m=65536,t=1,p=4", salt, hash
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2Params{}, nil, nil, InvalidEncodedHashFormat
+	}
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, InvalidEncodedHashFormat
+	}
+	var params Argon2Params
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil || params.Memory == 0 || params.Time == 0 || params.Threads == 0 {
+		return Argon2Params{}, nil, nil, InvalidEncodedHashFormat
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, FailedToDecodeSalt
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(hash) == 0 {
+		return Argon2Params{}, nil, nil, FailedToDecodeHashError
+	}
+	params.SaltLen = uint32(len(salt))
+	params.KeyLen = uint32(len(hash))
+	return params, salt, hash, nil
+}
+
+func decodeLegacyHash(encodedHash string) (Argon2Params, []byte, []byte, error) {
+	parts := strings.Split(encodedHash, ".")
+	if len(parts) != 2 {
+		return Argon2Params{}, nil, nil, InvalidEncodedHashFormat
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Argon2Params{}, nil, nil, FailedToDecodeSalt
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Argon2Params{}, nil, nil, FailedToDecodeHashError
+	}
+	return legacyArgon2Params, salt, hash, nil
+}
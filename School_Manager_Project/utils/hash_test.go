@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"encoding/base64"
+	"golang.org/x/crypto/argon2"
+	"strings"
+	"testing"
+)
+
+// cheapArgon2 keeps the hashes of the tests fast
+func cheapArgon2(t *testing.T) {
+	t.Setenv("ARGON2_MEMORY", "1024")
+	t.Setenv("ARGON2_TIME", "1")
+	t.Setenv("ARGON2_THREADS", "1")
+	t.Setenv("ARGON2_KEY_LENGTH", "32")
+}
+
+func TestHashRoundTrip(t *testing.T) {
+	cheapArgon2(t)
+	encoded, err := Hash("Tulip-harbor-93")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("Hash() = %s, want the PHC format with the current parameters", encoded)
+	}
+	ok, err := VerifyPassword(encoded, "Tulip-harbor-93")
+	if !ok || err != nil {
+		t.Fatalf("VerifyPassword() = %v, %v for the right password", ok, err)
+	}
+	ok, err = VerifyPassword(encoded, "tulip-harbor-93")
+	if ok || err != IncorrectPasswordError {
+		t.Fatalf("VerifyPassword() = %v, %v for a wrong password", ok, err)
+	}
+
+	again, _ := Hash("Tulip-harbor-93")
+	if again == encoded {
+		t.Error("two hashes of the same password share a salt")
+	}
+}
+
+func TestVerifyPasswordStoredParams(t *testing.T) {
+	// a hash keeps verifying with its own parameters after the policy changed
+	cheapArgon2(t)
+	encoded, err := Hash("Tulip-harbor-93")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ARGON2_TIME", "2")
+	t.Setenv("ARGON2_KEY_LENGTH", "16")
+	ok, err := VerifyPassword(encoded, "Tulip-harbor-93")
+	if !ok || err != nil {
+		t.Fatalf("VerifyPassword() = %v, %v after the parameters changed", ok, err)
+	}
+}
+
+func TestVerifyPasswordLegacy(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	p := legacyArgon2Params
+	hash := argon2.IDKey([]byte("Tulip-harbor-93"), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	legacy := base64.StdEncoding.EncodeToString(salt) + "." + base64.StdEncoding.EncodeToString(hash)
+
+	ok, err := VerifyPassword(legacy, "Tulip-harbor-93")
+	if !ok || err != nil {
+		t.Fatalf("VerifyPassword() = %v, %v for a legacy hash", ok, err)
+	}
+	ok, err = VerifyPassword(legacy, "Tulip-harbor-94")
+	if ok || err != IncorrectPasswordError {
+		t.Fatalf("VerifyPassword() = %v, %v for a wrong password against a legacy hash", ok, err)
+	}
+	if !NeedsRehash(legacy) {
+		t.Error("legacy hash not marked for rehashing")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	cheapArgon2(t)
+	encoded, err := Hash("Tulip-harbor-93")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NeedsRehash(encoded) {
+		t.Error("hash with the current parameters marked for rehashing")
+	}
+	for _, env := range []struct{ name, value string }{
+		{"ARGON2_MEMORY", "2048"},
+		{"ARGON2_TIME", "2"},
+		{"ARGON2_THREADS", "2"},
+		{"ARGON2_KEY_LENGTH", "64"},
+	} {
+		t.Run(env.name, func(t *testing.T) {
+			t.Setenv(env.name, env.value)
+			if !NeedsRehash(encoded) {
+				t.Errorf("hash not marked for rehashing after %s was raised", env.name)
+			}
+		})
+	}
+	t.Setenv("ARGON2_MEMORY", "512")
+	if NeedsRehash(encoded) {
+		t.Error("hash marked for rehashing after the memory was lowered")
+	}
+}
+
+func TestVerifyPasswordMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		want    error
+	}{
+		{name: "other algorithm", encoded: "$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$aGFzaA", want: InvalidEncodedHashFormat},
+		{name: "other version", encoded: "$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$aGFzaA", want: InvalidEncodedHashFormat},
+		{name: "zero parameters", encoded: "$argon2id$v=19$m=0,t=1,p=1$c2FsdA$aGFzaA", want: InvalidEncodedHashFormat},
+		{name: "missing part", encoded: "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA", want: InvalidEncodedHashFormat},
+		{name: "bad salt", encoded: "$argon2id$v=19$m=1024,t=1,p=1$!!$aGFzaA", want: FailedToDecodeSalt},
+		{name: "empty hash", encoded: "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$", want: FailedToDecodeHashError},
+		{name: "legacy without separator", encoded: "c2FsdA==", want: InvalidEncodedHashFormat},
+		{name: "legacy bad salt", encoded: "!!.aGFzaA==", want: FailedToDecodeSalt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := VerifyPassword(tt.encoded, "Tulip-harbor-93")
+			if ok || err != tt.want {
+				t.Errorf("VerifyPassword() = %v, %v, want %v", ok, err, tt.want)
+			}
+			if !NeedsRehash(tt.encoded) {
+				t.Error("malformed hash not marked for rehashing")
+			}
+		})
+	}
+}
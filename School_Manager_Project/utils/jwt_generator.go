@@ -3,32 +3,137 @@ package utils
 import (
 	"github.com/golang-jwt/jwt/v5"
 	"os"
+	"restapi/internal/models"
 	"time"
 )
 
-func SignToken(userId int, username, role string) (string, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
+// AccessTokenTTL is the lifetime of the JWT and of the cookie carrying it
+func AccessTokenTTL() (time.Duration, error) {
 	jwtExpiresIn := os.Getenv("JWT_EXPIRES_IN")
+	if jwtExpiresIn == "" {
+		return 15 * time.Minute, nil
+	}
+	duration, err := time.ParseDuration(jwtExpiresIn)
+	if err != nil {
+		return 0, ErrorGeneratingJwtToken
+	}
+	return duration, nil
+}
 
+// AccessTokenClaims are the claims of an access token for the user, signed by keyring.Sign.
+// familyID links it to the refresh token family of the login, "ver" has to match
+// execs.token_version for the token to be accepted.
+func AccessTokenClaims(user models.Exec, familyID string) (jwt.MapClaims, error) {
+	jti, err := GenerateRandomToken(16)
+	if err != nil {
+		return nil, ErrorGeneratingJwtToken
+	}
 	claims := jwt.MapClaims{
-		"uid":  userId,
-		"user": username,
-		"role": role,
-	}
-	if jwtExpiresIn != "" {
-		duration, err := time.ParseDuration(jwtExpiresIn)
-		if err != nil {
-			return "", ErrorGeneratingJwtToken
-		}
-		claims["exp"] = jwt.NewNumericDate(time.Now().Add(duration))
-	} else {
-		claims["exp"] = jwt.NewNumericDate(time.Now().Add(15 * time.Minute))
+		"uid":  user.ID,
+		"user": user.Username,
+		"role": user.Role,
+		"ver":  user.TokenVersion,
+		"fid":  familyID,
+		"jti":  jti,
+		"iat":  jwt.NewNumericDate(time.Now()),
+		"mfa":  user.MfaEnabled,
+	}
+	duration, err := AccessTokenTTL()
+	if err != nil {
+		return nil, err
+	}
+	claims["exp"] = jwt.NewNumericDate(time.Now().Add(duration))
+	return claims, nil
+}
+
+const MFAChallengeTTL = 5 * time.Minute
+
+// the challenge is signed with its own key so it can never be used as an access token
+func mfaChallengeKey() ([]byte, error) {
+	return derivedKey("mfa-challenge")
+}
+
+// SignMFAChallenge issues the token returned by a password login when a second factor is needed
+func SignMFAChallenge(userId int) (string, error) {
+	claims := jwt.MapClaims{
+		"uid": userId,
+		"exp": jwt.NewNumericDate(time.Now().Add(MFAChallengeTTL)),
+	}
+	key, err := mfaChallengeKey()
+	if err != nil {
+		return "", err
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signedToken, err := token.SignedString(key)
+	if err != nil {
+		return "", ErrorGeneratingJwtToken
+	}
+	return signedToken, nil
+}
 
-	signedToken, err := token.SignedString([]byte(jwtSecret))
+func ParseMFAChallenge(challenge string) (int, error) {
+	parsedToken, err := jwt.Parse(challenge, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, UnexpectedSigningMethodError
+		}
+		return mfaChallengeKey()
+	})
+	if err != nil || !parsedToken.Valid {
+		return 0, InvalidMFAChallengeError
+	}
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, InvalidMFAChallengeError
+	}
+	uid, ok := claims["uid"].(float64)
+	if !ok {
+		return 0, InvalidMFAChallengeError
+	}
+	return int(uid), nil
+}
+
+func inviteKey() ([]byte, error) {
+	return derivedKey("exec-invite")
+}
+
+// SignInviteToken signs the link mailed to an invited exec. The nonce is stored hashed with
+// the invite, so resending or revoking it invalidates links that haven't expired yet.
+func SignInviteToken(execID int, nonce string, expiresAt time.Time) (string, error) {
+	claims := jwt.MapClaims{
+		"uid":   execID,
+		"nonce": nonce,
+		"exp":   jwt.NewNumericDate(expiresAt),
+	}
+	key, err := inviteKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signedToken, err := token.SignedString(key)
 	if err != nil {
 		return "", ErrorGeneratingJwtToken
 	}
 	return signedToken, nil
 }
+
+func ParseInviteToken(invite string) (int, string, error) {
+	parsedToken, err := jwt.Parse(invite, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, UnexpectedSigningMethodError
+		}
+		return inviteKey()
+	})
+	if err != nil || !parsedToken.Valid {
+		return 0, "", InvalidInviteError
+	}
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, "", InvalidInviteError
+	}
+	uid, ok := claims["uid"].(float64)
+	nonce, _ := claims["nonce"].(string)
+	if !ok || nonce == "" {
+		return 0, "", InvalidInviteError
+	}
+	return int(uid), nonce, nil
+}
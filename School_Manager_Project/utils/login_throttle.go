@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// LoginMaxAttempts is how many failed logins a username may have before it is locked
+func LoginMaxAttempts() int {
+	return envInt("LOGIN_MAX_ATTEMPTS", 5)
+}
+
+// LoginMaxAttemptsPerIP is how many failed logins a single client may have before it is locked
+func LoginMaxAttemptsPerIP() int {
+	return envInt("LOGIN_MAX_ATTEMPTS_PER_IP", 20)
+}
+
+// LoginFailureWindow is how long a failed attempt is remembered
+func LoginFailureWindow() time.Duration {
+	return envDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute)
+}
+
+// LockoutDuration doubles the lockout for every failure past the limit, starting at
+// LOGIN_LOCKOUT_BASE and capped at LOGIN_LOCKOUT_MAX. Zero means no lockout yet.
+func LockoutDuration(failures, limit int) time.Duration {
+	if failures < limit {
+		return 0
+	}
+	base := envDuration("LOGIN_LOCKOUT_BASE", time.Minute)
+	max := envDuration("LOGIN_LOCKOUT_MAX", time.Hour)
+	duration := base
+	for i := limit; i < failures && duration < max; i++ {
+		duration *= 2
+	}
+	if duration > max {
+		return max
+	}
+	return duration
+}
+
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	duration, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || duration <= 0 {
+		return fallback
+	}
+	return duration
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FieldError describes why a single field of the request was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// FieldErrors is returned when a request breaks one or more validation rules.
+// Handlers send it back as JSON so clients can show the message next to the field.
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldErr := range e {
+		messages[i] = fieldErr.Field + ": " + fieldErr.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e FieldErrors) GetStatusCode() int {
+	return http.StatusUnprocessableEntity
+}
+
+// PasswordMinLength is the minimum number of characters of a password
+func PasswordMinLength() int {
+	return envInt("PASSWORD_MIN_LENGTH", 12)
+}
+
+// PasswordMinClasses is how many of lowercase, uppercase, digits and symbols a password has to mix
+func PasswordMinClasses() int {
+	return envInt("PASSWORD_MIN_CLASSES", 3)
+}
+
+// PasswordHistorySize is how many previous passwords can't be used again
+func PasswordHistorySize() int {
+	return envInt("PASSWORD_HISTORY", 5)
+}
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+var (
+	commonPasswordsOnce sync.Once
+	commonPasswords     map[string]struct{}
+)
+
+func isCommonPassword(password string) bool {
+	commonPasswordsOnce.Do(func() {
+		commonPasswords = make(map[string]struct{})
+		scanner := bufio.NewScanner(strings.NewReader(commonPasswordsFile))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				commonPasswords[strings.ToLower(line)] = struct{}{}
+			}
+		}
+	})
+	_, found := commonPasswords[strings.ToLower(password)]
+	return found
+}
+
+// CheckPassword applies the password policy to a new password. personal holds values
+// like the username or email that the password must not contain.
+// Reuse of older passwords is checked by the database handlers.
+func CheckPassword(field, password string, personal ...string) FieldErrors {
+	var errs FieldErrors
+	if minLength := PasswordMinLength(); utf8.RuneCountInString(password) < minLength {
+		errs = append(errs, FieldError{
+			Field:   field,
+			Code:    "too_short",
+			Message: fmt.Sprintf("must be at least %d characters long", minLength),
+		})
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			classes++
+		}
+	}
+	if minClasses := PasswordMinClasses(); classes < minClasses {
+		errs = append(errs, FieldError{
+			Field:   field,
+			Code:    "too_simple",
+			Message: fmt.Sprintf("must mix at least %d of lowercase letters, uppercase letters, digits and symbols", minClasses),
+		})
+	}
+
+	if isCommonPassword(password) {
+		errs = append(errs, FieldError{
+			Field:   field,
+			Code:    "common",
+			Message: "is too common, it appears in lists of breached passwords",
+		})
+	}
+
+	lowered := strings.ToLower(password)
+	for _, value := range personal {
+		if at := strings.Index(value, "@"); at >= 0 {
+			value = value[:at]
+		}
+		if len(value) >= 3 && strings.Contains(lowered, strings.ToLower(value)) {
+			errs = append(errs, FieldError{
+				Field:   field,
+				Code:    "personal",
+				Message: "must not contain your name, username or email",
+			})
+			break
+		}
+	}
+	return errs
+}
+
+// PasswordReusedError is returned when the new password matches one of the last ones
+func PasswordReusedError(field string) FieldErrors {
+	return FieldErrors{{
+		Field:   field,
+		Code:    "reused",
+		Message: fmt.Sprintf("must not be one of your last %d passwords", PasswordHistorySize()),
+	}}
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func errorCodes(errs FieldErrors) []string {
+	codes := make([]string, len(errs))
+	for i, fieldErr := range errs {
+		codes[i] = fieldErr.Code
+	}
+	return codes
+}
+
+func TestCheckPasswordCommon(t *testing.T) {
+	// long enough and mixing classes, only the breach list refuses it
+	errs := CheckPassword("password", "Qwerty123456")
+	if len(errs) != 1 || errs[0].Code != "common" {
+		t.Fatalf("CheckPassword(Qwerty123456) = %v, want only common", errorCodes(errs))
+	}
+	errs = CheckPassword("password", "Tulip-harbor-93")
+	if len(errs) != 0 {
+		t.Fatalf("CheckPassword(Tulip-harbor-93) = %v, want accepted", errorCodes(errs))
+	}
+}
+
+func TestCommonPasswordsPassTheOtherRules(t *testing.T) {
+	// an entry the length and class rules refuse anyway is dead weight
+	scanner := bufio.NewScanner(strings.NewReader(commonPasswordsFile))
+	count := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		count++
+		// users meet the class rule by capitalizing, the list is compared case-insensitively
+		capitalized := line
+		if i := strings.IndexFunc(line, unicode.IsLower); i >= 0 {
+			capitalized = line[:i] + strings.ToUpper(line[i:i+1]) + line[i+1:]
+		}
+		errs := CheckPassword("password", capitalized)
+		if len(errs) != 1 || errs[0].Code != "common" {
+			t.Errorf("CheckPassword(%q) = %v, want only common", capitalized, errorCodes(errs))
+		}
+	}
+	if count == 0 {
+		t.Fatal("no common passwords embedded")
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		personal []string
+		want     []string
+	}{
+		{name: "accepted", password: "Tulip-harbor-93", want: []string{}},
+		{name: "too short", password: "Ab1!", want: []string{"too_short"}},
+		{name: "too simple", password: "tulipharborgarden", want: []string{"too_simple"}},
+		{name: "contains the username", password: "Jsmith-harbor-93", personal: []string{"jsmith"}, want: []string{"personal"}},
+		{name: "contains the local part of the email", password: "Harbor-93-anna.k", personal: []string{"anna.k@school.example"}, want: []string{"personal"}},
+		{name: "short personal values ignored", password: "Tulip-harbor-93", personal: []string{"ha"}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := errorCodes(CheckPassword("password", tt.password, tt.personal...))
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("CheckPassword(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+)
+
+// CheckSecrets is run at startup. MFA_ENCRYPTION_KEY has no fallback: secrets encrypted or
+// challenges signed with an empty key could be read or forged by anybody.
+func CheckSecrets() error {
+	if os.Getenv("MFA_ENCRYPTION_KEY") == "" {
+		return MissingEncryptionKeyError
+	}
+	return nil
+}
+
+// secrets that have to be read back, like TOTP seeds, are encrypted with MFA_ENCRYPTION_KEY
+func secretBoxKey() ([]byte, error) {
+	key := os.Getenv("MFA_ENCRYPTION_KEY")
+	if key == "" {
+		return nil, MissingEncryptionKeyError
+	}
+	sum := sha256.Sum256([]byte(key))
+	return sum[:], nil
+}
+
+// derivedKey gives each kind of token signed from MFA_ENCRYPTION_KEY its own key, so one can't
+// be passed off as another
+func derivedKey(purpose string) ([]byte, error) {
+	key := os.Getenv("MFA_ENCRYPTION_KEY")
+	if key == "" {
+		return nil, MissingEncryptionKeyError
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil), nil
+}
+
+func EncryptSecret(plaintext string) (string, error) {
+	key, err := secretBoxKey()
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", ErrorEncryptingSecret
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", ErrorEncryptingSecret
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", ErrorEncryptingSecret
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func DecryptSecret(encoded string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrorEncryptingSecret
+	}
+	key, err := secretBoxKey()
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", ErrorEncryptingSecret
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", ErrorEncryptingSecret
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", ErrorEncryptingSecret
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrorEncryptingSecret
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"testing"
+	"time"
+)
+
+func TestSecretsRequireEncryptionKey(t *testing.T) {
+	t.Setenv("MFA_ENCRYPTION_KEY", "")
+	t.Setenv("JWT_SECRET", "jwt-secret")
+	if err := CheckSecrets(); err != MissingEncryptionKeyError {
+		t.Errorf("CheckSecrets() = %v, want MissingEncryptionKeyError", err)
+	}
+	if _, err := EncryptSecret("seed"); err == nil {
+		t.Error("EncryptSecret() worked without MFA_ENCRYPTION_KEY")
+	}
+	if _, err := SignMFAChallenge(1); err == nil {
+		t.Error("SignMFAChallenge() worked without MFA_ENCRYPTION_KEY")
+	}
+	// a challenge signed with the old JWT_SECRET derived key isn't accepted either
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"uid": 1, "exp": jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}).SignedString([]byte(":mfa-challenge"))
+	if _, err := ParseMFAChallenge(forged); err == nil {
+		t.Error("challenge signed with an empty secret accepted")
+	}
+}
+
+func TestSecretBox(t *testing.T) {
+	t.Setenv("MFA_ENCRYPTION_KEY", "encryption-key")
+	encrypted, err := EncryptSecret("seed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := DecryptSecret(encrypted); err != nil || got != "seed" {
+		t.Errorf("DecryptSecret() = %q, %v", got, err)
+	}
+	t.Setenv("MFA_ENCRYPTION_KEY", "other-key")
+	if _, err := DecryptSecret(encrypted); err == nil {
+		t.Error("secret decrypted with another key")
+	}
+}
+
+func TestSignedTokenKeys(t *testing.T) {
+	t.Setenv("MFA_ENCRYPTION_KEY", "encryption-key")
+	challenge, err := SignMFAChallenge(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid, err := ParseMFAChallenge(challenge); err != nil || uid != 7 {
+		t.Errorf("ParseMFAChallenge() = %d, %v", uid, err)
+	}
+	// the challenge and invite keys are separate
+	if _, _, err := ParseInviteToken(challenge); err == nil {
+		t.Error("MFA challenge accepted as an invite")
+	}
+	invite, err := SignInviteToken(7, "nonce", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseMFAChallenge(invite); err == nil {
+		t.Error("invite accepted as an MFA challenge")
+	}
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// AccessCookieName is the HttpOnly cookie browsers send the access token in
+	AccessCookieName = "Bearer"
+	// RefreshCookieName is only sent to /auth
+	RefreshCookieName = "RefreshToken"
+	// CSRFCookieName and CSRFHeaderName implement the double-submit check of cookie sessions
+	CSRFCookieName = "csrf_token"
+	CSRFHeaderName = "X-CSRF-Token"
+	// DeviceCookieName identifies a browser across logins, see exec sessions
+	DeviceCookieName = "device_id"
+	// MFAChallengeCookieName carries the challenge of a browser SSO login to /execs/login/mfa
+	MFAChallengeCookieName = "mfa_challenge"
+)
+
+// APIKeyPrefix starts every API key so it can't be mistaken for a JWT
+const APIKeyPrefix = "smk_"
+
+// GenerateAPIKey returns a new key "smk_<prefix>_<secret>" and its prefix
+func GenerateAPIKey() (string, string, error) {
+	prefixBytes := make([]byte, 6)
+	_, err := rand.Read(prefixBytes)
+	if err != nil {
+		return "", "", ErrorGeneratingToken
+	}
+	prefix := hex.EncodeToString(prefixBytes)
+	secret, err := GenerateRandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	return APIKeyPrefix + prefix + "_" + secret, prefix, nil
+}
+
+// ParseAPIKeyPrefix returns the prefix of a key in the format made by GenerateAPIKey
+func ParseAPIKeyPrefix(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, APIKeyPrefix)
+	if !ok || len(rest) < 14 || rest[12] != '_' {
+		return "", false
+	}
+	return rest[:12], true
+}
+
+// RefreshTokenTTL is how long a refresh token can be exchanged for a new access token
+func RefreshTokenTTL() time.Duration {
+	duration, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_EXPIRES_IN"))
+	if err != nil || duration <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return duration
+}
+
+// GenerateRandomToken returns a url safe random string of n bytes
+func GenerateRandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", ErrorGeneratingToken
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken is used for high entropy tokens that have to be looked up by value,
+// passwords keep going through Hash
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// PasswordResetTTL is how long a reset code sent by email stays valid
+func PasswordResetTTL() time.Duration {
+	duration, err := time.ParseDuration(os.Getenv("RESET_TOKEN_EXPIRES_IN"))
+	if err != nil || duration <= 0 {
+		return 10 * time.Minute
+	}
+	return duration
+}
+
+// InviteTTL is how long the link mailed to a new exec can be used to pick a password
+func InviteTTL() time.Duration {
+	duration, err := time.ParseDuration(os.Getenv("INVITE_EXPIRES_IN"))
+	if err != nil || duration <= 0 {
+		return 72 * time.Hour
+	}
+	return duration
+}
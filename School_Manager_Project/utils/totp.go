@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// codes of the previous and the next period are accepted too, to allow for clock drift
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", ErrorGeneratingToken
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPURI builds the otpauth:// URI authenticator apps read from a QR code
+func TOTPURI(issuer, account, secret string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", issuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(totpDigits))
+	values.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// ValidateTOTP checks the code against the secret and returns the time step it matched,
+// so callers can refuse a code that was already used
+func ValidateTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected := totpCode(key, step)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%uint32(math.Pow10(totpDigits)))
+}
+
+// GenerateRecoveryCodes returns n single-use codes formatted as xxxxx-xxxxx
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 7)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, ErrorGeneratingToken
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
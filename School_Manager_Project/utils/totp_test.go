@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA1 secret of the RFC 6238 test vectors, "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	key, err := totpEncoding.DecodeString(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RFC 6238 appendix B, last 6 of the 8 digits
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range vectors {
+		if got := totpCode(key, unix/totpPeriod); got != want {
+			t.Errorf("totpCode at %d = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+	key, _ := totpEncoding.DecodeString(rfcSecret)
+	codeAt := func(offset int64) string {
+		return totpCode(key, step+offset)
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "current step", secret: rfcSecret, code: codeAt(0), wantStep: step, wantOK: true},
+		{name: "previous step, clock behind", secret: rfcSecret, code: codeAt(-1), wantStep: step - 1, wantOK: true},
+		{name: "next step, clock ahead", secret: rfcSecret, code: codeAt(1), wantStep: step + 1, wantOK: true},
+		{name: "two steps old", secret: rfcSecret, code: codeAt(-2)},
+		{name: "two steps ahead", secret: rfcSecret, code: codeAt(2)},
+		{name: "lowercase secret with spaces", secret: " " + strings.ToLower(rfcSecret) + " ", code: codeAt(0), wantStep: step, wantOK: true},
+		{name: "wrong code", secret: rfcSecret, code: "000000"},
+		{name: "too short", secret: rfcSecret, code: codeAt(0)[:5]},
+		{name: "too long", secret: rfcSecret, code: codeAt(0) + "1"},
+		{name: "invalid secret", secret: "not base32!", code: codeAt(0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := ValidateTOTP(tt.secret, tt.code, now)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("ValidateTOTP() = %d, %v, want %d, %v", gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("secret %q decodes to %d bytes (%v), want 20", secret, len(key), err)
+	}
+	now := time.Now()
+	if _, ok := ValidateTOTP(secret, totpCode(key, now.Unix()/totpPeriod), now); !ok {
+		t.Error("code of a generated secret refused")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTPURI("School Manager", "jsmith", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/School Manager:jsmith" {
+		t.Errorf("TOTPURI() = %s", uri)
+	}
+	query := uri.Query()
+	if query.Get("secret") != rfcSecret || query.Get("issuer") != "School Manager" ||
+		query.Get("digits") != "6" || query.Get("period") != "30" || query.Get("algorithm") != "SHA1" {
+		t.Errorf("TOTPURI() query = %v", query)
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || strings.ToLower(code) != code {
+			t.Errorf("recovery code %q is not xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("recovery code %q repeated", code)
+		}
+		seen[code] = true
+	}
+}